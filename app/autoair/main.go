@@ -10,6 +10,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/shanghuiyang/rpi-devices/dev"
@@ -19,12 +20,25 @@ import (
 )
 
 const (
+	// cloudName is the cloud of the values in the config file of the clouds
+	cloudName = "onenet"
+	// mqttName is the mqtt cloud of Home Assistant in the config file of the clouds
+	mqttName = "mqtt"
+	// pollerName is the command poller in the config file of the clouds, it's optional
+	pollerName = "poller"
+	// queueFile persists the values which haven't been pushed to the cloud
+	queueFile = "autoair_queue.json"
+
 	pinSG = 18
 )
 
 const (
 	trigOnPM25  = 120
 	trigOffPm25 = 100
+
+	// manualHold is how long the air-cleaner keeps the state set by a command or home assistant,
+	// it's turned on/off by the pm2.5 again after that, or after the "auto" command.
+	manualHold = 2 * time.Hour
 )
 
 var (
@@ -43,9 +57,15 @@ type pm25Response struct {
 type autoAir struct {
 	sg      *dev.SG90
 	cloud   iot.Cloud
-	state   bool        // true: turn on, false: turn off
-	chClean chan uint16 // for turning on/off the air-cleaner
-	chCloud chan uint16 // for pushing to iot cloud
+	hass    *iot.HomeAssistant // nil if home assistant is disabled
+	chClean chan uint16        // for turning on/off the air-cleaner
+	chCloud chan uint16        // for pushing to iot cloud
+
+	// mu guards the state and the servo, which are used by clean() and the commands concurrently
+	mu    sync.Mutex
+	state bool // true: turn on, false: turn off
+	// manualUntil is the end of the manual mode set by a command
+	manualUntil time.Time
 }
 
 func main() {
@@ -56,25 +76,64 @@ func main() {
 	defer rpio.Close()
 
 	sg := dev.NewSG90(pinSG)
-	onenetCfg := &iot.OneNetConfig{
-		Token: iot.OneNetToken,
-		API:   iot.OneNetAPI,
+	clouds, err := iot.LoadClouds("")
+	if err != nil {
+		log.Printf("[autoair]failed to load the clouds, error: %v", err)
+		return
+	}
+	cloud, err := clouds.Get(cloudName)
+	if err != nil {
+		log.Printf("[autoair]failed to get the cloud, error: %v", err)
+		return
+	}
+	rcloud, err := iot.NewReliableCloud(cloud, &iot.ReliableConfig{File: queueFile})
+	if err != nil {
+		log.Printf("[autoair]failed to load the queue of the cloud, error: %v", err)
+		return
 	}
-	cloud := iot.NewCloud(onenetCfg)
 
-	autoair = newAutoAir(sg, cloud)
+	// home assistant is optional, the air-cleaner is controlled by the pm2.5 without it
+	var hass *iot.HomeAssistant
+	mqtt, err := clouds.MQTT(mqttName)
+	if err != nil {
+		log.Printf("[autoair]failed to get the mqtt cloud, home assistant is disabled, error: %v", err)
+	} else {
+		hass = iot.NewHomeAssistant(mqtt, "Auto-Air")
+		hass.AddSensor(&iot.HASensor{ID: "pm2.5", Name: "PM2.5", Unit: "µg/m³", DeviceClass: "pm25"})
+	}
+
+	autoair = newAutoAir(sg, rcloud, hass)
+	if hass != nil {
+		hass.AddSwitch(&iot.HASwitch{
+			ID:   "air-cleaner",
+			Name: "Air Cleaner",
+			Icon: "mdi:air-purifier",
+			On:   autoair.on,
+			Off:  autoair.off,
+		})
+		if err := mqtt.SubscribeCommands("air-cleaner", autoair.command); err != nil {
+			log.Printf("[autoair]failed to subscribe the commands, error: %v", err)
+		}
+	}
+	if poller, err := clouds.Commander(pollerName); err != nil {
+		log.Printf("[autoair]no command poller, error: %v", err)
+	} else if err := poller.SubscribeCommands("air-cleaner", autoair.command); err != nil {
+		log.Printf("[autoair]failed to subscribe the commands of the poller, error: %v", err)
+	}
 	util.WaitQuit(func() {
+		rcloud.Close()
+		clouds.Close()
 		autoair.stop()
 		rpio.Close()
 	})
 	autoair.start()
 }
 
-func newAutoAir(sg *dev.SG90, cloud iot.Cloud) *autoAir {
+func newAutoAir(sg *dev.SG90, cloud iot.Cloud, hass *iot.HomeAssistant) *autoAir {
 	return &autoAir{
 		sg:      sg,
 		cloud:   cloud,
-		state:   false,
+		hass:    hass,
 		chClean: make(chan uint16, 4),
 		chCloud: make(chan uint16, 4),
 	}
@@ -98,6 +157,11 @@ func (a *autoAir) detect() {
 			continue
 		}
 		log.Printf("[autoair]pm2.5: %v ug/m3", pm25)
+		if a.hass != nil {
+			if err := a.hass.Push(&iot.Value{Device: "pm2.5", Value: pm25}); err != nil {
+				log.Printf("[autoair]failed to push pm2.5 to home assistant, error: %v", err)
+			}
+		}
 
 		a.chClean <- pm25
 		time.Sleep(60 * time.Second)
@@ -110,19 +174,15 @@ func (a *autoAir) clean() {
 		if pm25 < 400 && (hour >= 20 || hour < 8) {
 			// disable at 20:00-08:00
 			log.Printf("[autoair]auto air-cleaner was disabled at 20:00-08:00")
-			if a.state {
-				a.off()
-			}
+			a.turn(false, false)
 			continue
 		}
 
-		if !a.state && pm25 >= trigOnPM25 {
-			a.on()
+		if pm25 >= trigOnPM25 && a.turn(true, false) {
 			log.Printf("[autoair]air-cleaner was turned on")
 			continue
 		}
-		if a.state && pm25 < trigOffPm25 {
-			a.off()
+		if pm25 < trigOffPm25 && a.turn(false, false) {
 			log.Printf("[autoair]air-cleaner was turned off")
 			continue
 		}
@@ -135,7 +195,7 @@ func (a *autoAir) push() {
 		time.Sleep(60 * time.Second)
 		v := &iot.Value{
 			Device: "air-cleaner",
-			Value:  bool2int[a.state],
+			Value:  bool2int[a.isOn()],
 		}
 		if err := a.cloud.Push(v); err != nil {
 			log.Printf("[autoair]push: failed to push the state of air-cleaner to cloud, error: %v", err)
@@ -167,20 +227,89 @@ func (a *autoAir) getPM25() (uint16, error) {
 	return pm25Resp.PM25, nil
 }
 
+// on turns on the air-cleaner in the manual mode, it's called by the commands and home assistant
 func (a *autoAir) on() {
-	a.sg.Roll(0)
-	time.Sleep(1 * time.Second)
-	a.sg.Roll(-45)
-	a.state = true
+	a.turn(true, true)
 }
 
+// off turns off the air-cleaner in the manual mode, it's called by the commands and home assistant
 func (a *autoAir) off() {
-	a.sg.Roll(0)
-	time.Sleep(1 * time.Second)
-	a.sg.Roll(45)
-	a.state = false
+	a.turn(false, true)
+}
+
+// turn turns on/off the air-cleaner, and returns true if the state is changed.
+// a manual turn holds the state for manualHold, and the automatic turns are ignored within it.
+func (a *autoAir) turn(on, manual bool) bool {
+	a.mu.Lock()
+	now := time.Now()
+	if manual {
+		a.manualUntil = now.Add(manualHold)
+	} else if now.Before(a.manualUntil) {
+		a.mu.Unlock()
+		return false
+	}
+	changed := a.state != on
+	if changed {
+		angle := 45
+		if on {
+			angle = -45
+		}
+		a.sg.Roll(0)
+		time.Sleep(1 * time.Second)
+		a.sg.Roll(angle)
+		a.state = on
+	}
+	a.mu.Unlock()
+
+	if changed || manual {
+		a.report(on)
+	}
+	return changed
+}
+
+// auto ends the manual mode, the air-cleaner is turned on/off by the pm2.5 again
+func (a *autoAir) auto() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.manualUntil = time.Time{}
+}
+
+func (a *autoAir) isOn() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.state
+}
+
+// command handles the commands of the air-cleaner: "on", "off", "auto" and "state"
+func (a *autoAir) command(cmd *iot.Command) (interface{}, error) {
+	switch cmd.Name {
+	case "on":
+		a.on()
+	case "off":
+		a.off()
+	case "auto":
+		a.auto()
+	case "state":
+	default:
+		return nil, iot.ErrUnknownCommand
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return map[string]bool{"on": a.state, "manual": time.Now().Before(a.manualUntil)}, nil
+}
+
+// report reports the state of the air-cleaner to home assistant
+func (a *autoAir) report(on bool) {
+	if a.hass == nil {
+		return
+	}
+	if err := a.hass.SetState("air-cleaner", on); err != nil {
+		log.Printf("[autoair]failed to report the state to home assistant, error: %v", err)
+	}
 }
 
 func (a *autoAir) stop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	a.sg.Roll(45)
 }
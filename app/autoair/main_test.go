@@ -7,6 +7,6 @@ import (
 )
 
 func TestStart(t *testing.T) {
-	a := autoAir{}
+	a := &autoAir{}
 	assert.NotNil(t, a)
 }
@@ -51,17 +51,27 @@ package main
 
 import (
 	"log"
+	"sync"
 	"time"
 
 	"github.com/shanghuiyang/rpi-devices/dev"
+	"github.com/shanghuiyang/rpi-devices/iot"
 	"github.com/shanghuiyang/rpi-devices/util"
 	"github.com/stianeikeland/go-rpio"
 )
 
 const (
+	// mqttName is the mqtt cloud of Home Assistant in the config file of the clouds
+	mqttName = "mqtt"
+	// pollerName is the command poller in the config file of the clouds, it's optional
+	pollerName         = "poller"
 	relayPin           = 7
 	intervalTime       = 1 * time.Minute
 	triggerTemperature = 27.3
+
+	// manualHold is how long the fan keeps the state set by a command or home assistant,
+	// it's turned on/off by the temperature again after that, or after the "auto" command.
+	manualHold = 2 * time.Hour
 )
 
 func main() {
@@ -87,8 +97,38 @@ func main() {
 		temp:  temp,
 		relay: r,
 	}
+	// home assistant is optional, the fan is controlled by the temperature without it
+	clouds, err := iot.LoadClouds("")
+	if err != nil {
+		log.Printf("[autofan]failed to load the clouds, home assistant is disabled, error: %v", err)
+	} else if mqtt, err := clouds.MQTT(mqttName); err != nil {
+		log.Printf("[autofan]failed to get the mqtt cloud, home assistant is disabled, error: %v", err)
+	} else {
+		f.hass = iot.NewHomeAssistant(mqtt, "Auto-Fan")
+		f.hass.AddSensor(&iot.HASensor{ID: "temperature", Name: "Temperature", Unit: "°C", DeviceClass: "temperature"})
+		f.hass.AddSwitch(&iot.HASwitch{
+			ID:   "fan",
+			Name: "Fan",
+			Icon: "mdi:fan",
+			On:   f.on,
+			Off:  f.off,
+		})
+		if err := mqtt.SubscribeCommands("fan", f.command); err != nil {
+			log.Printf("[autofan]failed to subscribe the commands, error: %v", err)
+		}
+	}
+	if clouds != nil {
+		if poller, err := clouds.Commander(pollerName); err != nil {
+			log.Printf("[autofan]no command poller, error: %v", err)
+		} else if err := poller.SubscribeCommands("fan", f.command); err != nil {
+			log.Printf("[autofan]failed to subscribe the commands of the poller, error: %v", err)
+		}
+	}
 	util.WaitQuit(func() {
 		f.off()
+		if clouds != nil {
+			clouds.Close()
+		}
 		rpio.Close()
 	})
 	f.start()
@@ -97,6 +137,14 @@ func main() {
 type autoFan struct {
 	temp  *dev.DS18B20
 	relay *dev.Relay
+	// hass is nil if home assistant is disabled
+	hass *iot.HomeAssistant
+
+	// mu guards the state and the relay, which are used by start() and the commands concurrently
+	mu    sync.Mutex
+	state bool
+	// manualUntil is the end of the manual mode set by a command
+	manualUntil time.Time
 }
 
 func (f *autoFan) start() {
@@ -107,18 +155,80 @@ func (f *autoFan) start() {
 			log.Printf("[autofan]failed to get temperature, error: %v", err)
 			continue
 		}
-		if c >= triggerTemperature {
-			f.on()
-		} else {
-			f.off()
+		if f.hass != nil {
+			if err := f.hass.Push(&iot.Value{Device: "temperature", Value: c}); err != nil {
+				log.Printf("[autofan]failed to push temperature to home assistant, error: %v", err)
+			}
 		}
+		f.turn(c >= triggerTemperature, false)
 	}
 }
 
+// on turns on the fan in the manual mode, it's called by the commands and home assistant
 func (f *autoFan) on() {
-	f.relay.On()
+	f.turn(true, true)
 }
 
+// off turns off the fan in the manual mode, it's called by the commands and home assistant
 func (f *autoFan) off() {
-	f.relay.Off()
+	f.turn(false, true)
+}
+
+// turn turns on/off the fan, and returns true if the state is changed.
+// a manual turn holds the state for manualHold, and the automatic turns are ignored within it.
+func (f *autoFan) turn(on, manual bool) bool {
+	f.mu.Lock()
+	now := time.Now()
+	if manual {
+		f.manualUntil = now.Add(manualHold)
+	} else if now.Before(f.manualUntil) {
+		f.mu.Unlock()
+		return false
+	}
+	changed := f.state != on
+	if on {
+		f.relay.On()
+	} else {
+		f.relay.Off()
+	}
+	f.state = on
+	f.mu.Unlock()
+
+	f.report(on)
+	return changed
+}
+
+// auto ends the manual mode, the fan is turned on/off by the temperature again
+func (f *autoFan) auto() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.manualUntil = time.Time{}
+}
+
+// command handles the commands of the fan: "on", "off", "auto" and "state"
+func (f *autoFan) command(cmd *iot.Command) (interface{}, error) {
+	switch cmd.Name {
+	case "on":
+		f.on()
+	case "off":
+		f.off()
+	case "auto":
+		f.auto()
+	case "state":
+	default:
+		return nil, iot.ErrUnknownCommand
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return map[string]bool{"on": f.state, "manual": time.Now().Before(f.manualUntil)}, nil
+}
+
+// report reports the state of the fan to home assistant
+func (f *autoFan) report(on bool) {
+	if f.hass == nil {
+		return
+	}
+	if err := f.hass.SetState("fan", on); err != nil {
+		log.Printf("[autofan]failed to report the state to home assistant, error: %v", err)
+	}
 }
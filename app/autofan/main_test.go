@@ -7,6 +7,6 @@ import (
 )
 
 func TestStart(t *testing.T) {
-	fan := autoFan{}
+	fan := &autoFan{}
 	assert.NotNil(t, fan)
 }
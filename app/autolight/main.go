@@ -15,6 +15,7 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/shanghuiyang/rpi-devices/dev"
@@ -24,10 +25,23 @@ import (
 )
 
 const (
+	// cloudName is the cloud of the values in the config file of the clouds
+	cloudName = "wsn"
+	// mqttName is the mqtt cloud of Home Assistant in the config file of the clouds
+	mqttName = "mqtt"
+	// pollerName is the command poller in the config file of the clouds, it's optional
+	pollerName = "poller"
+	// queueFile persists the values which haven't been pushed to the cloud
+	queueFile = "autolight_queue.json"
+
 	pinLight = 16
 	pinLed   = 4
 	pinTrig  = 21
 	pinEcho  = 26
+
+	// manualHold is how long the light keeps the state set by a command, the web page or home assistant,
+	// it's turned on/off by the distance again after that, or after the "auto" command.
+	manualHold = 1 * time.Hour
 )
 
 const (
@@ -66,21 +80,59 @@ func main() {
 		return
 	}
 
-	wsnCfg := &iot.WsnConfig{
-		Token: iot.WsnToken,
-		API:   iot.WsnNumericalAPI,
+	clouds, err := iot.LoadClouds("")
+	if err != nil {
+		log.Printf("[autolight]failed to load the clouds, error: %v", err)
+		return
+	}
+	cloud, err := clouds.Get(cloudName)
+	if err != nil {
+		log.Printf("[autolight]failed to get the cloud, error: %v", err)
+		return
+	}
+	rcloud, err := iot.NewReliableCloud(cloud, &iot.ReliableConfig{File: queueFile})
+	if err != nil {
+		log.Printf("[autolight]failed to load the queue of the cloud, error: %v", err)
+		return
+	}
+
+	// home assistant is optional, the light is controlled by the distance without it
+	var hass *iot.HomeAssistant
+	mqtt, err := clouds.MQTT(mqttName)
+	if err != nil {
+		log.Printf("[autolight]failed to get the mqtt cloud, home assistant is disabled, error: %v", err)
+	} else {
+		hass = iot.NewHomeAssistant(mqtt, "Auto-Light")
 	}
-	cloud := iot.NewCloud(wsnCfg)
 
-	alight = newAutoLight(dist, light, led, cloud)
+	alight = newAutoLight(dist, light, led, rcloud, hass)
+	if hass != nil {
+		hass.AddSwitch(&iot.HASwitch{
+			ID:   "light",
+			Name: "Light",
+			Icon: "mdi:lightbulb",
+			On:   alight.on,
+			Off:  alight.off,
+		})
+		if err := mqtt.SubscribeCommands("light", alight.command); err != nil {
+			log.Printf("[autolight]failed to subscribe the commands, error: %v", err)
+		}
+	}
+	if poller, err := clouds.Commander(pollerName); err != nil {
+		log.Printf("[autolight]no command poller, error: %v", err)
+	} else if err := poller.SubscribeCommands("light", alight.command); err != nil {
+		log.Printf("[autolight]failed to subscribe the commands of the poller, error: %v", err)
+	}
 	util.WaitQuit(func() {
+		rcloud.Close()
+		clouds.Close()
 		alight.off()
 		rpio.Close()
 	})
 	alight.start()
 
 	http.HandleFunc("/", lightServer)
-	err := http.ListenAndServe(":8080", nil)
+	err = http.ListenAndServe(":8080", nil)
 	if err != nil {
 		log.Fatal("[autolight]ListenAndServe: ", err.Error())
 	}
@@ -129,7 +181,7 @@ func homePageHandler(w http.ResponseWriter, r *http.Request) {
 			s = strings.Replace(s, datetimePattern, datetime, 1)
 		case strings.Index(s, statePattern) >= 0:
 			state := "unchecked"
-			if alight.state {
+			if alight.isOn() {
 				state = "checked"
 			}
 			s = strings.Replace(s, statePattern, state, 1)
@@ -154,24 +206,30 @@ func operationHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 type autoLight struct {
-	dist     *dev.HCSR04
-	light    *dev.Led
-	led      *dev.Led
-	cloud    iot.Cloud
+	dist    *dev.HCSR04
+	light   *dev.Led
+	led     *dev.Led
+	cloud   iot.Cloud
+	hass    *iot.HomeAssistant // nil if home assistant is disabled
+	chLight chan bool
+	chLed   chan bool
+
+	// mu guards the state and the light, which are used by ctrLight() and the commands concurrently
+	mu       sync.Mutex
 	trigTime time.Time
 	state    bool // true: turn on, false: turn off
-	chLight  chan bool
-	chLed    chan bool
+	// manualUntil is the end of the manual mode set by a command
+	manualUntil time.Time
 }
 
-func newAutoLight(dist *dev.HCSR04, light *dev.Led, led *dev.Led, cloud iot.Cloud) *autoLight {
+func newAutoLight(dist *dev.HCSR04, light *dev.Led, led *dev.Led, cloud iot.Cloud, hass *iot.HomeAssistant) *autoLight {
 	return &autoLight{
 		dist:     dist,
 		light:    light,
 		led:      led,
-		state:    false,
 		trigTime: time.Now(),
 		cloud:    cloud,
+		hass:     hass,
 		chLight:  make(chan bool, 4),
 		chLed:    make(chan bool, 4),
 	}
@@ -211,7 +269,7 @@ func (a *autoLight) ctrLight() {
 			time.Sleep(10 * time.Second)
 			v := &iot.Value{
 				Device: "5dd29e1be4b074c40dfe87c4",
-				Value:  bool2int[a.state],
+				Value:  bool2int[a.isOn()],
 			}
 			if err := a.cloud.Push(v); err != nil {
 				log.Printf("[autolight]push: failed to push the state of light to cloud, error: %v", err)
@@ -221,16 +279,11 @@ func (a *autoLight) ctrLight() {
 
 	for detected := range a.chLight {
 		if detected {
-			if !a.state {
-				a.on()
-			}
-			a.trigTime = time.Now()
+			a.turn(true, false)
 			continue
 		}
-		timeout := time.Now().Sub(a.trigTime).Seconds() > 45
-		if timeout && a.state {
+		if a.timeout() && a.turn(false, false) {
 			log.Printf("[autolight]timeout, light off")
-			a.off()
 		}
 	}
 }
@@ -243,13 +296,91 @@ func (a *autoLight) ctrLed() {
 	}
 }
 
+// on turns on the light in the manual mode, it's called by the commands, the web page and home assistant
 func (a *autoLight) on() {
-	a.state = true
-	a.trigTime = time.Now()
-	a.light.On()
+	a.turn(true, true)
 }
 
+// off turns off the light in the manual mode, it's called by the commands, the web page and home assistant
 func (a *autoLight) off() {
-	a.state = false
-	a.light.Off()
+	a.turn(false, true)
+}
+
+// turn turns on/off the light, and returns true if the state is changed.
+// a manual turn holds the state for manualHold, and the automatic turns are ignored within it.
+func (a *autoLight) turn(on, manual bool) bool {
+	a.mu.Lock()
+	now := time.Now()
+	if manual {
+		a.manualUntil = now.Add(manualHold)
+	} else if now.Before(a.manualUntil) {
+		a.mu.Unlock()
+		return false
+	}
+	if on {
+		a.trigTime = now
+	}
+	changed := a.state != on
+	if changed {
+		if on {
+			a.light.On()
+		} else {
+			a.light.Off()
+		}
+		a.state = on
+	}
+	a.mu.Unlock()
+
+	if changed || manual {
+		a.report(on)
+	}
+	return changed
+}
+
+// timeout returns true if no objects are detected in 45s
+func (a *autoLight) timeout() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return time.Now().Sub(a.trigTime).Seconds() > 45
+}
+
+// auto ends the manual mode, the light is turned on/off by the distance again
+func (a *autoLight) auto() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.manualUntil = time.Time{}
+}
+
+func (a *autoLight) isOn() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.state
+}
+
+// command handles the commands of the light: "on", "off", "auto" and "state"
+func (a *autoLight) command(cmd *iot.Command) (interface{}, error) {
+	switch cmd.Name {
+	case "on":
+		a.on()
+	case "off":
+		a.off()
+	case "auto":
+		a.auto()
+	case "state":
+	default:
+		return nil, iot.ErrUnknownCommand
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return map[string]bool{"on": a.state, "manual": time.Now().Before(a.manualUntil)}, nil
+}
+
+// report reports the state of the light to home assistant
+func (a *autoLight) report(on bool) {
+	if a.hass == nil {
+		return
+	}
+	if err := a.hass.SetState("light", on); err != nil {
+		log.Printf("[autolight]failed to report the state to home assistant, error: %v", err)
+	}
 }
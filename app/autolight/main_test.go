@@ -7,6 +7,6 @@ import (
 )
 
 func TestStart(t *testing.T) {
-	light := autoLight{}
+	light := &autoLight{}
 	assert.NotNil(t, light)
 }
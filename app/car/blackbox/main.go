@@ -0,0 +1,114 @@
+/*
+blackbox exports the telemetry recorded by the car.
+
+usage:
+  # export the frames in a time range to csv
+  $ blackbox -dir telemetry -from 2021-01-02T15:04:05 -to 2021-01-02T15:10:00 -csv track.csv
+  # plot the track against the nav waypoints
+  $ blackbox -dir telemetry -svg track.svg -waypoints "39.956,116.444;39.955,116.445"
+
+the waypoints in the telemetry will be used if -waypoints isn't provided.
+
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shanghuiyang/rpi-devices/app/car/telemetry"
+	"github.com/shanghuiyang/rpi-devices/util/geo"
+)
+
+const timeFormat = "2006-01-02T15:04:05"
+
+func main() {
+	var (
+		dir       = flag.String("dir", "telemetry", "the directory of telemetry files")
+		from      = flag.String("from", "", "export the frames from this time, in local time: "+timeFormat)
+		to        = flag.String("to", "", "export the frames to this time, in local time: "+timeFormat)
+		csvFile   = flag.String("csv", "", "export the frames to this csv file")
+		svgFile   = flag.String("svg", "", "plot the track to this svg file")
+		waypoints = flag.String("waypoints", "", "the waypoints for plotting: lat,lon;lat,lon;...")
+	)
+	flag.Parse()
+
+	if *csvFile == "" && *svgFile == "" {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	start, err := parseTime(*from)
+	if err != nil {
+		log.Fatalf("[blackbox]invalid from: %v", err)
+	}
+	end, err := parseTime(*to)
+	if err != nil {
+		log.Fatalf("[blackbox]invalid to: %v", err)
+	}
+
+	frames, err := telemetry.Read(*dir, start, end)
+	if err != nil {
+		log.Fatalf("[blackbox]failed to read telemetry, error: %v", err)
+	}
+	log.Printf("[blackbox]%v frames", len(frames))
+
+	if *csvFile != "" {
+		if err := writeFile(*csvFile, func(f *os.File) error {
+			return telemetry.WriteCSV(f, frames)
+		}); err != nil {
+			log.Fatalf("[blackbox]failed to export csv, error: %v", err)
+		}
+	}
+
+	if *svgFile != "" {
+		pts := telemetry.Waypoints(frames)
+		if *waypoints != "" {
+			pts, err = parseWaypoints(*waypoints)
+			if err != nil {
+				log.Fatalf("[blackbox]invalid waypoints: %v", err)
+			}
+		}
+		if err := writeFile(*svgFile, func(f *os.File) error {
+			return telemetry.PlotSVG(f, frames, pts)
+		}); err != nil {
+			log.Fatalf("[blackbox]failed to plot svg, error: %v", err)
+		}
+	}
+}
+
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation(timeFormat, s, time.Local)
+}
+
+func parseWaypoints(s string) ([]*geo.Point, error) {
+	var pts []*geo.Point
+	for _, item := range strings.Split(s, ";") {
+		var pt geo.Point
+		if n, err := fmt.Sscanf(item, "%f,%f", &pt.Lat, &pt.Lon); err != nil || n != 2 {
+			return nil, fmt.Errorf("bad waypoint: %v", item)
+		}
+		pts = append(pts, &pt)
+	}
+	return pts, nil
+}
+
+func writeFile(name string, write func(f *os.File) error) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
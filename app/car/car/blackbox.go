@@ -0,0 +1,115 @@
+package car
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/shanghuiyang/rpi-devices/app/car/telemetry"
+	"github.com/shanghuiyang/rpi-devices/util/geo"
+)
+
+// blackbox samples all subsystems of the car into the telemetry at a fixed rate
+func (c *Car) blackbox() {
+	ticker := time.NewTicker(telemetryInterval)
+	defer ticker.Stop()
+
+	flushed := time.Now()
+	for t := range ticker.C {
+		fr := c.sample(t)
+		if err := c.telemetry.Write(fr); err != nil {
+			log.Printf("[car]failed to write telemetry, error: %v", err)
+			continue
+		}
+		if time.Since(flushed) >= telemetryFlushInterval {
+			c.telemetry.Flush()
+			flushed = time.Now()
+		}
+	}
+}
+
+// Telemetry samples the car right now
+func (c *Car) Telemetry() *telemetry.Frame {
+	return c.sample(time.Now())
+}
+
+func (c *Car) sample(t time.Time) *telemetry.Frame {
+	fr := &telemetry.Frame{
+		Time:    t,
+		Mode:    c.mode(),
+		OpQueue: len(c.chOp),
+		Motor:   string(c.motor),
+		Speed:   c.curSpeed,
+		Servo:   c.servoAngle,
+		Dist:    c.dist,
+		Ticks:   atomic.LoadInt64(&c.ticks),
+	}
+	if c.gy25 != nil {
+		if yaw, pitch, roll, err := c.gy25.Angles(); err == nil {
+			fr.Yaw, fr.Pitch, fr.Roll = yaw, pitch, roll
+		}
+	}
+	if fix := c.lastFix(); fix != nil {
+		fr.Fix = true
+		fr.Lat, fr.Lon = fix.Lat, fix.Lon
+	}
+	if dest := c.dest; dest != nil {
+		fr.DestLat, fr.DestLon = dest.Lat, dest.Lon
+	}
+	if target := c.navTarget; target != nil {
+		fr.TargetLat, fr.TargetLon = target.Lat, target.Lon
+	}
+	d := c.planner.lastDecision()
+	fr.Steer, fr.PlanSpeed, fr.Blocked = d.Steer, d.Speed, d.Blocked
+	return fr
+}
+
+// pollGPS keeps the gps fix fresh for the telemetry and the home when nav isn't reading the gps
+func (c *Car) pollGPS() {
+	if c.gps == nil {
+		return
+	}
+	for {
+		time.Sleep(1 * time.Second)
+		if c.selfnav {
+			continue
+		}
+		c.loc()
+	}
+}
+
+// loc reads the location from gps, and keeps it as the latest fix
+func (c *Car) loc() (*geo.Point, error) {
+	pt, err := c.gps.Loc()
+	if err != nil {
+		return nil, err
+	}
+	c.fixMu.Lock()
+	c.fix = pt
+	c.fixMu.Unlock()
+	c.setHome(pt)
+	return pt, nil
+}
+
+// lastFix returns the latest gps fix, nil if there isn't any
+func (c *Car) lastFix() *geo.Point {
+	c.fixMu.Lock()
+	defer c.fixMu.Unlock()
+	return c.fix
+}
+
+func (c *Car) mode() string {
+	switch {
+	case c.selfdriving:
+		return "selfdriving"
+	case c.selftracking:
+		return "selftracking"
+	case c.speechdriving:
+		return "speechdriving"
+	case c.selfnav:
+		return "selfnav"
+	case c.isReplaying():
+		return "replaying"
+	}
+	return "manual"
+}
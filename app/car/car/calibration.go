@@ -0,0 +1,232 @@
+package car
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"time"
+
+	"github.com/shanghuiyang/rpi-devices/dev"
+)
+
+var (
+	// calibrationSpeeds are the speeds(%) for calibrating
+	calibrationSpeeds = []uint32{30, 40, 50}
+	// calibrationPulses are the durations(ms) of spinning at each speed
+	calibrationPulses = []int{100, 200, 300, 400, 500}
+)
+
+// Calibration is the calibration profile of turning,
+// it maps the angle(degree) to time(millisecond) and encoder counts at each speed.
+type Calibration struct {
+	Speeds []*SpeedCalibration `json:"speeds"`
+}
+
+// SpeedCalibration is the calibration at a speed:
+//
+//	angle = DegPerMs * ms + MsOffset
+//	angle = DegPerCount * count + CountOffset
+type SpeedCalibration struct {
+	Speed       uint32  `json:"speed"`
+	DegPerMs    float64 `json:"deg_per_ms"`
+	MsOffset    float64 `json:"ms_offset"`
+	DegPerCount float64 `json:"deg_per_count"`
+	CountOffset float64 `json:"count_offset"`
+}
+
+// spinSample is the result of spinning the car for a while
+type spinSample struct {
+	ms    int
+	count int
+	angle float64
+}
+
+// LoadCalibration loads the calibration profile from a json file
+func LoadCalibration(file string) (*Calibration, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var cal Calibration
+	if err := json.Unmarshal(data, &cal); err != nil {
+		return nil, err
+	}
+	if len(cal.Speeds) == 0 {
+		return nil, errors.New("empty calibration")
+	}
+	return &cal, nil
+}
+
+// Save saves the calibration profile to a json file
+func (cal *Calibration) Save(file string) error {
+	data, err := json.MarshalIndent(cal, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+// TurnMs returns how long(ms) the car should spin for turning the angle at the speed.
+// false will be returned if there isn't a valid calibration.
+func (cal *Calibration) TurnMs(speed uint32, angle float64) (int, bool) {
+	sc := cal.nearest(speed)
+	if sc == nil || sc.DegPerMs <= 0 {
+		return 0, false
+	}
+	ms := (math.Abs(angle) - sc.MsOffset) / sc.DegPerMs
+	if ms < 0 {
+		ms = 0
+	}
+	return int(ms + 0.5), true
+}
+
+// TurnCounts returns how many encoder counts the car should spin for turning the angle at the speed.
+// false will be returned if there isn't a valid calibration.
+func (cal *Calibration) TurnCounts(speed uint32, angle float64) (int, bool) {
+	sc := cal.nearest(speed)
+	if sc == nil || sc.DegPerCount <= 0 {
+		return 0, false
+	}
+	n := (math.Abs(angle) - sc.CountOffset) / sc.DegPerCount
+	if n < 0 {
+		n = 0
+	}
+	return int(n + 0.5), true
+}
+
+// nearest returns the calibration at the speed closest to speed
+func (cal *Calibration) nearest(speed uint32) *SpeedCalibration {
+	var best *SpeedCalibration
+	for _, sc := range cal.Speeds {
+		if best == nil || math.Abs(float64(sc.Speed)-float64(speed)) < math.Abs(float64(best.Speed)-float64(speed)) {
+			best = sc
+		}
+	}
+	return best
+}
+
+// Calibrate spins the car at each speed for several durations,
+// reading the yaw from gy-25 and the counts of the encoder from ticks,
+// and fits the angle-per-ms and angle-per-count lines.
+// ticks returns the total counts of the encoder, it doesn't read the encoder itself,
+// since the encoder is read by only one goroutine. it can be nil, and then the angle-per-count won't be calibrated.
+func Calibrate(eng *dev.L298N, gy25 *dev.GY25, ticks func() int64, speeds []uint32) (*Calibration, error) {
+	if eng == nil {
+		return nil, errors.New("engine is nil")
+	}
+	if gy25 == nil {
+		return nil, errors.New("gy-25 is nil")
+	}
+	defer eng.Stop()
+
+	cal := &Calibration{}
+	for _, speed := range speeds {
+		eng.Speed(speed)
+		var samples []*spinSample
+		for _, ms := range calibrationPulses {
+			s, err := spin(eng, gy25, ticks, ms)
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("[car]calibrate: speed=%v%%, ms=%v, count=%v, angle=%.1f", speed, s.ms, s.count, s.angle)
+			samples = append(samples, s)
+		}
+		sc, err := fitSpeedCalibration(speed, samples)
+		if err != nil {
+			return nil, fmt.Errorf("failed to calibrate at speed %v%%, error: %v", speed, err)
+		}
+		log.Printf("[car]calibrate: speed=%v%%, %.3f deg/ms, %.3f deg/count", speed, sc.DegPerMs, sc.DegPerCount)
+		cal.Speeds = append(cal.Speeds, sc)
+	}
+	return cal, nil
+}
+
+// spin spins the car to the right for ms, and measures the angle it turned and the encoder counts
+func spin(eng *dev.L298N, gy25 *dev.GY25, ticks func() int64, ms int) (*spinSample, error) {
+	yaw, _, _, err := gy25.Angles()
+	if err != nil {
+		return nil, err
+	}
+	var start int64
+	if ticks != nil {
+		start = ticks()
+	}
+
+	// accumulate the signed yaw deltas in small steps, so that it works for the angle more than 180 degree,
+	// and the jitter of the yaw back and forth cancels out.
+	// the yaw increases when the car turns right.
+	angle := 0.0
+	end := time.Now().Add(time.Duration(ms) * time.Millisecond)
+	eng.Right()
+	for time.Now().Before(end) {
+		yaw2, _, _, err := gy25.Angles()
+		if err != nil {
+			continue
+		}
+		angle += angleDiff(yaw2, yaw)
+		yaw = yaw2
+	}
+	eng.Stop()
+
+	// the car keeps turning for a while after stopping the engine
+	time.Sleep(500 * time.Millisecond)
+	if yaw2, _, _, err := gy25.Angles(); err == nil {
+		angle += angleDiff(yaw2, yaw)
+	}
+	count := 0
+	if ticks != nil {
+		count = int(ticks() - start)
+	}
+	return &spinSample{ms: ms, count: count, angle: angle}, nil
+}
+
+func fitSpeedCalibration(speed uint32, samples []*spinSample) (*SpeedCalibration, error) {
+	var ms, counts, angles []float64
+	for _, s := range samples {
+		ms = append(ms, float64(s.ms))
+		counts = append(counts, float64(s.count))
+		angles = append(angles, s.angle)
+	}
+	k, b, err := fitLine(ms, angles)
+	if err != nil {
+		return nil, err
+	}
+	if k <= 0 {
+		return nil, errors.New("the car didn't turn")
+	}
+	sc := &SpeedCalibration{
+		Speed:    speed,
+		DegPerMs: k,
+		MsOffset: b,
+	}
+	if k, b, err := fitLine(counts, angles); err == nil && k > 0 {
+		sc.DegPerCount = k
+		sc.CountOffset = b
+	}
+	return sc, nil
+}
+
+// fitLine fits y = k*x + b using least squares
+func fitLine(x, y []float64) (k, b float64, err error) {
+	n := float64(len(x))
+	if len(x) < 2 || len(x) != len(y) {
+		return 0, 0, errors.New("not enough samples")
+	}
+	var sx, sy, sxx, sxy float64
+	for i := range x {
+		sx += x[i]
+		sy += y[i]
+		sxx += x[i] * x[i]
+		sxy += x[i] * y[i]
+	}
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0, 0, errors.New("samples are degenerate")
+	}
+	k = (n*sxy - sx*sy) / d
+	b = (sy - k*sx) / n
+	return k, b, nil
+}
@@ -0,0 +1,97 @@
+package car
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFitSpeedCalibration(t *testing.T) {
+	// angle = 0.2*ms + 5, angle = 4*count + 1
+	var samples []*spinSample
+	for _, ms := range calibrationPulses {
+		angle := 0.2*float64(ms) + 5
+		samples = append(samples, &spinSample{
+			ms:    ms,
+			count: int((angle - 1) / 4),
+			angle: angle,
+		})
+	}
+	sc, err := fitSpeedCalibration(30, samples)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.2, sc.DegPerMs, 0.0001)
+	assert.InDelta(t, 5, sc.MsOffset, 0.0001)
+	assert.InDelta(t, 4, sc.DegPerCount, 0.0001)
+	assert.InDelta(t, 1, sc.CountOffset, 0.0001)
+
+	_, err = fitSpeedCalibration(30, samples[:1])
+	assert.Error(t, err)
+}
+
+func TestSpinAngle(t *testing.T) {
+	// the yaw jitters back and forth, and wraps at 180 degree
+	yaws := []float64{170, 175, 172, 176, 179, -178, -179, -175}
+	angle := 0.0
+	for i := 1; i < len(yaws); i++ {
+		angle += angleDiff(yaws[i], yaws[i-1])
+	}
+	assert.InDelta(t, 15, angle, 0.0001)
+}
+
+func TestCalibration(t *testing.T) {
+	cal := &Calibration{
+		Speeds: []*SpeedCalibration{
+			{Speed: 30, DegPerMs: 0.2, MsOffset: 5, DegPerCount: 4, CountOffset: 1},
+			{Speed: 50, DegPerMs: 0.4, MsOffset: 10, DegPerCount: 4, CountOffset: 2},
+		},
+	}
+	testCases := []struct {
+		desc   string
+		speed  uint32
+		angle  float64
+		ms     int
+		counts int
+	}{
+		{
+			desc:   "exact speed",
+			speed:  30,
+			angle:  90,
+			ms:     425,
+			counts: 22,
+		},
+		{
+			desc:   "nearest speed",
+			speed:  45,
+			angle:  -90,
+			ms:     200,
+			counts: 22,
+		},
+		{
+			desc:   "small angle",
+			speed:  30,
+			angle:  1,
+			ms:     0,
+			counts: 0,
+		},
+	}
+	for _, test := range testCases {
+		ms, ok := cal.TurnMs(test.speed, test.angle)
+		assert.True(t, ok, test.desc)
+		assert.Equal(t, test.ms, ms, test.desc)
+		counts, ok := cal.TurnCounts(test.speed, test.angle)
+		assert.True(t, ok, test.desc)
+		assert.Equal(t, test.counts, counts, test.desc)
+	}
+
+	dir, err := ioutil.TempDir("", "calibration")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "calibration.json")
+	assert.NoError(t, cal.Save(file))
+	loaded, err := LoadCalibration(file)
+	assert.NoError(t, err)
+	assert.Equal(t, cal, loaded)
+}
@@ -12,14 +12,18 @@ import (
 	"github.com/shanghuiyang/go-speech/oauth"
 	"github.com/shanghuiyang/go-speech/speech"
 	"github.com/shanghuiyang/image-recognizer/recognizer"
+	"github.com/shanghuiyang/rpi-devices/app/car/telemetry"
 	"github.com/shanghuiyang/rpi-devices/dev"
 	"github.com/shanghuiyang/rpi-devices/util"
-	cv "github.com/shanghuiyang/rpi-devices/util/cv/mock"
 	"github.com/shanghuiyang/rpi-devices/util/geo"
 )
 
 // Car ...
 type Car struct {
+	// the 64-bit fields accessed atomically are kept at the beginning for the alignment on 32-bit arm
+	heartbeat int64 // unix time in nanosecond of the last heartbeat
+	ticks     int64 // ticks counted by the encoder
+
 	engine *dev.L298N
 	horn   *dev.Buzzer
 	led    *dev.Led
@@ -36,6 +40,11 @@ type Car struct {
 	collisions  []*dev.Collision
 	selfdriving bool
 	servoAngle  int
+	curSpeed    uint32
+	cal         *Calibration
+	motor       Op
+	dist        float64
+	planner     *vfh
 
 	// speed-driving
 	asr           *speech.ASR
@@ -45,7 +54,10 @@ type Car struct {
 	volume        int
 
 	// self-tracking
-	tracker      *cv.Tracker
+	tracker      tracker
+	detector     string
+	detectorCfg  string
+	frameSrc     string
 	selftracking bool
 
 	// nav
@@ -54,22 +66,46 @@ type Car struct {
 	lastLoc   *geo.Point
 	gpslogger *util.GPSLogger
 	selfnav   bool
+	navTarget *geo.Point
+	// fixMu guards fix, which is written by the gps reader and read by the recorder and the telemetry
+	fixMu sync.Mutex
+	fix   *geo.Point
+
+	// failsafe
+	home          *geo.Point
+	linklost      bool
+	returninghome bool
+	failsafeCfg   *FailsafeConfig
+
+	// record & replay
+	recorder  *recorder
+	replaying int32 // 1 if replaying, accessed atomically, see isReplaying()
+
+	// black-box
+	telemetry *telemetry.Writer
 }
 
 // New ...
 func New(cfg *Config) *Car {
 	car := &Car{
-		engine:     cfg.Engine,
-		horn:       cfg.Horn,
-		led:        cfg.Led,
-		light:      cfg.Led,
-		camera:     cfg.Camera,
-		lc12s:      cfg.LC12S,
-		servo:      cfg.Servo,
-		dmeter:     cfg.DistMeter,
-		gy25:       cfg.GY25,
-		collisions: cfg.Collisions,
-		gps:        cfg.GPS,
+		engine:      cfg.Engine,
+		horn:        cfg.Horn,
+		led:         cfg.Led,
+		light:       cfg.Led,
+		camera:      cfg.Camera,
+		lc12s:       cfg.LC12S,
+		servo:       cfg.Servo,
+		dmeter:      cfg.DistMeter,
+		encoder:     cfg.Encoder,
+		gy25:        cfg.GY25,
+		collisions:  cfg.Collisions,
+		gps:         cfg.GPS,
+		telemetry:   cfg.Telemetry,
+		failsafeCfg: cfg.Failsafe,
+		detector:    cfg.Detector,
+		detectorCfg: cfg.DetectorConfig,
+		frameSrc:    cfg.FrameSource,
+		planner:     newVFH(nil),
 
 		servoAngle:    0,
 		selfdriving:   false,
@@ -78,22 +114,36 @@ func New(cfg *Config) *Car {
 		selfnav:       false,
 		chOp:          make(chan Op, chSize),
 	}
+	car.recorder = newRecorder(recordingDir, car.sense)
+	if cal, err := LoadCalibration(calibrationFile); err == nil {
+		car.cal = cal
+		log.Printf("[car]loaded calibration from %v", calibrationFile)
+	}
 	return car
 }
 
 // Start ...
 func (c *Car) Start() error {
 	go c.start()
-	go c.servo.Roll(0)
+	go c.roll(0)
 	go c.blink()
 	go c.joystick()
 	go c.setVolume(40)
+	if c.encoder != nil {
+		go c.countTicks()
+	}
+	if c.telemetry != nil {
+		go c.blackbox()
+	}
+	go c.pollGPS()
+	go c.failsafe()
 	c.speed(30)
 	return nil
 }
 
 // Do ...
 func (c *Car) Do(op Op) {
+	c.recorder.add(op)
 	c.chOp <- op
 }
 
@@ -109,6 +159,11 @@ func (c *Car) GetState() (selfDriving, selfTracking, speechDriving bool) {
 	return c.selfdriving, c.selftracking, c.speechdriving
 }
 
+// GetRecordingState ...
+func (c *Car) GetRecordingState() (recording, replaying bool) {
+	return c.recorder.recording(), c.isReplaying()
+}
+
 // SetDest ...
 func (c *Car) SetDest(dest *geo.Point) {
 	if c.selfnav {
@@ -158,6 +213,10 @@ func (c *Car) start() {
 			go c.selfNavOn()
 		case selfnavoff:
 			go c.selfNavOff()
+		case calibrate:
+			go c.calibrate()
+		case returnhome:
+			go c.returnHome()
 		default:
 			log.Printf("[car]invalid op")
 		}
@@ -168,35 +227,50 @@ func (c *Car) start() {
 func (c *Car) forward() {
 	log.Printf("[car]forward")
 	c.engine.Forward()
+	c.motor = forward
 }
 
 // backward ...
 func (c *Car) backward() {
 	log.Printf("[car]backward")
 	c.engine.Backward()
+	c.motor = backward
 }
 
 // left ...
 func (c *Car) left() {
 	log.Printf("[car]left")
 	c.engine.Left()
+	c.motor = left
 }
 
 // right ...
 func (c *Car) right() {
 	log.Printf("[car]right")
 	c.engine.Right()
+	c.motor = right
 }
 
 // stop ...
 func (c *Car) stop() {
 	log.Printf("[car]stop")
 	c.engine.Stop()
+	c.motor = stop
+}
+
+// roll rolls the servo to the angle
+func (c *Car) roll(angle int) {
+	c.servoAngle = angle
+	if c.servo == nil {
+		return
+	}
+	c.servo.Roll(angle)
 }
 
 func (c *Car) speed(s uint32) {
 	log.Printf("[car]speed %v%%", s)
 	c.engine.Speed(s)
+	c.curSpeed = s
 }
 
 // beep ...
@@ -234,12 +308,8 @@ func (c *Car) servoLeft() {
 	if angle < -90 {
 		angle = -90
 	}
-	c.servoAngle = angle
 	log.Printf("[car]servo roll %v", angle)
-	if c.servo == nil {
-		return
-	}
-	c.servo.Roll(angle)
+	c.roll(angle)
 }
 
 func (c *Car) servoRight() {
@@ -247,21 +317,13 @@ func (c *Car) servoRight() {
 	if angle > 90 {
 		angle = 90
 	}
-	c.servoAngle = angle
 	log.Printf("[car]servo roll %v", angle)
-	if c.servo == nil {
-		return
-	}
-	c.servo.Roll(angle)
+	c.roll(angle)
 }
 
 func (c *Car) servoAhead() {
-	c.servoAngle = 0
 	log.Printf("[car]servo roll %v", 0)
-	if c.servo == nil {
-		return
-	}
-	c.servo.Roll(0)
+	c.roll(0)
 }
 
 func (c *Car) selfDriving() {
@@ -275,14 +337,17 @@ func (c *Car) selfDriving() {
 
 	var (
 		fwd       bool
+		fwdAt     time.Time
 		retry     int
 		mindAngle int
 		maxdAngle int
+		steer     int
 		mind      float64
 		maxd      float64
 		op        = forward
 		chOp      = make(chan Op, 4)
 	)
+	c.planner.reset()
 
 	for c.selfdriving || c.selftracking {
 		select {
@@ -297,13 +362,20 @@ func (c *Car) selfDriving() {
 		}
 		log.Printf("[car]op: %v", op)
 
+		if fwd && op != forward {
+			// update the obstacles in memory with how far the car went
+			c.planner.move(c.distSince(fwdAt), 0)
+		}
+
 		switch op {
 		case backward:
 			fwd = false
 			c.stop()
 			util.DelayMs(20)
 			c.backward()
+			start := time.Now()
 			util.DelayMs(500)
+			c.planner.move(-c.distSince(start), 0)
 			chOp <- stop
 			continue
 		case stop:
@@ -321,11 +393,26 @@ func (c *Car) selfDriving() {
 				retry++
 				continue
 			}
+			decision := c.planner.decide(0)
+			log.Printf("[car]vfh: steer=%.0f, speed=%.0f, blocked=%v, maxdAngle=%v", decision.Steer, decision.Speed, decision.Blocked, maxdAngle)
+			if decision.Blocked && retry < 4 {
+				chOp <- backward
+				retry++
+				continue
+			}
+			steer = int(decision.Steer)
+			if decision.Blocked {
+				// still blocked after several retries, turn to the farthest direction
+				steer = maxdAngle
+			} else {
+				c.speed(uint32(decision.Speed))
+			}
 			chOp <- turn
 			retry = 0
 		case turn:
 			fwd = false
-			c.turn(maxdAngle)
+			turned := c.turn(steer)
+			c.planner.move(0, turned)
 			util.DelayMs(150)
 			chOp <- forward
 			continue
@@ -333,6 +420,7 @@ func (c *Car) selfDriving() {
 			if !fwd {
 				c.forward()
 				fwd = true
+				fwdAt = time.Now()
 				go c.detecting(chOp)
 			}
 			util.DelayMs(50)
@@ -438,7 +526,7 @@ func (c *Car) selfDrivingOn() {
 
 func (c *Car) selfDrivingOff() {
 	c.selfdriving = false
-	c.servo.Roll(0)
+	c.roll(0)
 	log.Printf("[car]self-drving off")
 }
 
@@ -446,14 +534,17 @@ func (c *Car) selfTrackingOn() {
 	if c.selftracking {
 		return
 	}
-	util.StopMotion()
+	if c.frameSrc == "" {
+		// the camera is used by motion
+		util.StopMotion()
+	}
 	c.selfdriving = false
 	c.speechdriving = false
 	c.selfnav = false
 	util.DelayMs(1000) // wait to quit self-driving & speech-driving
 
 	// start slef-tracking
-	t, err := cv.NewTracker(lh, ls, lv, hh, hs, hv)
+	t, err := c.newTracker()
 	if err != nil {
 		log.Printf("[carapp]failed to create a tracker, error: %v", err)
 		return
@@ -468,11 +559,13 @@ func (c *Car) selfTrackingOn() {
 func (c *Car) selfTrackingOff() {
 	c.selftracking = false
 	c.tracker.Close()
-	c.servo.Roll(0)
+	c.roll(0)
 	util.DelayMs(500)
 
-	if err := util.StartMotion(); err != nil {
-		log.Printf("[car]failed to start motion, error: %v", err)
+	if c.frameSrc == "" {
+		if err := util.StartMotion(); err != nil {
+			log.Printf("[car]failed to start motion, error: %v", err)
+		}
 	}
 	log.Printf("[car]self-tracking off")
 }
@@ -493,7 +586,7 @@ func (c *Car) speechDrivingOn() {
 
 func (c *Car) speechDrivingOff() {
 	c.speechdriving = false
-	c.servo.Roll(0)
+	c.roll(0)
 	log.Printf("[car]speech-drving off")
 }
 
@@ -530,9 +623,11 @@ func (c *Car) detectObstacles(chOp chan Op, chQuit chan bool, wg *sync.WaitGroup
 			default:
 				// do nothing
 			}
-			c.servo.Roll(angle)
+			c.roll(angle)
 			util.DelayMs(70)
 			d := c.dmeter.Dist()
+			c.dist = d
+			c.planner.addReading(float64(angle), d)
 			if d < 20 {
 				chOp <- backward
 				chQuit <- true
@@ -561,8 +656,9 @@ func (c *Car) detectCollision(chOp chan Op, chQuit chan bool, wg *sync.WaitGroup
 		default:
 			// do nothing
 		}
-		for _, collision := range c.collisions {
+		for i, collision := range c.collisions {
 			if collision.Collided() {
+				c.planner.addCollision(c.collisionAngle(i))
 				chOp <- backward
 				go c.horn.Beep(1, 100)
 				log.Printf("[car]crashed")
@@ -719,7 +815,7 @@ func (c *Car) scan() (mind, maxd float64, mindAngle, maxdAngle int) {
 	mind = 9999
 	maxd = -9999
 	for _, ang := range scanningAngles {
-		c.servo.Roll(ang)
+		c.roll(ang)
 		util.DelayMs(100)
 		d := c.dmeter.Dist()
 		for i := 0; d < 0 && i < 3; i++ {
@@ -730,6 +826,8 @@ func (c *Car) scan() (mind, maxd float64, mindAngle, maxdAngle int) {
 			continue
 		}
 		log.Printf("[car]scan: angle=%v, dist=%.0f", ang, d)
+		c.dist = d
+		c.planner.addReading(float64(ang), d)
 		if d < mind {
 			mind = d
 			mindAngle = ang
@@ -739,24 +837,53 @@ func (c *Car) scan() (mind, maxd float64, mindAngle, maxdAngle int) {
 			maxdAngle = ang
 		}
 	}
-	c.servo.Roll(0)
+	c.roll(0)
 	util.DelayMs(50)
 	return
 }
 
-func (c *Car) turn(angle int) {
+// turn turns the car to the angle, and returns the angle it has turned actually
+func (c *Car) turn(angle int) float64 {
+	sign := 1.0
 	turnf := c.engine.Right
+	c.motor = right
 	if angle < 0 {
+		sign = -1
 		turnf = c.engine.Left
+		c.motor = left
 		angle *= (-1)
 	}
+	defer func() { c.motor = stop }()
+
+	if c.gy25 == nil {
+		// turn in open-loop using the calibration
+		ms, ok := c.turnMs(float64(angle))
+		if !ok {
+			log.Printf("[car]can't turn without gy-25 or calibration")
+			return 0
+		}
+		turnf()
+		util.DelayMs(ms)
+		c.engine.Stop()
+		return sign * float64(angle)
+	}
 
 	yaw, _, _, err := c.gy25.Angles()
 	if err != nil {
 		log.Printf("[car]failed to get angles from gy-25, error: %v", err)
-		return
+		return 0
+	}
+
+	// turn most of the angle in one go using the calibration,
+	// and then approach the angle step by step
+	if ms, ok := c.turnMs(float64(angle) * 0.8); ok && ms > 0 {
+		turnf()
+		util.DelayMs(ms)
+		c.engine.Stop()
+		time.Sleep(100 * time.Millisecond)
 	}
 
+	turned := 0.0
 	retry := 0
 	for {
 		turnf()
@@ -770,6 +897,7 @@ func (c *Car) turn(angle int) {
 			break
 		}
 		ang := c.gy25.IncludedAngle(yaw, yaw2)
+		turned = ang
 		if ang >= float64(angle) {
 			break
 		}
@@ -778,34 +906,85 @@ func (c *Car) turn(angle int) {
 		time.Sleep(100 * time.Millisecond)
 	}
 	c.engine.Stop()
-	return
+	return sign * turned
 }
 
-func (c *Car) turnLeft(angle int) {
-	n := angle/5 - 1
-	c.encoder.Start()
-	defer c.encoder.Stop()
+// distSince estimates the distance(cm) the car has gone since t using the current speed
+func (c *Car) distSince(t time.Time) float64 {
+	return time.Since(t).Seconds() * float64(c.curSpeed) * cmPerSecPerSpeed
+}
 
-	c.chOp <- left
-	for i := 0; i < n; {
-		i += c.encoder.Count1()
+// collisionAngle returns the angle which the i-th collision switch points to.
+// the switches are mounted from left to right.
+func (c *Car) collisionAngle(i int) float64 {
+	n := len(c.collisions)
+	if n <= 1 {
+		return 0
 	}
-	return
+	return -collisionSpread + 2*collisionSpread*float64(i)/float64(n-1)
 }
 
-func (c *Car) turnRight(angle int) {
-	n := angle/5 - 1
-	c.encoder.Start()
-	defer c.encoder.Stop()
+func (c *Car) turnLeft(angle int) {
+	n := c.turnCounts(angle)
+	c.chOp <- left
+	c.waitTicks(n, func() bool { return c.selfnav })
+}
 
+func (c *Car) turnRight(angle int) {
+	n := c.turnCounts(angle)
 	c.chOp <- right
-	for i := 0; i < n; {
-		i += c.encoder.Count1()
+	c.waitTicks(n, func() bool { return c.selfnav })
+}
+
+// turnMs returns how long(ms) to spin for turning the angle at current speed using the calibration
+func (c *Car) turnMs(angle float64) (int, bool) {
+	if c.cal == nil {
+		return 0, false
 	}
-	return
+	return c.cal.TurnMs(c.curSpeed, angle)
+}
+
+// turnCounts returns the encoder counts for turning the angle at current speed
+func (c *Car) turnCounts(angle int) int {
+	if c.cal != nil {
+		if n, ok := c.cal.TurnCounts(c.curSpeed, float64(angle)); ok {
+			return n
+		}
+	}
+	return angle/5 - 1
+}
+
+// calibrate calibrates the turning and saves the calibration profile
+func (c *Car) calibrate() {
+	if c.selfdriving || c.selftracking || c.speechdriving || c.selfnav || c.isReplaying() {
+		log.Printf("[car]can't calibrate in self-driving, self-tracking, speech-driving, nav or replaying mode")
+		return
+	}
+	log.Printf("[car]calibrating")
+	speed := c.curSpeed
+	defer c.speed(speed)
+
+	var ticks func() int64
+	if c.encoder != nil {
+		ticks = c.tickCount
+	}
+	cal, err := Calibrate(c.engine, c.gy25, ticks, calibrationSpeeds)
+	if err != nil {
+		log.Printf("[car]failed to calibrate, error: %v", err)
+		return
+	}
+	if err := cal.Save(calibrationFile); err != nil {
+		log.Printf("[car]failed to save calibration, error: %v", err)
+	}
+	c.cal = cal
+	go c.horn.Beep(2, 100)
+	log.Printf("[car]calibrated")
 }
 
 func (c *Car) recognize() error {
+	if c.camera == nil {
+		return errors.New("no camera")
+	}
 	log.Printf("[car]take photo")
 	imagef, err := c.camera.TakePhoto()
 	if err != nil {
@@ -918,6 +1097,7 @@ func (c *Car) joystick() {
 			continue
 		}
 		log.Printf("[car]LC12S received: %v", data)
+		c.Heartbeat()
 
 		if len(data) != 1 {
 			log.Printf("[car]invalid data from LC12S, data len: %v", len(data))
@@ -999,7 +1179,7 @@ func (c *Car) selfNav() error {
 
 	var org *geo.Point
 	for c.selfnav {
-		pt, err := c.gps.Loc()
+		pt, err := c.loc()
 		if err != nil {
 			log.Printf("[car]gps sensor is not ready")
 			util.DelayMs(1000)
@@ -1054,9 +1234,11 @@ func (c *Car) selfNav() error {
 }
 
 func (c *Car) navTo(dest *geo.Point) error {
+	c.navTarget = dest
+	defer func() { c.navTarget = nil }()
 	retry := 8
 	for c.selfnav {
-		loc, err := c.gps.Loc()
+		loc, err := c.loc()
 		if err != nil {
 			c.chOp <- stop
 			log.Printf("[car]gps sensor is not ready")
@@ -3,6 +3,7 @@ package car
 import (
 	"testing"
 
+	"github.com/shanghuiyang/rpi-devices/util/geo"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -10,3 +11,19 @@ func TestCar(t *testing.T) {
 	car := New(&Config{})
 	assert.NotNil(t, car)
 }
+
+func TestSetHome(t *testing.T) {
+	car := New(&Config{})
+	assert.Nil(t, car.GetHomeState().Home)
+
+	outside := &geo.Point{Lat: 0, Lon: 0}
+	car.setHome(outside)
+	assert.Nil(t, car.GetHomeState().Home)
+
+	home := &geo.Point{Lat: 39.956, Lon: 116.4444}
+	car.setHome(home)
+	assert.Equal(t, home, car.GetHomeState().Home)
+
+	car.setHome(&geo.Point{Lat: 39.9558, Lon: 116.4445})
+	assert.Equal(t, home, car.GetHomeState().Home)
+}
@@ -1,11 +1,18 @@
 package car
 
+import (
+	"time"
+)
+
 const (
 	chSize        = 8
 	letMeThinkWav = "let_me_think.wav"
 	thisIsXWav    = "this_is_x.wav"
 	iDontKnowWav  = "i_dont_know.wav"
 	errorWav      = "error.wav"
+	recordingDir  = "recordings"
+	// calibrationFile is the calibration profile of turning, see Calibrate()
+	calibrationFile = "calibration.json"
 )
 
 const (
@@ -42,21 +49,28 @@ const (
 	speechdrivingoff Op = "speechdrivingoff"
 	selfnavon        Op = "selfnavon"
 	selfnavoff       Op = "selfnavoff"
+	calibrate        Op = "calibrate"
+	returnhome       Op = "returnhome"
 )
 
-var (
-	scanningAngles = []int{-90, -75, -60, -45, -30, -15, 0, 15, 30, 45, 60, 75, 90}
-	aheadAngles    = []int{0, -15, 0, 15}
+const (
+	// telemetryInterval is the interval of sampling the car into the telemetry
+	telemetryInterval = 200 * time.Millisecond
+	// telemetryFlushInterval is the interval of flushing the telemetry to the file
+	telemetryFlushInterval = 2 * time.Second
 )
 
 const (
-	// the hsv of a tennis
-	lh float64 = 33
-	ls float64 = 108
-	lv float64 = 138
-	hh float64 = 61
-	hs float64 = 255
-	hv float64 = 255
+	// cmPerSecPerSpeed is the distance(cm) the car goes in one second per 1% speed,
+	// it is used for estimating the distance from the speed, need to tune for your car.
+	cmPerSecPerSpeed = 1.0
+	// collisionSpread is the angle which the leftmost and rightmost collision switches point to
+	collisionSpread = 30.0
+)
+
+var (
+	scanningAngles = []int{-90, -75, -60, -45, -30, -15, 0, 15, 30, 45, 60, 75, 90}
+	aheadAngles    = []int{0, -15, 0, 15}
 )
 
 type (
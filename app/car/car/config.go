@@ -1,6 +1,7 @@
 package car
 
 import (
+	"github.com/shanghuiyang/rpi-devices/app/car/telemetry"
 	"github.com/shanghuiyang/rpi-devices/dev"
 )
 
@@ -10,6 +11,7 @@ type Config struct {
 	Servo      *dev.SG90
 	DistMeter  dev.DistMeter
 	GY25       *dev.GY25
+	Encoder    *dev.Encoder
 	Horn       *dev.Buzzer
 	Led        *dev.Led
 	Light      *dev.Led
@@ -17,4 +19,14 @@ type Config struct {
 	GPS        *dev.GPS
 	LC12S      *dev.LC12S
 	Collisions []*dev.Collision
+	Telemetry  *telemetry.Writer
+	Failsafe   *FailsafeConfig
+	// Detector is the name of the detector for self-tracking, see detect.Names()
+	Detector string
+	// DetectorConfig is the config file of the detector, it's optional for some detectors
+	DetectorConfig string
+	// FrameSource is where self-tracking reads the frames from,
+	// an url of mjpeg stream like "http://localhost:8081" or a directory of jpeg images.
+	// the camera will be used if it's empty, which needs building with -tags=gocv.
+	FrameSource string
 }
@@ -0,0 +1,137 @@
+package car
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/shanghuiyang/rpi-devices/util"
+	"github.com/shanghuiyang/rpi-devices/util/geo"
+)
+
+// FailsafeAction is what the car does when it loses the link to the operator
+type FailsafeAction string
+
+const (
+	// FailsafeNone does nothing
+	FailsafeNone FailsafeAction = "none"
+	// FailsafeStop stops the car
+	FailsafeStop FailsafeAction = "stop"
+	// FailsafeReturnHome stops the car, waits for a while, and then navigates to home
+	FailsafeReturnHome FailsafeAction = "returnhome"
+)
+
+// FailsafeConfig is the policy on link-loss.
+// the link is lost if neither the web client nor the LC12S joystick
+// has been heard from for Timeout.
+type FailsafeConfig struct {
+	Action  FailsafeAction
+	Timeout time.Duration
+	Wait    time.Duration
+}
+
+// HomeState ...
+type HomeState struct {
+	Home          *geo.Point `json:"home"`
+	ReturningHome bool       `json:"returning_home"`
+	LinkLost      bool       `json:"link_lost"`
+}
+
+// Heartbeat tells the car the operator is still there
+func (c *Car) Heartbeat() {
+	atomic.StoreInt64(&c.heartbeat, time.Now().UnixNano())
+}
+
+// lastHeartbeat returns the unix time in nanosecond of the last heartbeat, 0 means never
+func (c *Car) lastHeartbeat() int64 {
+	return atomic.LoadInt64(&c.heartbeat)
+}
+
+// GetHomeState ...
+func (c *Car) GetHomeState() *HomeState {
+	return &HomeState{
+		Home:          c.home,
+		ReturningHome: c.returninghome,
+		LinkLost:      c.linklost,
+	}
+}
+
+// setHome records the home from the first valid gps fix
+func (c *Car) setHome(pt *geo.Point) {
+	if c.home != nil || !bbox.IsInside(pt) {
+		return
+	}
+	c.home = pt
+	log.Printf("[car]home: %v", pt)
+}
+
+// failsafe watches the link to the operator and applies the failsafe policy on link-loss
+func (c *Car) failsafe() {
+	if c.failsafeCfg == nil || c.failsafeCfg.Action == FailsafeNone {
+		return
+	}
+	for {
+		time.Sleep(1 * time.Second)
+		last := c.lastHeartbeat()
+		if last == 0 || c.returninghome {
+			// never connected, or is going home already
+			continue
+		}
+		if time.Since(time.Unix(0, last)) < c.failsafeCfg.Timeout {
+			c.linklost = false
+			continue
+		}
+		if c.linklost {
+			continue
+		}
+		if c.selfdriving || c.selftracking || c.speechdriving || c.selfnav {
+			// the car is on its own
+			continue
+		}
+
+		c.linklost = true
+		log.Printf("[car]link lost, failsafe: %v", c.failsafeCfg.Action)
+		c.StopReplay()
+		c.chOp <- stop
+		if c.failsafeCfg.Action != FailsafeReturnHome {
+			continue
+		}
+
+		// give the operator a chance to reconnect
+		for end := time.Now().Add(c.failsafeCfg.Wait); time.Now().Before(end); {
+			util.DelayMs(200)
+			if c.lastHeartbeat() > last {
+				break
+			}
+		}
+		if c.lastHeartbeat() > last {
+			log.Printf("[car]link is back")
+			c.linklost = false
+			continue
+		}
+		c.chOp <- returnhome
+	}
+}
+
+// returnHome navigates to home using the A* planner
+func (c *Car) returnHome() {
+	if c.returninghome {
+		return
+	}
+	if c.home == nil {
+		log.Printf("[car]failed to return home, error: home isn't set")
+		return
+	}
+	if c.selfnav {
+		c.selfNavOff()
+		util.DelayMs(1000) // wait for nav quit
+	}
+
+	c.returninghome = true
+	log.Printf("[car]return home: %v", c.home)
+	c.dest = c.home
+	c.selfNavOn()
+	c.selfnav = false
+	c.returninghome = false
+	log.Printf("[car]return home done")
+}
@@ -0,0 +1,205 @@
+package car
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const recordingExt = ".jsonl"
+
+// ReplayMode ...
+type ReplayMode string
+
+const (
+	// OpenLoop replays the ops at the time they were recorded
+	OpenLoop ReplayMode = "open"
+	// ClosedLoop replays the ops segment by segment, matching the heading and distance of each segment
+	ClosedLoop ReplayMode = "closed"
+)
+
+// Step is an op received by the car with its timestamp and the sensor context
+type Step struct {
+	Offset int64   `json:"offset"` // in millisecond since the recording started
+	Op     Op      `json:"op"`
+	Yaw    float64 `json:"yaw"`
+	Ticks  int     `json:"ticks"`
+	Lat    float64 `json:"lat,omitempty"`
+	Lon    float64 `json:"lon,omitempty"`
+}
+
+// recorder records the ops into a session file, one step in json per line
+type recorder struct {
+	sync.Mutex
+	dir     string
+	name    string
+	f       *os.File
+	start   time.Time
+	chSteps chan *Step
+	done    chan bool
+	sense   func(s *Step)
+}
+
+func newRecorder(dir string, sense func(s *Step)) *recorder {
+	return &recorder{
+		dir:   dir,
+		sense: sense,
+	}
+}
+
+// begin starts a new recording session
+func (r *recorder) begin(name string) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.f != nil {
+		return fmt.Errorf("recording %v is in progress", r.name)
+	}
+	if err := validRecordingName(name); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(r.path(name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	r.f = f
+	r.name = name
+	r.start = time.Now()
+	r.chSteps = make(chan *Step, chSize)
+	r.done = make(chan bool)
+	go r.write(f, r.chSteps, r.done)
+	return nil
+}
+
+// end stops the current recording session
+func (r *recorder) end() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.f == nil {
+		return errors.New("not recording")
+	}
+	close(r.chSteps)
+	<-r.done
+	err := r.f.Close()
+	r.f = nil
+	r.name = ""
+	return err
+}
+
+func (r *recorder) recording() bool {
+	r.Lock()
+	defer r.Unlock()
+	return r.f != nil
+}
+
+// add records the op with the sensor context at the time it happened if a session is in progress.
+// it blocks if the writer falls behind rather than dropping the step, a missing step would corrupt the route.
+func (r *recorder) add(op Op) {
+	if !r.recording() {
+		return
+	}
+	s := &Step{Op: op}
+	if r.sense != nil {
+		r.sense(s)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if r.f == nil {
+		return
+	}
+	s.Offset = int64(time.Since(r.start) / time.Millisecond)
+	r.chSteps <- s
+}
+
+func (r *recorder) write(f *os.File, chSteps chan *Step, done chan bool) {
+	defer close(done)
+	w := bufio.NewWriter(f)
+	defer w.Flush()
+	for s := range chSteps {
+		data, err := json.Marshal(s)
+		if err != nil {
+			log.Printf("[car]failed to marshal step, error: %v", err)
+			continue
+		}
+		w.Write(data)
+		w.WriteString("\n")
+	}
+}
+
+// list returns the names of the saved recordings
+func (r *recorder) list() ([]string, error) {
+	files, err := ioutil.ReadDir(r.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	names := []string{}
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != recordingExt {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(f.Name(), recordingExt))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// load reads the steps of a saved recording
+func (r *recorder) load(name string) ([]*Step, error) {
+	if err := validRecordingName(name); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(r.path(name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var steps []*Step
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var s Step
+		if err := json.Unmarshal([]byte(line), &s); err != nil {
+			return nil, fmt.Errorf("bad step at line %v, error: %v", n, err)
+		}
+		steps = append(steps, &s)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return steps, nil
+}
+
+func (r *recorder) path(name string) string {
+	return filepath.Join(r.dir, name+recordingExt)
+}
+
+func validRecordingName(name string) error {
+	if name == "" {
+		return errors.New("empty recording name")
+	}
+	if strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid recording name: %v", name)
+	}
+	return nil
+}
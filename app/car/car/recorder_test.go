@@ -0,0 +1,62 @@
+package car
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	yaw := 0.0
+	r := newRecorder(dir, func(s *Step) {
+		s.Yaw = yaw
+		yaw += 10
+	})
+	r.add(forward) // not recording, will be ignored
+
+	assert.NoError(t, r.begin("route1"))
+	assert.Error(t, r.begin("route2"))
+	assert.True(t, r.recording())
+	r.add(forward)
+	r.add(right)
+	r.add(stop)
+	assert.NoError(t, r.end())
+	assert.Error(t, r.end())
+
+	names, err := r.list()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"route1"}, names)
+
+	steps, err := r.load("route1")
+	assert.NoError(t, err)
+	assert.Len(t, steps, 3)
+	assert.Equal(t, forward, steps[0].Op)
+	assert.Equal(t, right, steps[1].Op)
+	assert.Equal(t, 20.0, steps[2].Yaw)
+
+	_, err = r.load("../route1")
+	assert.Error(t, err)
+}
+
+func TestRecorderNoDrop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recordings")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r := newRecorder(dir, nil)
+	assert.NoError(t, r.begin("route1"))
+	for i := 0; i < 1000; i++ {
+		r.add(forward)
+	}
+	assert.NoError(t, r.end())
+
+	steps, err := r.load("route1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, len(steps))
+}
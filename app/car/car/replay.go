@@ -0,0 +1,239 @@
+package car
+
+import (
+	"errors"
+	"log"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"github.com/shanghuiyang/rpi-devices/util"
+)
+
+// StartRecording starts to record the ops received by Do into a session named name
+func (c *Car) StartRecording(name string) error {
+	if err := c.recorder.begin(name); err != nil {
+		log.Printf("[car]failed to start recording, error: %v", err)
+		return err
+	}
+	log.Printf("[car]recording %v", name)
+	return nil
+}
+
+// StopRecording stops and saves the current recording
+func (c *Car) StopRecording() error {
+	if err := c.recorder.end(); err != nil {
+		log.Printf("[car]failed to stop recording, error: %v", err)
+		return err
+	}
+	log.Printf("[car]recording stopped")
+	return nil
+}
+
+// Recordings lists the names of the saved recordings
+func (c *Car) Recordings() ([]string, error) {
+	return c.recorder.list()
+}
+
+// Replay replays a saved recording in the background
+func (c *Car) Replay(name string, mode ReplayMode) error {
+	if c.isReplaying() {
+		return errors.New("another recording is replaying")
+	}
+	if c.selfdriving || c.selftracking || c.speechdriving || c.selfnav {
+		return errors.New("can't replay in self-driving, self-tracking, speech-driving or nav mode")
+	}
+	if c.recorder.recording() {
+		return errors.New("can't replay while recording")
+	}
+	steps, err := c.recorder.load(name)
+	if err != nil {
+		log.Printf("[car]failed to load recording %v, error: %v", name, err)
+		return err
+	}
+	if len(steps) == 0 {
+		return errors.New("empty recording")
+	}
+
+	switch mode {
+	case OpenLoop:
+	case ClosedLoop:
+		if c.gy25 == nil {
+			return errors.New("can't replay in closed-loop without gy-25")
+		}
+	default:
+		return errors.New("invalid replay mode")
+	}
+
+	if !atomic.CompareAndSwapInt32(&c.replaying, 0, 1) {
+		return errors.New("another recording is replaying")
+	}
+	log.Printf("[car]replay %v in %v-loop", name, mode)
+	go func() {
+		if mode == ClosedLoop {
+			c.replayClosedLoop(steps)
+		} else {
+			c.replayOpenLoop(steps)
+		}
+		c.chOp <- stop
+		atomic.StoreInt32(&c.replaying, 0)
+		log.Printf("[car]replay %v done", name)
+	}()
+	return nil
+}
+
+// StopReplay stops the replaying
+func (c *Car) StopReplay() {
+	atomic.StoreInt32(&c.replaying, 0)
+}
+
+// isReplaying tells if a recording is replaying
+func (c *Car) isReplaying() bool {
+	return atomic.LoadInt32(&c.replaying) == 1
+}
+
+// replayOpenLoop sends the ops at the same pace as they were recorded
+func (c *Car) replayOpenLoop(steps []*Step) {
+	start := time.Now()
+	for _, s := range steps {
+		for c.isReplaying() {
+			wait := time.Duration(s.Offset)*time.Millisecond - time.Since(start)
+			if wait <= 0 {
+				break
+			}
+			if wait > 100*time.Millisecond {
+				wait = 100 * time.Millisecond
+			}
+			time.Sleep(wait)
+		}
+		if !c.isReplaying() {
+			return
+		}
+		if !replayable(s.Op) {
+			continue
+		}
+		c.chOp <- s.Op
+	}
+}
+
+// replayClosedLoop splits the recording into segments, each one starts with an op and ends at the next op.
+// a forward or backward segment is replayed by turning to the recorded heading
+// and going the recorded distance (in encoder ticks, or in time if the car hasn't an encoder),
+// and a left or right segment is replayed by turning the recorded angle.
+func (c *Car) replayClosedLoop(steps []*Step) {
+	yaw0, err := c.heading()
+	if err != nil {
+		log.Printf("[car]failed to get heading, error: %v", err)
+		return
+	}
+	for i, s := range steps {
+		if !c.isReplaying() {
+			return
+		}
+		if !replayable(s.Op) {
+			continue
+		}
+		var next *Step
+		if i+1 < len(steps) {
+			next = steps[i+1]
+		}
+
+		switch s.Op {
+		case forward, backward:
+			if next == nil {
+				continue
+			}
+			yaw, err := c.heading()
+			if err != nil {
+				log.Printf("[car]failed to get heading, error: %v", err)
+				return
+			}
+			// the heading relative to the start of the replay should match the recorded one
+			if d := angleDiff(s.Yaw-steps[0].Yaw, yaw-yaw0); math.Abs(d) > 5 {
+				c.turn(int(d))
+			}
+			c.chOp <- s.Op
+			c.goDist(next.Ticks-s.Ticks, time.Duration(next.Offset-s.Offset)*time.Millisecond)
+			c.chOp <- stop
+		case left, right:
+			if next == nil {
+				continue
+			}
+			c.turn(int(angleDiff(next.Yaw, s.Yaw)))
+		default:
+			c.chOp <- s.Op
+		}
+	}
+}
+
+// goDist keeps the car going until the encoder counts ticks,
+// or until timeout if the car hasn't an encoder.
+func (c *Car) goDist(ticks int, timeout time.Duration) {
+	if c.encoder == nil || ticks <= 0 {
+		for end := time.Now().Add(timeout); c.isReplaying() && time.Now().Before(end); {
+			util.DelayMs(10)
+		}
+		return
+	}
+	c.waitTicks(ticks, func() bool { return c.isReplaying() })
+}
+
+// countTicks counts the ticks of the encoder all the time,
+// it's the only reader of the encoder, the others wait on the count with waitTicks().
+func (c *Car) countTicks() {
+	c.encoder.Start()
+	defer c.encoder.Stop()
+	for {
+		if c.encoder.Count1() > 0 {
+			atomic.AddInt64(&c.ticks, 1)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// tickCount returns the ticks counted by countTicks() so far
+func (c *Car) tickCount() int64 {
+	return atomic.LoadInt64(&c.ticks)
+}
+
+// waitTicks waits until the encoder counts n more ticks, or until keep returns false
+func (c *Car) waitTicks(n int, keep func() bool) {
+	end := atomic.LoadInt64(&c.ticks) + int64(n)
+	for atomic.LoadInt64(&c.ticks) < end && keep() {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// sense fills the sensor context of a step.
+// it doesn't fail the step if a sensor isn't available,
+// and it takes the latest gps fix rather than waiting for the gps.
+func (c *Car) sense(s *Step) {
+	if c.gy25 != nil {
+		if yaw, err := c.heading(); err == nil {
+			s.Yaw = yaw
+		}
+	}
+	s.Ticks = int(atomic.LoadInt64(&c.ticks))
+	if fix := c.lastFix(); fix != nil {
+		s.Lat, s.Lon = fix.Lat, fix.Lon
+	}
+}
+
+// heading returns the yaw from gy-25.
+// the yaw increases when the car turns right, the same as the angles of turn().
+func (c *Car) heading() (float64, error) {
+	if c.gy25 == nil {
+		return 0, errors.New("without gy-25")
+	}
+	yaw, _, _, err := c.gy25.Angles()
+	return yaw, err
+}
+
+// replayable tells if an op can be replayed, the mode switches won't be replayed
+func replayable(op Op) bool {
+	switch op {
+	case forward, backward, left, right, stop, beep, servoleft, servoright, servoahead:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package car
+
+import (
+	"errors"
+	"image"
+
+	"github.com/shanghuiyang/rpi-devices/util/cv/detect"
+)
+
+const (
+	// defaultDetector is the detector for self-tracking if no detector is configured
+	defaultDetector = "color"
+)
+
+// tracker locates the target for self-tracking, see detect.Tracker
+type tracker interface {
+	Locate() (bool, *image.Rectangle)
+	MiddleXY(rect *image.Rectangle) (x int, y int)
+	Last() *detect.Box
+	Close()
+}
+
+// TrackedBoxes returns the bounding box of the target in the frame when self-tracking
+func (c *Car) TrackedBoxes() []image.Rectangle {
+	t := c.tracker
+	if !c.selftracking || t == nil {
+		return nil
+	}
+	b := t.Last()
+	if b == nil {
+		return nil
+	}
+	return []image.Rectangle{b.Rect}
+}
+
+// openCamera opens the camera for self-tracking if no frame source is configured.
+// it's replaced by the camera of OpenCV when building with -tags=gocv.
+var openCamera = func() (detect.FrameSource, error) {
+	return nil, errors.New("no frame source, need an url of mjpeg stream or a directory of images")
+}
+
+// newTracker creates a tracker with the detector picked by name
+func (c *Car) newTracker() (tracker, error) {
+	name := c.detector
+	if name == "" {
+		name = defaultDetector
+	}
+	det, err := detect.New(name, c.detectorCfg)
+	if err != nil {
+		return nil, err
+	}
+	src, err := c.openFrameSource()
+	if err != nil {
+		return nil, err
+	}
+	return detect.NewTracker(src, det)
+}
+
+// openFrameSource opens the configured frame source, or the camera if it isn't configured
+func (c *Car) openFrameSource() (detect.FrameSource, error) {
+	if c.frameSrc != "" {
+		return detect.OpenSource(c.frameSrc)
+	}
+	return openCamera()
+}
@@ -0,0 +1,14 @@
+// +build gocv
+
+package car
+
+import (
+	"github.com/shanghuiyang/rpi-devices/util/cv"
+	"github.com/shanghuiyang/rpi-devices/util/cv/detect"
+)
+
+func init() {
+	openCamera = func() (detect.FrameSource, error) {
+		return cv.NewCamera(0)
+	}
+}
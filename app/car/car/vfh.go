@@ -0,0 +1,378 @@
+package car
+
+import (
+	"math"
+	"sync"
+)
+
+// vfh is a local planner based on the vector field histogram (VFH) method.
+// It keeps a short-term memory of obstacles in the car's own frame,
+// builds a polar obstacle histogram from them, and picks a steering
+// direction and a speed.
+//
+// the car's frame:
+//
+//	     x /|\  0 degree
+//	        |
+//	-90 ----*----> y  90 degree
+//	       car
+//
+// angles are in degree, negative on the left and positive on the right,
+// which is the same as the angles of the servo.
+// distances are in cm.
+type vfh struct {
+	sync.Mutex
+	obstacles []*obstacle
+	prevSteer float64
+	last      vfhDecision
+	cfg       *vfhConfig
+}
+
+type vfhConfig struct {
+	sectorSize   float64 // size of a sector in degree
+	windowSize   float64 // only obstacles within the window will be considered
+	carRadius    float64 // obstacles are enlarged by the radius of the car plus the safe dist
+	safeDist     float64
+	smoothing    int     // number of neighbour sectors for smoothing the histogram
+	threshold    float64 // sectors with a density above the threshold are blocked
+	maxObstacles int     // the max number of obstacles in the memory
+	wideValley   int     // a valley wider than this number of sectors is a wide valley
+	targetWeight float64 // the weight of the deviation from the target direction
+	prevWeight   float64 // the weight of the deviation from the previous steering
+	maxSpeed     float64 // in percent
+	minSpeed     float64 // in percent
+}
+
+// obstacle is an obstacle in the car's frame
+type obstacle struct {
+	x         float64
+	y         float64
+	certainty float64
+}
+
+// vfhDecision is the decision of the planner
+type vfhDecision struct {
+	Steer   float64 `json:"steer"`
+	Speed   float64 `json:"speed"`
+	Blocked bool    `json:"blocked"`
+}
+
+var defaultVFHConfig = &vfhConfig{
+	sectorSize:   5,
+	windowSize:   200,
+	carRadius:    12,
+	safeDist:     8,
+	smoothing:    2,
+	threshold:    0.6,
+	maxObstacles: 256,
+	wideValley:   12,
+	targetWeight: 5,
+	prevWeight:   2,
+	maxSpeed:     50,
+	minSpeed:     20,
+}
+
+func newVFH(cfg *vfhConfig) *vfh {
+	if cfg == nil {
+		cfg = defaultVFHConfig
+	}
+	return &vfh{
+		cfg: cfg,
+	}
+}
+
+// addReading adds a reading from the distance meter pointing to angle.
+// the reading which is out of the window only clears the memory in that direction.
+func (v *vfh) addReading(angle, dist float64) {
+	if dist < 0 {
+		return
+	}
+	v.Lock()
+	defer v.Unlock()
+
+	rad := toRad(angle)
+	// forget the obstacles which are closer than the new reading in the same direction,
+	// they have moved or they were ghosts
+	v.clearBefore(angle, dist)
+	if dist > v.cfg.windowSize {
+		return
+	}
+	v.add(&obstacle{
+		x:         dist * math.Cos(rad),
+		y:         dist * math.Sin(rad),
+		certainty: 1,
+	})
+}
+
+// addCollision adds an obstacle right in front of the collision switch pointing to angle.
+// collision switches are reliable, so the obstacle has a high certainty.
+func (v *vfh) addCollision(angle float64) {
+	v.Lock()
+	defer v.Unlock()
+
+	rad := toRad(angle)
+	d := v.cfg.carRadius
+	v.add(&obstacle{
+		x:         d * math.Cos(rad),
+		y:         d * math.Sin(rad),
+		certainty: 3,
+	})
+}
+
+// move updates the memory with the odometry of the car.
+// dist is the distance the car moved forward (negative for backward),
+// and turn is the angle the car turned (negative for left).
+func (v *vfh) move(dist, turn float64) {
+	v.Lock()
+	defer v.Unlock()
+
+	rad := toRad(turn)
+	cos, sin := math.Cos(rad), math.Sin(rad)
+	var kept []*obstacle
+	for _, o := range v.obstacles {
+		x := o.x - dist
+		y := o.y
+		// rotate the obstacle to the opposite direction of the turn
+		o.x = x*cos + y*sin
+		o.y = -x*sin + y*cos
+		if math.Hypot(o.x, o.y) > v.cfg.windowSize {
+			continue
+		}
+		kept = append(kept, o)
+	}
+	v.obstacles = kept
+	v.prevSteer -= turn
+}
+
+// reset forgets all obstacles
+func (v *vfh) reset() {
+	v.Lock()
+	defer v.Unlock()
+	v.obstacles = nil
+	v.prevSteer = 0
+}
+
+// decide picks the steering angle closest to the target in a free valley of the histogram.
+// Blocked will be true if there isn't any free valley.
+func (v *vfh) decide(target float64) *vfhDecision {
+	v.Lock()
+	defer v.Unlock()
+
+	hist := v.smooth(v.histogram())
+	n := len(hist)
+	free := make([]bool, n)
+	allFree := true
+	anyFree := false
+	for i, h := range hist {
+		free[i] = h < v.cfg.threshold
+		allFree = allFree && free[i]
+		anyFree = anyFree || free[i]
+	}
+	if !anyFree {
+		v.last = vfhDecision{Blocked: true}
+		return &vfhDecision{Blocked: true}
+	}
+
+	var candidates []float64
+	if allFree {
+		candidates = append(candidates, target)
+	} else {
+		for _, valley := range v.valleys(free) {
+			candidates = append(candidates, v.candidates(valley, target)...)
+		}
+	}
+
+	best := 0.0
+	bestCost := math.MaxFloat64
+	for _, c := range candidates {
+		cost := v.cfg.targetWeight*math.Abs(angleDiff(c, target)) +
+			v.cfg.prevWeight*math.Abs(angleDiff(c, v.prevSteer))
+		if cost < bestCost {
+			bestCost = cost
+			best = c
+		}
+	}
+	if bestCost == math.MaxFloat64 {
+		v.last = vfhDecision{Blocked: true}
+		return &vfhDecision{Blocked: true}
+	}
+
+	// slow down when obstacles are close to the steering direction
+	h := math.Min(hist[v.sector(best)], v.cfg.threshold)
+	speed := v.cfg.maxSpeed * (1 - h/v.cfg.threshold)
+	if speed < v.cfg.minSpeed {
+		speed = v.cfg.minSpeed
+	}
+	v.prevSteer = best
+	v.last = vfhDecision{
+		Steer: best,
+		Speed: speed,
+	}
+	return &vfhDecision{
+		Steer: best,
+		Speed: speed,
+	}
+}
+
+// lastDecision returns the latest decision
+func (v *vfh) lastDecision() vfhDecision {
+	v.Lock()
+	defer v.Unlock()
+	return v.last
+}
+
+// histogram builds the polar obstacle density.
+// each obstacle contributes certainty^2 * (1 - d/window) to the sectors
+// it covers after being enlarged by the radius of the car.
+func (v *vfh) histogram() []float64 {
+	n := int(360 / v.cfg.sectorSize)
+	hist := make([]float64, n)
+	r := v.cfg.carRadius + v.cfg.safeDist
+	for _, o := range v.obstacles {
+		d := math.Hypot(o.x, o.y)
+		if d > v.cfg.windowSize {
+			continue
+		}
+		m := o.certainty * o.certainty * (1 - d/v.cfg.windowSize)
+		angle := toDegree(math.Atan2(o.y, o.x))
+		enlarge := 90.0
+		if d > r {
+			enlarge = toDegree(math.Asin(r / d))
+		}
+		// the density of an obstacle closer than the enlarged radius is full
+		if d <= r {
+			m = math.Max(m, v.cfg.threshold)
+		}
+		for a := angle - enlarge; a <= angle+enlarge; a += v.cfg.sectorSize / 2 {
+			i := v.sector(a)
+			hist[i] = math.Max(hist[i], m)
+		}
+	}
+	return hist
+}
+
+func (v *vfh) smooth(hist []float64) []float64 {
+	n := len(hist)
+	l := v.cfg.smoothing
+	smoothed := make([]float64, n)
+	for i := range hist {
+		var sum, w float64
+		for k := -l; k <= l; k++ {
+			wk := float64(l + 1 - abs(k))
+			sum += wk * hist[(i+k+n)%n]
+			w += wk
+		}
+		smoothed[i] = sum / w
+	}
+	return smoothed
+}
+
+// valleys returns the [start, end] sectors of continuous free sectors
+func (v *vfh) valleys(free []bool) [][2]int {
+	n := len(free)
+	// start from a blocked sector so that a valley across 180 degree won't be split
+	first := 0
+	for i := range free {
+		if !free[i] {
+			first = i
+			break
+		}
+	}
+	var valleys [][2]int
+	start := -1
+	for k := 1; k <= n; k++ {
+		i := (first + k) % n
+		if free[i] && start < 0 {
+			start = first + k
+		}
+		if !free[i] && start >= 0 {
+			valleys = append(valleys, [2]int{start, first + k - 1})
+			start = -1
+		}
+	}
+	return valleys
+}
+
+// candidates returns the steering candidates of a valley.
+// a narrow valley has its center as the only candidate,
+// while a wide valley has the target (if it is inside) and the directions
+// next to its borders which keep a distance from the obstacles.
+func (v *vfh) candidates(valley [2]int, target float64) []float64 {
+	width := valley[1] - valley[0] + 1
+	center := v.angle(valley[0] + width/2)
+	if width <= v.cfg.wideValley {
+		return []float64{center}
+	}
+	half := v.cfg.wideValley / 2
+	left := v.angle(valley[0] + half)
+	right := v.angle(valley[1] - half)
+	cands := []float64{left, right}
+	if angleDiff(target, left) >= 0 && angleDiff(right, target) >= 0 {
+		cands = append(cands, target)
+	}
+	return cands
+}
+
+// sector returns the index of the sector which the angle falls in
+func (v *vfh) sector(angle float64) int {
+	n := int(360 / v.cfg.sectorSize)
+	a := math.Mod(angle+360+v.cfg.sectorSize/2, 360)
+	return int(a/v.cfg.sectorSize) % n
+}
+
+// angle returns the center angle of the i-th sector in (-180, 180]
+func (v *vfh) angle(i int) float64 {
+	return normAngle(float64(i) * v.cfg.sectorSize)
+}
+
+func (v *vfh) add(o *obstacle) {
+	v.obstacles = append(v.obstacles, o)
+	if n := len(v.obstacles); n > v.cfg.maxObstacles {
+		v.obstacles = v.obstacles[n-v.cfg.maxObstacles:]
+	}
+}
+
+func (v *vfh) clearBefore(angle, dist float64) {
+	var kept []*obstacle
+	for _, o := range v.obstacles {
+		a := toDegree(math.Atan2(o.y, o.x))
+		d := math.Hypot(o.x, o.y)
+		if math.Abs(angleDiff(a, angle)) < v.cfg.sectorSize/2 && d < dist-v.cfg.safeDist {
+			continue
+		}
+		kept = append(kept, o)
+	}
+	v.obstacles = kept
+}
+
+func toRad(degree float64) float64 {
+	return degree * math.Pi / 180
+}
+
+func toDegree(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// normAngle normalizes the angle to (-180, 180]
+func normAngle(a float64) float64 {
+	a = math.Mod(a, 360)
+	if a > 180 {
+		a -= 360
+	}
+	if a <= -180 {
+		a += 360
+	}
+	return a
+}
+
+// angleDiff returns a - b in (-180, 180]
+func angleDiff(a, b float64) float64 {
+	return normAngle(a - b)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,149 @@
+package car
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// simWorld is a simulated world with walls for testing the planner
+type simWorld struct {
+	walls [][4]float64 // x1, y1, x2, y2
+	x     float64
+	y     float64
+	yaw   float64 // in degree
+}
+
+// dist casts a ray from the car to the angle (in the car's frame)
+// and returns the distance to the closest wall, or maxd if nothing is hit.
+func (w *simWorld) dist(angle, maxd float64) float64 {
+	rad := toRad(w.yaw + angle)
+	dx, dy := math.Cos(rad), math.Sin(rad)
+	best := maxd
+	for _, wall := range w.walls {
+		ex, ey := wall[2]-wall[0], wall[3]-wall[1]
+		den := dx*ey - dy*ex
+		if den == 0 {
+			continue
+		}
+		t := ((wall[0]-w.x)*ey - (wall[1]-w.y)*ex) / den
+		u := ((wall[0]-w.x)*dy - (wall[1]-w.y)*dx) / den
+		if t > 0 && u >= 0 && u <= 1 && t < best {
+			best = t
+		}
+	}
+	return best
+}
+
+func (w *simWorld) sweep(v *vfh) {
+	for _, a := range scanningAngles {
+		v.addReading(float64(a), w.dist(float64(a), 450))
+	}
+}
+
+func (w *simWorld) drive(v *vfh, turn, dist float64) {
+	w.yaw += turn
+	rad := toRad(w.yaw)
+	w.x += dist * math.Cos(rad)
+	w.y += dist * math.Sin(rad)
+	v.move(dist, turn)
+}
+
+func (w *simWorld) clearance() float64 {
+	d := math.MaxFloat64
+	for a := -180.0; a < 180; a += 5 {
+		d = math.Min(d, w.dist(a, 1000))
+	}
+	return d
+}
+
+func TestVFHDecide(t *testing.T) {
+	testCases := []struct {
+		desc    string
+		walls   [][4]float64
+		blocked bool
+		steer   func(s float64) bool
+	}{
+		{
+			desc:  "open space",
+			walls: nil,
+			steer: func(s float64) bool { return s == 0 },
+		},
+		{
+			desc: "wall ahead with an opening on the right",
+			walls: [][4]float64{
+				{40, -200, 40, 30},
+			},
+			steer: func(s float64) bool { return s > 20 },
+		},
+		{
+			desc: "corner on the ahead and the right",
+			walls: [][4]float64{
+				{40, -30, 40, 200},
+				{-50, 30, 40, 30},
+			},
+			steer: func(s float64) bool { return s < -20 },
+		},
+		{
+			desc: "trapped in a box",
+			walls: [][4]float64{
+				{15, -15, 15, 15},
+				{-15, -15, -15, 15},
+				{-15, 15, 15, 15},
+				{-15, -15, 15, -15},
+			},
+			blocked: true,
+		},
+	}
+
+	for _, test := range testCases {
+		v := newVFH(nil)
+		w := &simWorld{walls: test.walls}
+		w.sweep(v)
+		// sweep the rear as well, like the car turning around
+		for a := 100.0; a < 270; a += 15 {
+			v.addReading(a, w.dist(a, 450))
+		}
+		d := v.decide(0)
+		assert.Equal(t, test.blocked, d.Blocked, test.desc)
+		if test.blocked {
+			continue
+		}
+		assert.True(t, test.steer(d.Steer), "%v: steer=%v", test.desc, d.Steer)
+	}
+}
+
+func TestVFHMove(t *testing.T) {
+	v := newVFH(nil)
+	v.addReading(0, 50)
+	v.move(30, 0)
+	assert.Len(t, v.obstacles, 1)
+	assert.InDelta(t, 20, v.obstacles[0].x, 0.001)
+
+	v.move(0, 90)
+	assert.InDelta(t, 0, v.obstacles[0].x, 0.001)
+	assert.InDelta(t, -20, v.obstacles[0].y, 0.001)
+
+	v.move(-300, 0)
+	assert.Len(t, v.obstacles, 0)
+}
+
+func TestVFHCorridor(t *testing.T) {
+	v := newVFH(nil)
+	w := &simWorld{
+		walls: [][4]float64{
+			{-50, -40, 1000, -40},
+			{-50, 40, 1000, 40},
+		},
+		yaw: 20,
+	}
+	for i := 0; i < 60; i++ {
+		w.sweep(v)
+		d := v.decide(-w.yaw)
+		assert.False(t, d.Blocked)
+		w.drive(v, d.Steer, 10)
+		assert.True(t, w.clearance() > 10, "step %v: crashed at (%.0f, %.0f)", i, w.x, w.y)
+	}
+	assert.True(t, w.x > 400, "x=%.0f", w.x)
+}
@@ -5,6 +5,8 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -13,10 +15,14 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/shanghuiyang/rpi-devices/app/car/car"
+	"github.com/shanghuiyang/rpi-devices/app/car/telemetry"
 	"github.com/shanghuiyang/rpi-devices/dev"
 	"github.com/shanghuiyang/rpi-devices/util"
+	"github.com/shanghuiyang/rpi-devices/util/cv/detect"
+	"github.com/shanghuiyang/rpi-devices/util/cv/stream"
 	"github.com/shanghuiyang/rpi-devices/util/geo"
 	"github.com/stianeikeland/go-rpio"
 )
@@ -39,6 +45,18 @@ const (
 	pinTrig      = 21
 	pinEcho      = 26
 
+	telemetryDir      = "telemetry"
+	telemetryMaxSize  = 4 * 1024 * 1024 // 4M per file
+	telemetryMaxFiles = 32
+
+	// the stream of motion, it's used for self-tracking
+	motionStream = "http://localhost:8081"
+	// videoAddr is the address of the video with overlays, which relays the stream of motion
+	videoAddr = ":8085"
+	videoFPS  = 5
+	// trackingProfile is the hsv profile for self-tracking, tuned by app/hsvtuner
+	trackingProfile = "tracking.json"
+
 	ipPattern          = "((000.000.000.000))"
 	selfDrivingState   = "((selfdriving-state))"
 	selfTrackingState  = "((selftracking-state))"
@@ -49,6 +67,31 @@ const (
 	speechDrivingEnabled = "((speechdriving-enabled))"
 )
 
+// video relays the stream of motion with the time, the target of self-tracking and the telemetry
+func video(ctx context.Context, c *car.Car) {
+	src, err := detect.OpenSource(motionStream)
+	if err != nil {
+		log.Printf("[carapp]failed to open the stream of motion, error: %v", err)
+		return
+	}
+	defer src.Close()
+
+	cfg := &stream.Config{
+		FPS: videoFPS,
+		Overlays: []stream.Overlay{
+			stream.Timestamp(),
+			stream.Boxes(c.TrackedBoxes),
+			stream.Text(func() string {
+				fr := c.Telemetry()
+				return fmt.Sprintf("%v %v speed:%v yaw:%.0f dist:%.0fcm", fr.Mode, fr.Motor, fr.Speed, fr.Yaw, fr.Dist)
+			}),
+		},
+	}
+	if err := stream.ListenAndServe(ctx, videoAddr, src, cfg); err != nil {
+		log.Printf("[carapp]failed to serve the video, error: %v", err)
+	}
+}
+
 type server struct {
 	car         *car.Car
 	pageContext []byte
@@ -116,9 +159,11 @@ func main() {
 	if servo == nil {
 		log.Printf("[carapp]failed to new a sg90, will build a car without servo")
 	}
-	cam := dev.NewCamera()
-	if cam == nil {
-		log.Printf("[carapp]failed to new a camera, will build a car without cameras")
+	// the camera only takes a photo now and then, it grabs a single frame from the stream of motion for each photo
+	cam, err := dev.NewCamera(dev.NewMJPEGSnapshotSource(motionStream), nil)
+	if err != nil {
+		log.Printf("[carapp]failed to open a camera, will build a car without cameras, error: %v", err)
+		cam = nil
 	}
 
 	var gps *dev.GPS = nil
@@ -134,25 +179,49 @@ func main() {
 	// 	log.Printf("[carapp]failed to new a LC12S, error: %v", err)
 	// }
 
+	tm, err := telemetry.NewWriter(telemetryDir, telemetryMaxSize, telemetryMaxFiles)
+	if err != nil {
+		log.Printf("[carapp]failed to new a telemetry writer, will build a car without black-box, error: %v", err)
+		tm = nil
+	}
+
+	var detectorCfg string
+	if _, err := os.Stat(trackingProfile); err == nil {
+		detectorCfg = trackingProfile
+	}
+
 	car := car.New(&car.Config{
-		Engine:     eng,
-		Servo:      servo,
-		DistMeter:  ult,
-		GY25:       gy25,
-		Collisions: collisions,
-		Horn:       horn,
-		Led:        led,
-		Camera:     cam,
-		GPS:        gps,
-		LC12S:      lc12s,
+		Engine:         eng,
+		Servo:          servo,
+		DistMeter:      ult,
+		GY25:           gy25,
+		Collisions:     collisions,
+		Horn:           horn,
+		Led:            led,
+		Camera:         cam,
+		GPS:            gps,
+		LC12S:          lc12s,
+		Telemetry:      tm,
+		Detector:       "color",
+		DetectorConfig: detectorCfg,
+		FrameSource:    motionStream,
+		Failsafe: &car.FailsafeConfig{
+			Action:  car.FailsafeReturnHome,
+			Timeout: 10 * time.Second,
+			Wait:    30 * time.Second,
+		},
 	})
 	if car == nil {
 		log.Fatal("failed to new a car")
 		return
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	go video(ctx, car)
+
 	svr := newServer(car)
 	util.WaitQuit(func() {
+		cancel()
 		svr.stop()
 		if ult != nil {
 			ult.Close()
@@ -172,6 +241,9 @@ func main() {
 		if lc12s != nil {
 			lc12s.Close()
 		}
+		if tm != nil {
+			tm.Close()
+		}
 		rpio.Close()
 	})
 	if err := svr.start(); err != nil {
@@ -194,6 +266,11 @@ func (s *server) start() error {
 	log.Printf("[carapp]car started successfully")
 
 	http.HandleFunc("/", s.handler)
+	http.HandleFunc("/recordings", s.recordingsHandler)
+	http.HandleFunc("/recording", s.recordingHandler)
+	http.HandleFunc("/replay", s.replayHandler)
+	http.HandleFunc("/heartbeat", s.heartbeatHandler)
+	http.HandleFunc("/state", s.stateHandler)
 	if err := http.ListenAndServe(":8080", nil); err != nil {
 		return err
 	}
@@ -286,6 +363,7 @@ func (s *server) loadHomePage(w http.ResponseWriter, r *http.Request) error {
 }
 
 func (s *server) handler(w http.ResponseWriter, r *http.Request) {
+	s.car.Heartbeat()
 	switch r.Method {
 	case "GET":
 		s.loadHomePage(w, r)
@@ -308,3 +386,103 @@ func (s *server) handler(w http.ResponseWriter, r *http.Request) {
 		s.car.Do(car.Op(op))
 	}
 }
+
+// recordingsHandler lists the saved recordings
+// GET /recordings
+func (s *server) recordingsHandler(w http.ResponseWriter, r *http.Request) {
+	names, err := s.car.Recordings()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recording, replaying := s.car.GetRecordingState()
+	resp := struct {
+		Recording  bool     `json:"recording"`
+		Replaying  bool     `json:"replaying"`
+		Recordings []string `json:"recordings"`
+	}{
+		Recording:  recording,
+		Replaying:  replaying,
+		Recordings: names,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// recordingHandler starts or stops recording
+// POST /recording action=start&name=xxx
+// POST /recording action=stop
+func (s *server) recordingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var err error
+	switch action := r.FormValue("action"); action {
+	case "start":
+		err = s.car.StartRecording(r.FormValue("name"))
+	case "stop":
+		err = s.car.StopRecording()
+	default:
+		err = fmt.Errorf("invalid action: %v", action)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// replayHandler replays a recording or stops replaying
+// POST /replay action=start&name=xxx&mode=open|closed
+// POST /replay action=stop
+func (s *server) replayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var err error
+	switch action := r.FormValue("action"); action {
+	case "start":
+		mode := car.ReplayMode(r.FormValue("mode"))
+		if mode == "" {
+			mode = car.OpenLoop
+		}
+		err = s.car.Replay(r.FormValue("name"), mode)
+	case "stop":
+		s.car.StopReplay()
+	default:
+		err = fmt.Errorf("invalid action: %v", action)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// heartbeatHandler keeps the link to the car alive, the web page calls it periodically
+// POST /heartbeat
+func (s *server) heartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	s.car.Heartbeat()
+}
+
+// stateHandler reports the state of the car
+// GET /state
+func (s *server) stateHandler(w http.ResponseWriter, r *http.Request) {
+	selfDriving, selfTracking, speechDriving := s.car.GetState()
+	recording, replaying := s.car.GetRecordingState()
+	resp := struct {
+		SelfDriving   bool           `json:"selfdriving"`
+		SelfTracking  bool           `json:"selftracking"`
+		SpeechDriving bool           `json:"speechdriving"`
+		Recording     bool           `json:"recording"`
+		Replaying     bool           `json:"replaying"`
+		Home          *car.HomeState `json:"home"`
+	}{
+		SelfDriving:   selfDriving,
+		SelfTracking:  selfTracking,
+		SpeechDriving: speechDriving,
+		Recording:     recording,
+		Replaying:     replaying,
+		Home:          s.car.GetHomeState(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,154 @@
+package telemetry
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/shanghuiyang/rpi-devices/util/geo"
+)
+
+var csvHeader = []string{
+	"timestamp", "mode", "opq", "motor", "speed", "servo", "dist",
+	"yaw", "pitch", "roll", "ticks", "fix", "lat", "lon",
+	"dest_lat", "dest_lon", "target_lat", "target_lon",
+	"steer", "plan_speed", "blocked",
+}
+
+// WriteCSV exports the frames in csv
+func WriteCSV(w io.Writer, frames []*Frame) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	f := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	for _, fr := range frames {
+		record := []string{
+			fr.Time.Format(time.RFC3339Nano),
+			fr.Mode,
+			strconv.Itoa(fr.OpQueue),
+			fr.Motor,
+			strconv.FormatUint(uint64(fr.Speed), 10),
+			strconv.Itoa(fr.Servo),
+			f(fr.Dist),
+			f(fr.Yaw),
+			f(fr.Pitch),
+			f(fr.Roll),
+			strconv.FormatInt(fr.Ticks, 10),
+			strconv.FormatBool(fr.Fix),
+			f(fr.Lat),
+			f(fr.Lon),
+			f(fr.DestLat),
+			f(fr.DestLon),
+			f(fr.TargetLat),
+			f(fr.TargetLon),
+			f(fr.Steer),
+			f(fr.PlanSpeed),
+			strconv.FormatBool(fr.Blocked),
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Waypoints returns the distinct nav targets and destinations in the frames in order
+func Waypoints(frames []*Frame) []*geo.Point {
+	var pts []*geo.Point
+	seen := map[geo.Point]bool{}
+	add := func(lat, lon float64) {
+		if lat == 0 && lon == 0 {
+			return
+		}
+		pt := geo.Point{Lat: lat, Lon: lon}
+		if seen[pt] {
+			return
+		}
+		seen[pt] = true
+		pts = append(pts, &pt)
+	}
+	for _, fr := range frames {
+		add(fr.TargetLat, fr.TargetLon)
+	}
+	for _, fr := range frames {
+		add(fr.DestLat, fr.DestLon)
+	}
+	return pts
+}
+
+// PlotSVG plots the track of the frames with gps fix against the waypoints
+//
+//   - the track: blue polyline, starts from a green dot
+//   - the waypoints: red circles with their order
+func PlotSVG(w io.Writer, frames []*Frame, waypoints []*geo.Point) error {
+	const (
+		width  = 800.0
+		height = 800.0
+		margin = 40.0
+	)
+
+	var track []*geo.Point
+	for _, fr := range frames {
+		if fr.Fix {
+			track = append(track, &geo.Point{Lat: fr.Lat, Lon: fr.Lon})
+		}
+	}
+
+	all := append(append([]*geo.Point{}, track...), waypoints...)
+	if len(all) == 0 {
+		_, err := fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f"></svg>`+"\n", width, height)
+		return err
+	}
+
+	bbox := &geo.Bbox{
+		Left:   math.MaxFloat64,
+		Right:  -math.MaxFloat64,
+		Top:    -math.MaxFloat64,
+		Bottom: math.MaxFloat64,
+	}
+	for _, pt := range all {
+		bbox.Left = math.Min(bbox.Left, pt.Lon)
+		bbox.Right = math.Max(bbox.Right, pt.Lon)
+		bbox.Bottom = math.Min(bbox.Bottom, pt.Lat)
+		bbox.Top = math.Max(bbox.Top, pt.Lat)
+	}
+
+	// keep the aspect ratio, a degree of lon is shorter than a degree of lat
+	kx := math.Cos(geo.Rad((bbox.Top + bbox.Bottom) / 2))
+	scale := 1.0
+	if d := math.Max((bbox.Right-bbox.Left)*kx, bbox.Top-bbox.Bottom); d > 0 {
+		scale = (width - 2*margin) / d
+	}
+	xy := func(pt *geo.Point) (float64, float64) {
+		return margin + (pt.Lon-bbox.Left)*kx*scale, height - margin - (pt.Lat-bbox.Bottom)*scale
+	}
+
+	if _, err := fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%.0f" height="%.0f">`+"\n", width, height); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, `<rect width="100%%" height="100%%" fill="white"/>`+"\n")
+	if len(track) > 0 {
+		fmt.Fprintf(w, `<polyline fill="none" stroke="blue" stroke-width="2" points="`)
+		for _, pt := range track {
+			x, y := xy(pt)
+			fmt.Fprintf(w, "%.1f,%.1f ", x, y)
+		}
+		fmt.Fprintf(w, `"/>`+"\n")
+		x, y := xy(track[0])
+		fmt.Fprintf(w, `<circle cx="%.1f" cy="%.1f" r="5" fill="green"/>`+"\n", x, y)
+	}
+	for i, pt := range waypoints {
+		x, y := xy(pt)
+		fmt.Fprintf(w, `<circle cx="%.1f" cy="%.1f" r="6" fill="none" stroke="red" stroke-width="2"/>`+"\n", x, y)
+		fmt.Fprintf(w, `<text x="%.1f" y="%.1f" font-size="12" fill="red">%v</text>`+"\n", x+8, y-8, i+1)
+	}
+	_, err := fmt.Fprintf(w, "</svg>\n")
+	return err
+}
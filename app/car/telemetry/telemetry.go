@@ -0,0 +1,239 @@
+/*
+Package telemetry is the black-box of the car.
+
+The car samples all of its subsystems at a fixed rate into frames,
+and the frames are appended to jsonl files, one frame per line.
+A new file is created when the current one exceeds the max size,
+and the oldest files are removed when there are too many files.
+
+*/
+package telemetry
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	filePrefix = "telemetry-"
+	fileExt    = ".jsonl"
+	fileTime   = "20060102T150405.000"
+)
+
+// Frame is a snapshot of the car
+type Frame struct {
+	Time      time.Time `json:"t"`
+	Mode      string    `json:"mode"`
+	OpQueue   int       `json:"opq"`
+	Motor     string    `json:"motor"`
+	Speed     uint32    `json:"speed"`
+	Servo     int       `json:"servo"`
+	Dist      float64   `json:"dist"`
+	Yaw       float64   `json:"yaw"`
+	Pitch     float64   `json:"pitch"`
+	Roll      float64   `json:"roll"`
+	Ticks     int64     `json:"ticks"`
+	Fix       bool      `json:"fix"`
+	Lat       float64   `json:"lat,omitempty"`
+	Lon       float64   `json:"lon,omitempty"`
+	DestLat   float64   `json:"dlat,omitempty"`
+	DestLon   float64   `json:"dlon,omitempty"`
+	TargetLat float64   `json:"tlat,omitempty"`
+	TargetLon float64   `json:"tlon,omitempty"`
+	Steer     float64   `json:"steer"`
+	PlanSpeed float64   `json:"pspeed"`
+	Blocked   bool      `json:"blocked"`
+}
+
+// Writer appends frames to the rotated files in a directory
+type Writer struct {
+	mu       sync.Mutex
+	dir      string
+	maxSize  int64
+	maxFiles int
+	f        *os.File
+	w        *bufio.Writer
+	size     int64
+}
+
+// NewWriter creates a writer.
+// maxSize is the max size in bytes of a file, and maxFiles is the max number of files to keep.
+func NewWriter(dir string, maxSize int64, maxFiles int) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if maxFiles < 1 {
+		maxFiles = 1
+	}
+	return &Writer{
+		dir:      dir,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}, nil
+}
+
+// Write appends a frame
+func (w *Writer) Write(fr *Frame) error {
+	data, err := json.Marshal(fr)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil || (w.maxSize > 0 && w.size+int64(len(data)) > w.maxSize) {
+		if err := w.rotate(fr.Time); err != nil {
+			return err
+		}
+	}
+	n, err := w.w.Write(data)
+	w.size += int64(n)
+	return err
+}
+
+// Flush writes the buffered frames to the file
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.w == nil {
+		return nil
+	}
+	return w.w.Flush()
+}
+
+// Close ...
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.close()
+}
+
+func (w *Writer) close() error {
+	if w.f == nil {
+		return nil
+	}
+	w.w.Flush()
+	err := w.f.Close()
+	w.f = nil
+	w.w = nil
+	return err
+}
+
+func (w *Writer) rotate(t time.Time) error {
+	w.close()
+	name := filepath.Join(w.dir, filePrefix+t.Format(fileTime)+fileExt)
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f = f
+	w.w = bufio.NewWriter(f)
+	w.size = info.Size()
+
+	files, err := listFiles(w.dir)
+	if err != nil {
+		return err
+	}
+	for len(files) > w.maxFiles {
+		os.Remove(files[0])
+		files = files[1:]
+	}
+	return nil
+}
+
+// Read reads the frames in [from, to] from the files in dir.
+// a zero from or to means no limit on that side.
+func Read(dir string, from, to time.Time) ([]*Frame, error) {
+	files, err := listFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	var frames []*Frame
+	for _, file := range files {
+		frs, err := readFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, fr := range frs {
+			if !from.IsZero() && fr.Time.Before(from) {
+				continue
+			}
+			if !to.IsZero() && fr.Time.After(to) {
+				continue
+			}
+			frames = append(frames, fr)
+		}
+	}
+	return frames, nil
+}
+
+// readFile reads the frames of a file.
+// a broken last line is skipped, it happens if the car lost power while writing it,
+// but a broken line in the middle of the file fails the read.
+func readFile(file string) ([]*Frame, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var frames []*Frame
+	var badErr error
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if badErr != nil {
+			return nil, badErr
+		}
+		var fr Frame
+		if err := json.Unmarshal(line, &fr); err != nil {
+			badErr = fmt.Errorf("bad frame in %v at line %v, error: %v", file, n, err)
+			continue
+		}
+		frames = append(frames, &fr)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if badErr != nil {
+		log.Printf("[telemetry]skipped the broken last line, %v", badErr)
+	}
+	return frames, nil
+}
+
+// listFiles lists the telemetry files in dir from the oldest to the newest
+func listFiles(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || !strings.HasPrefix(name, filePrefix) || filepath.Ext(name) != fileExt {
+			continue
+		}
+		files = append(files, filepath.Join(dir, name))
+	}
+	sort.Strings(files)
+	return files, nil
+}
@@ -0,0 +1,77 @@
+package telemetry
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteAndRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "telemetry")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	w, err := NewWriter(dir, 512, 3)
+	assert.NoError(t, err)
+
+	start := time.Date(2021, 1, 2, 15, 4, 5, 0, time.Local)
+	for i := 0; i < 20; i++ {
+		fr := &Frame{
+			Time:  start.Add(time.Duration(i) * time.Second),
+			Mode:  "manual",
+			Motor: "forward",
+			Fix:   true,
+			Lat:   39.9557 + float64(i)*0.00001,
+			Lon:   116.4442,
+		}
+		assert.NoError(t, w.Write(fr))
+	}
+	assert.NoError(t, w.Close())
+
+	files, err := listFiles(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 3)
+
+	// the oldest frames have been rotated out
+	frames, err := Read(dir, time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	assert.True(t, len(frames) > 0 && len(frames) < 20)
+	assert.Equal(t, start.Add(19*time.Second).Unix(), frames[len(frames)-1].Time.Unix())
+
+	frames, err = Read(dir, start.Add(17*time.Second), start.Add(18*time.Second))
+	assert.NoError(t, err)
+	assert.Len(t, frames, 2)
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteCSV(&buf, frames))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], "timestamp,mode,"))
+
+	buf.Reset()
+	assert.NoError(t, PlotSVG(&buf, frames, Waypoints(frames)))
+	assert.Contains(t, buf.String(), "<polyline")
+}
+
+func TestReadBrokenLine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "telemetry")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	good := `{"t":"2021-01-02T15:04:05Z","mode":"manual"}`
+	// the car lost power while writing the last line
+	file := dir + "/" + filePrefix + "20210102T150405.000" + fileExt
+	assert.NoError(t, ioutil.WriteFile(file, []byte(good+"\n"+good+"\n"+`{"t":"2021-01`), 0644))
+	frames, err := Read(dir, time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	assert.Len(t, frames, 2)
+
+	assert.NoError(t, ioutil.WriteFile(file, []byte(good+"\n"+`{"t":"2021-01`+"\n"+good+"\n"), 0644))
+	_, err = Read(dir, time.Time{}, time.Time{})
+	assert.Error(t, err)
+}
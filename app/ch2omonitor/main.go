@@ -19,10 +19,20 @@ import (
 	"github.com/shanghuiyang/rpi-devices/dev"
 	"github.com/shanghuiyang/rpi-devices/iot"
 	"github.com/shanghuiyang/rpi-devices/util"
+	"github.com/shanghuiyang/rpi-devices/util/metrics"
 	"github.com/stianeikeland/go-rpio"
 )
 
 const (
+	// cloudName is the cloud of the values in the config file of the clouds
+	cloudName = "wsn"
+	// mqttName is the mqtt cloud of Home Assistant in the config file of the clouds
+	mqttName = "mqtt"
+	// queueFile persists the values which haven't been pushed to the cloud
+	queueFile = "ch2omonitor_queue.json"
+	// metricsAddr is the address of serving the metrics for prometheus
+	metricsAddr = ":9104"
+
 	pinBzr  = 17
 	pinLed  = 26
 	dioPin  = 11
@@ -51,15 +61,41 @@ func main() {
 	bzr := dev.NewBuzzer(pinBzr)
 	dsp := dev.NewLedDisplay(dioPin, rclkPin, sclkPin)
 
-	wsnCfg := &iot.WsnConfig{
-		Token: iot.WsnToken,
-		API:   iot.WsnNumericalAPI,
+	clouds, err := iot.LoadClouds("")
+	if err != nil {
+		log.Printf("[ch2omonitor]failed to load the clouds, error: %v", err)
+		return
+	}
+	cloud, err := clouds.Get(cloudName)
+	if err != nil {
+		log.Printf("[ch2omonitor]failed to get the cloud, error: %v", err)
+		return
+	}
+	rcloud, err := iot.NewReliableCloud(cloud, &iot.ReliableConfig{File: queueFile})
+	if err != nil {
+		log.Printf("[ch2omonitor]failed to load the queue of the cloud, error: %v", err)
+		return
 	}
-	cloud := iot.NewCloud(wsnCfg)
 
-	m := newCH2OMonitor(sensor, led, bzr, dsp, cloud)
+	// home assistant is optional
+	var hass *iot.HomeAssistant
+	if mqtt, err := clouds.MQTT(mqttName); err != nil {
+		log.Printf("[ch2omonitor]failed to get the mqtt cloud, home assistant is disabled, error: %v", err)
+	} else {
+		hass = iot.NewHomeAssistant(mqtt, "CH2O-Monitor")
+		hass.AddSensor(&iot.HASensor{ID: "ch2o", Name: "CH2O", Unit: "mg/m³", Icon: "mdi:molecule"})
+	}
+
+	m := newCH2OMonitor(sensor, led, bzr, dsp, iot.NewMetricsCloud(rcloud, nil), hass)
 	// m.setMode(util.DevMode)
+	go func() {
+		if err := metrics.ListenAndServe(metricsAddr); err != nil {
+			log.Printf("[ch2omonitor]failed to serve metrics, error: %v", err)
+		}
+	}()
 	util.WaitQuit(func() {
+		rcloud.Close()
+		clouds.Close()
 		m.stop()
 		rpio.Close()
 	})
@@ -72,19 +108,21 @@ type ch2oMonitor struct {
 	buzzer    *dev.Buzzer
 	dsp       *dev.LedDisplay
 	cloud     iot.Cloud
+	hass      *iot.HomeAssistant // nil if home assistant is disabled
 	mode      util.Mode
 	chAlert   chan float64 // for alerting
 	chDisplay chan float64
 	chCloud   chan float64 // for pushing to iot cloud
 }
 
-func newCH2OMonitor(sensor *dev.ZE08CH2O, led *dev.Led, buzzer *dev.Buzzer, dsp *dev.LedDisplay, cloud iot.Cloud) *ch2oMonitor {
+func newCH2OMonitor(sensor *dev.ZE08CH2O, led *dev.Led, buzzer *dev.Buzzer, dsp *dev.LedDisplay, cloud iot.Cloud, hass *iot.HomeAssistant) *ch2oMonitor {
 	return &ch2oMonitor{
 		sensor:    sensor,
 		led:       led,
 		buzzer:    buzzer,
 		dsp:       dsp,
 		cloud:     cloud,
+		hass:      hass,
 		mode:      util.PrdMode,
 		chAlert:   make(chan float64, 4),
 		chDisplay: make(chan float64, 4),
@@ -147,6 +185,12 @@ func (m *ch2oMonitor) push() {
 			if err := m.cloud.Push(v); err != nil {
 				log.Printf("[ch2omonitor]push: failed to push ch2o to cloud, error: %v", err)
 			}
+			if m.hass == nil {
+				return
+			}
+			if err := m.hass.Push(&iot.Value{Device: "ch2o", Value: v.Value}); err != nil {
+				log.Printf("[ch2omonitor]push: failed to push ch2o to home assistant, error: %v", err)
+			}
 		}(ch2o)
 	}
 }
@@ -161,7 +205,9 @@ func (m *ch2oMonitor) alert() {
 			// do nothing
 		}
 
-		if ch2o >= alertCH2O {
+		alerting := ch2o >= alertCH2O
+		metrics.SetState("buzzer", pinBzr, float64(bool2int[alerting]))
+		if alerting {
 			go m.buzzer.Beep(1, 200)
 			go m.led.Blink(1, 200)
 		}
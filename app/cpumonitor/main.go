@@ -8,25 +8,45 @@ import (
 	"time"
 
 	"github.com/shanghuiyang/rpi-devices/iot"
+	"github.com/shanghuiyang/rpi-devices/util/metrics"
 )
 
 const (
+	// cloudName is the cloud of the values in the config file of the clouds
+	cloudName = "onenet"
+	// queueFile persists the values which haven't been pushed to the cloud
+	queueFile = "cpumonitor_queue.json"
+	// metricsAddr is the address of serving the metrics for prometheus
+	metricsAddr = ":9101"
+
 	cpuInterval = 5 * time.Minute
 )
 
 func main() {
-	onenetCfg := &iot.OneNetConfig{
-		Token: iot.OneNetToken,
-		API:   iot.OneNetAPI,
+	clouds, err := iot.LoadClouds("")
+	if err != nil {
+		log.Printf("[cpumonitor]failed to load the clouds, error: %v", err)
+		return
+	}
+	cloud, err := clouds.Get(cloudName)
+	if err != nil {
+		log.Printf("[cpumonitor]failed to get the cloud, error: %v", err)
+		return
 	}
-	cloud := iot.NewCloud(onenetCfg)
-	if cloud == nil {
-		log.Printf("cpumonitor]failed to new OneNet iot cloud")
+	rcloud, err := iot.NewReliableCloud(cloud, &iot.ReliableConfig{File: queueFile})
+	if err != nil {
+		log.Printf("[cpumonitor]failed to load the queue of the cloud, error: %v", err)
 		return
 	}
 	monitor := &cpuMonitor{
-		cloud: cloud,
+		cloud: iot.NewMetricsCloud(rcloud, nil),
 	}
+	go func() {
+		if err := metrics.ListenAndServe(metricsAddr); err != nil {
+			log.Printf("[cpumonitor]failed to serve metrics, error: %v", err)
+		}
+	}()
+
 	monitor.start()
 }
 
@@ -38,7 +58,9 @@ type cpuMonitor struct {
 func (c *cpuMonitor) start() {
 	log.Printf("[cpumonitor]cpu monitor start working")
 	for {
+		start := time.Now()
 		f, err := c.idle()
+		metrics.ObserveRead("cpu", start, err)
 		if err != nil {
 			log.Printf("[cpumonitor]failed to get cpu idle, error: %v", err)
 			time.Sleep(30 * time.Second)
@@ -49,7 +71,9 @@ func (c *cpuMonitor) start() {
 			Device: "cpu",
 			Value:  f,
 		}
-		go c.cloud.Push(v)
+		if err := c.cloud.Push(v); err != nil {
+			log.Printf("[cpumonitor]failed to push cpu to cloud, error: %v", err)
+		}
 		time.Sleep(cpuInterval)
 	}
 }
@@ -26,6 +26,8 @@ const (
 	pinLed  = 23
 
 	ifttAPI = "your-iftt-api"
+	// the stream of motion
+	motionStream = "http://localhost:8081"
 )
 
 const (
@@ -57,7 +59,12 @@ func main() {
 	}
 	defer rpio.Close()
 
-	cam := dev.NewCamera()
+	cam, err := dev.OpenCamera(motionStream, nil)
+	if err != nil {
+		log.Printf("[doordog]failed to open the camera, error: %v", err)
+		return
+	}
+	defer cam.Close()
 	bzr := dev.NewBuzzer(pinBzr)
 	led := dev.NewLed(pinLed)
 	btn := dev.NewButton(pinBtn)
@@ -9,6 +9,13 @@ import (
 	"github.com/shanghuiyang/rpi-devices/util"
 )
 
+const (
+	// cloudName is the cloud of the values in the config file of the clouds
+	cloudName = "onenet"
+	// queueFile persists the values which haven't been pushed to the cloud
+	queueFile = "gpstracker_queue.json"
+)
+
 func main() {
 	gps := dev.NewGPS()
 	if gps == nil {
@@ -20,22 +27,31 @@ func main() {
 		log.Printf("[gpstracker]failed to new a tracker")
 		return
 	}
-	oneNetCfg := &iot.OneNetConfig{
-		Token: iot.OneNetToken,
-		API:   iot.OneNetAPI,
+	clouds, err := iot.LoadClouds("")
+	if err != nil {
+		log.Printf("[gpstracker]failed to load the clouds, error: %v", err)
+		return
+	}
+	cloud, err := clouds.Get(cloudName)
+	if err != nil {
+		log.Printf("[gpstracker]failed to get the cloud, error: %v", err)
+		return
 	}
-	cloud := iot.NewCloud(oneNetCfg)
-	if cloud == nil {
-		log.Printf("[gpstracker]failed to new OneNet iot cloud")
+	rcloud, err := iot.NewReliableCloud(cloud, &iot.ReliableConfig{File: queueFile})
+	if err != nil {
+		log.Printf("[gpstracker]failed to load the queue of the cloud, error: %v", err)
 		return
 	}
 	t := &gpsTracker{
 		gps:    gps,
 		logger: logger,
-		cloud:  cloud,
+		cloud:  rcloud,
 	}
 
-	util.WaitQuit(t.close)
+	util.WaitQuit(func() {
+		t.close()
+		rcloud.Close()
+	})
 	t.start()
 }
 
@@ -60,7 +76,9 @@ func (t *gpsTracker) start() {
 			Device: "gps",
 			Value:  pt,
 		}
-		go t.cloud.Push(v)
+		if err := t.cloud.Push(v); err != nil {
+			log.Printf("[gpstracker]failed to push gps to cloud, error: %v", err)
+		}
 	}
 }
 
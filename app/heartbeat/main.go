@@ -8,17 +8,20 @@ import (
 )
 
 const (
+	// cloudName is the cloud of the values in the config file of the clouds
+	cloudName         = "wsn"
 	heartBeatInterval = 1 * time.Minute
 )
 
 func main() {
-	oneNetCfg := &iot.WsnConfig{
-		Token: iot.WsnToken,
-		API:   iot.WsnNumericalAPI,
+	clouds, err := iot.LoadClouds("")
+	if err != nil {
+		log.Printf("[heartbeat]failed to load the clouds, error: %v", err)
+		return
 	}
-	cloud := iot.NewCloud(oneNetCfg)
-	if cloud == nil {
-		log.Printf("[heartbeat]failed to new OneNet iot cloud")
+	cloud, err := clouds.Get(cloudName)
+	if err != nil {
+		log.Printf("[heartbeat]failed to get the cloud, error: %v", err)
 		return
 	}
 	h := &heartBeat{
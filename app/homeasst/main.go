@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/shanghuiyang/rpi-devices/dev"
@@ -15,6 +16,13 @@ import (
 )
 
 const (
+	// cloudName is the cloud of the values in the config file of the clouds
+	cloudName = "onenet"
+	// mqttName is the mqtt cloud of Home Assistant in the config file of the clouds
+	mqttName = "mqtt"
+	// queueFile persists the values which haven't been pushed to the cloud
+	queueFile = "homeasst_queue.json"
+
 	dioPin  = 9
 	rclkPin = 10
 	sclkPin = 11
@@ -39,8 +47,9 @@ type pm25Response struct {
 type homeAsst struct {
 	dsp       *dev.LedDisplay
 	cloud     iot.Cloud
-	chDisplay chan *data // for disploying on oled
-	chCloud   chan *data // for pushing to iot cloud
+	hass      *iot.HomeAssistant // nil if home assistant is disabled
+	chDisplay chan *data         // for disploying on oled
+	chCloud   chan []*iot.Value  // for pushing to iot cloud in batches
 	// chAlert   chan *data // for alerting
 }
 
@@ -53,26 +62,49 @@ func main() {
 
 	dsp := dev.NewLedDisplay(dioPin, rclkPin, sclkPin)
 
-	onenetCfg := &iot.OneNetConfig{
-		Token: iot.OneNetToken,
-		API:   iot.OneNetAPI,
+	clouds, err := iot.LoadClouds("")
+	if err != nil {
+		log.Printf("[homeasst]failed to load the clouds, error: %v", err)
+		return
+	}
+	cloud, err := clouds.Get(cloudName)
+	if err != nil {
+		log.Printf("[homeasst]failed to get the cloud, error: %v", err)
+		return
+	}
+	rcloud, err := iot.NewReliableCloud(cloud, &iot.ReliableConfig{File: queueFile})
+	if err != nil {
+		log.Printf("[homeasst]failed to load the queue of the cloud, error: %v", err)
+		return
+	}
+
+	// home assistant is optional
+	var hass *iot.HomeAssistant
+	if mqtt, err := clouds.MQTT(mqttName); err != nil {
+		log.Printf("[homeasst]failed to get the mqtt cloud, home assistant is disabled, error: %v", err)
+	} else {
+		hass = iot.NewHomeAssistant(mqtt, "Home-Asst")
+		hass.AddSensor(&iot.HASensor{ID: "temp", Name: "Temperature", Unit: "°C", DeviceClass: "temperature"})
+		hass.AddSensor(&iot.HASensor{ID: "pm2.5", Name: "PM2.5", Unit: "µg/m³", DeviceClass: "pm25"})
 	}
-	cloud := iot.NewCloud(onenetCfg)
 
-	asst := newHomeAsst(dsp, cloud)
+	asst := newHomeAsst(dsp, rcloud, hass)
 	util.WaitQuit(func() {
+		rcloud.Close()
+		clouds.Close()
 		asst.stop()
 		rpio.Close()
 	})
 	asst.start()
 }
 
-func newHomeAsst(dsp *dev.LedDisplay, cloud iot.Cloud) *homeAsst {
+func newHomeAsst(dsp *dev.LedDisplay, cloud iot.Cloud, hass *iot.HomeAssistant) *homeAsst {
 	return &homeAsst{
 		dsp:       dsp,
 		cloud:     cloud,
+		hass:      hass,
 		chDisplay: make(chan *data, 4),
-		chCloud:   make(chan *data, 4),
+		chCloud:   make(chan []*iot.Value, 4),
 		// chAlert:   make(chan *value, 4),
 	}
 }
@@ -86,43 +118,65 @@ func (h *homeAsst) start() {
 
 func (h *homeAsst) getData() {
 	for {
-		go func() {
-			t, err := h.getTemp()
-			if err != nil {
-				log.Printf("[homeasst]failed to get temperature, error: %v", err)
-				time.Sleep(5 * time.Second)
-				return
-			}
-			log.Printf("[homeasst]temp: %v", t)
-			d := &data{
-				name:  "temp",
-				text:  fmt.Sprintf("%.1f", t),
-				value: t,
-			}
-			h.chDisplay <- d
-			h.chCloud <- d
-		}()
-
-		go func() {
-			pm25, err := h.getPM25()
-			if err != nil {
-				log.Printf("[homeasst]failed to get pm2.5, error: %v", err)
-				time.Sleep(5 * time.Second)
-				return
-			}
-			log.Printf("[homeasst]pm2.5: %v", pm25)
+		go h.collect()
+		time.Sleep(60 * time.Second)
+	}
+}
 
-			d := &data{
-				name:  "pm2.5",
-				text:  fmt.Sprintf("%v", pm25),
-				value: pm25,
-			}
-			h.chDisplay <- d
-			h.chCloud <- d
-			// h.chAlert <- v
-		}()
+// collect gets the temperature and pm2.5 concurrently,
+// and pushes them to the cloud together in a batch with the time they were measured.
+func (h *homeAsst) collect() {
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+		vs []*iot.Value
+	)
+	add := func(d *data) {
+		h.chDisplay <- d
+		mu.Lock()
+		vs = append(vs, &iot.Value{
+			Device: d.name,
+			Value:  d.value,
+			Time:   time.Now(),
+		})
+		mu.Unlock()
+	}
 
-		time.Sleep(60 * time.Second)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		t, err := h.getTemp()
+		if err != nil {
+			log.Printf("[homeasst]failed to get temperature, error: %v", err)
+			return
+		}
+		log.Printf("[homeasst]temp: %v", t)
+		add(&data{
+			name:  "temp",
+			text:  fmt.Sprintf("%.1f", t),
+			value: t,
+		})
+	}()
+
+	go func() {
+		defer wg.Done()
+		pm25, err := h.getPM25()
+		if err != nil {
+			log.Printf("[homeasst]failed to get pm2.5, error: %v", err)
+			return
+		}
+		log.Printf("[homeasst]pm2.5: %v", pm25)
+		add(&data{
+			name:  "pm2.5",
+			text:  fmt.Sprintf("%v", pm25),
+			value: pm25,
+		})
+		// h.chAlert <- v
+	}()
+
+	wg.Wait()
+	if len(vs) > 0 {
+		h.chCloud <- vs
 	}
 }
 
@@ -167,17 +221,16 @@ func (h *homeAsst) display() {
 }
 
 func (h *homeAsst) push() {
-	for d := range h.chCloud {
-		go func(d *data) {
-			v := &iot.Value{
-				Device: d.name,
-				Value:  d.value,
-			}
-			if err := h.cloud.Push(v); err != nil {
-				log.Printf("[homeasst]failed to push %v to cloud, error: %v", d.name, err)
-				return
-			}
-		}(d)
+	for vs := range h.chCloud {
+		if err := iot.PushBatch(h.cloud, vs); err != nil {
+			log.Printf("[homeasst]failed to push to cloud, error: %v", err)
+		}
+		if h.hass == nil {
+			continue
+		}
+		if err := iot.PushBatch(h.hass, vs); err != nil {
+			log.Printf("[homeasst]failed to push to home assistant, error: %v", err)
+		}
 	}
 }
 
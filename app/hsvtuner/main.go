@@ -0,0 +1,44 @@
+/*
+hsvtuner is a web tool for tuning the hsv profile of self-tracking.
+
+usage:
+  # tune the profile with the stream of motion, and open http://<ip>:8084 in the browser
+  $ hsvtuner -src http://localhost:8081 -profile tracking.json
+
+the saved profile can be loaded by the car for self-tracking.
+
+*/
+
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/shanghuiyang/rpi-devices/util/cv/detect"
+)
+
+func main() {
+	var (
+		src     = flag.String("src", "http://localhost:8081", "the frame source, an url of mjpeg stream or a directory of jpeg images")
+		profile = flag.String("profile", "tracking.json", "the file of the hsv profile")
+		addr    = flag.String("addr", ":8084", "the address to listen on")
+	)
+	flag.Parse()
+
+	frames, err := detect.OpenSource(*src)
+	if err != nil {
+		log.Fatalf("[hsvtuner]failed to open the frame source, error: %v", err)
+	}
+	defer frames.Close()
+
+	c, err := detect.NewCalibrator(frames, *profile)
+	if err != nil {
+		log.Fatalf("[hsvtuner]failed to new a calibrator, error: %v", err)
+	}
+	log.Printf("[hsvtuner]listening on %v", *addr)
+	if err := http.ListenAndServe(*addr, c); err != nil {
+		log.Fatalf("[hsvtuner]failed to listen and serve, error: %v", err)
+	}
+}
@@ -18,6 +18,9 @@ const (
 	wavThisIsX    = "this_is_x.wav"
 	wavIDontKnow  = "i_dont_know.wav"
 
+	// the stream of motion
+	motionStream = "http://localhost:8081"
+
 	// replace your_app_key and your_secret_key with yours
 	baiduSpeechAppKey    = "your_speech_app_key"
 	baiduSpeechSecretKey = "your_speech_secret_key"
@@ -40,7 +43,12 @@ func main() {
 	asr = speech.NewASR(speechAuth)
 	tts = speech.NewTTS(speechAuth)
 	imgr = recognizer.New(imageAuth)
-	cam = dev.NewCamera()
+	var err error
+	cam, err = dev.OpenCamera(motionStream, nil)
+	if err != nil {
+		log.Printf("[imgr]failed to open the camera, error: %v", err)
+		os.Exit(1)
+	}
 
 	for {
 		log.Printf("[imgr]take photo")
@@ -11,17 +11,20 @@ import (
 )
 
 const (
-	maxRetry = 10
+	// cloudName is the cloud of the values in the config file of the clouds
+	cloudName = "onenet"
+	maxRetry  = 10
 )
 
 func main() {
-	oneCfg := &iot.OneNetConfig{
-		Token: iot.OneNetToken,
-		API:   iot.OneNetAPI,
+	clouds, err := iot.LoadClouds("")
+	if err != nil {
+		log.Printf("[ip]failed to load the clouds, error: %v", err)
+		return
 	}
-	cloud := iot.NewCloud(oneCfg)
-	if cloud == nil {
-		log.Printf("[ip]failed to new OneNet iot cloud")
+	cloud, err := clouds.Get(cloudName)
+	if err != nil {
+		log.Printf("[ip]failed to get the cloud, error: %v", err)
 		return
 	}
 
@@ -8,26 +8,46 @@ import (
 	"time"
 
 	"github.com/shanghuiyang/rpi-devices/iot"
+	"github.com/shanghuiyang/rpi-devices/util/metrics"
 )
 
 const (
+	// cloudName is the cloud of the values in the config file of the clouds
+	cloudName = "onenet"
+	// queueFile persists the values which haven't been pushed to the cloud
+	queueFile = "memmonitor_queue.json"
+	// metricsAddr is the address of serving the metrics for prometheus
+	metricsAddr = ":9102"
+
 	memoryInterval = 10 * time.Minute
 )
 
 func main() {
-	onenetCfg := &iot.OneNetConfig{
-		Token: iot.OneNetToken,
-		API:   iot.OneNetAPI,
+	clouds, err := iot.LoadClouds("")
+	if err != nil {
+		log.Printf("[memmonitor]failed to load the clouds, error: %v", err)
+		return
+	}
+	cloud, err := clouds.Get(cloudName)
+	if err != nil {
+		log.Printf("[memmonitor]failed to get the cloud, error: %v", err)
+		return
 	}
-	cloud := iot.NewCloud(onenetCfg)
-	if cloud == nil {
-		log.Printf("[memmonitor]failed to new OneNet iot cloud")
+	rcloud, err := iot.NewReliableCloud(cloud, &iot.ReliableConfig{File: queueFile})
+	if err != nil {
+		log.Printf("[memmonitor]failed to load the queue of the cloud, error: %v", err)
 		return
 	}
 
 	monitor := &memMonitor{
-		cloud: cloud,
+		cloud: iot.NewMetricsCloud(rcloud, nil),
 	}
+	go func() {
+		if err := metrics.ListenAndServe(metricsAddr); err != nil {
+			log.Printf("[memmonitor]failed to serve metrics, error: %v", err)
+		}
+	}()
+
 	monitor.start()
 }
 
@@ -38,7 +58,9 @@ type memMonitor struct {
 func (m *memMonitor) start() {
 	log.Printf("[memmonitor]start working")
 	for {
+		start := time.Now()
 		f, err := m.free()
+		metrics.ObserveRead("memory", start, err)
 		if err != nil {
 			log.Printf("[memmonitor]failed to get free memory, error: %v", err)
 			time.Sleep(30 * time.Second)
@@ -48,7 +70,9 @@ func (m *memMonitor) start() {
 			Device: "memory",
 			Value:  f,
 		}
-		go m.cloud.Push(v)
+		if err := m.cloud.Push(v); err != nil {
+			log.Printf("[memmonitor]failed to push memory to cloud, error: %v", err)
+		}
 		time.Sleep(memoryInterval)
 	}
 }
@@ -56,7 +80,9 @@ func (m *memMonitor) start() {
 // Free is to get free memory in MB
 // $ free -m
 // ---------------------------------------------------------------------------------
-//             total        used        free      shared  buff/cache   available
+//
+//	total        used        free      shared  buff/cache   available
+//
 // Mem:          432          50         258           3         123         328
 // Swap:          99           0          99
 // ---------------------------------------------------------------------------------
@@ -10,9 +10,11 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 
 	"github.com/shanghuiyang/rpi-devices/dev"
 	"github.com/shanghuiyang/rpi-devices/util"
+	"github.com/shanghuiyang/rpi-devices/util/metrics"
 	"github.com/stianeikeland/go-rpio"
 )
 
@@ -20,6 +22,8 @@ type (
 	option func(s *sserver)
 )
 
+var requests = metrics.NewCounter("sserver_requests_total", "The number of the requests by the paths and the status codes.", "path", "code")
+
 type sserver struct {
 	ds18b20 *dev.DS18B20
 	pms7003 *dev.PMS7003
@@ -97,13 +101,15 @@ func (s *sserver) start() error {
 	log.Printf("[sensors]start service")
 	http.HandleFunc("/temp", s.tempHandler)
 	http.HandleFunc("/pm25", s.pm25Handler)
+	http.Handle("/metrics", metrics.Handler())
 	if err := http.ListenAndServe(":8000", nil); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (s *sserver) response(w http.ResponseWriter, resp interface{}, statusCode int) error {
+func (s *sserver) response(w http.ResponseWriter, r *http.Request, resp interface{}, statusCode int) error {
+	requests.Inc(r.URL.Path, strconv.Itoa(statusCode))
 	w.WriteHeader(statusCode)
 	data, err := json.Marshal(resp)
 	if err != nil {
@@ -123,7 +129,7 @@ func (s *sserver) tempHandler(w http.ResponseWriter, r *http.Request) {
 		resp := &tempResponse{
 			ErrorMsg: "invaild ds18b20 sensor",
 		}
-		s.response(w, resp, http.StatusInternalServerError)
+		s.response(w, r, resp, http.StatusInternalServerError)
 		return
 	}
 
@@ -132,14 +138,14 @@ func (s *sserver) tempHandler(w http.ResponseWriter, r *http.Request) {
 		resp := &tempResponse{
 			ErrorMsg: fmt.Sprintf("failed to get temp, error: %v", err),
 		}
-		s.response(w, resp, http.StatusInternalServerError)
+		s.response(w, r, resp, http.StatusInternalServerError)
 		return
 	}
 
 	resp := &tempResponse{
 		Temp: t,
 	}
-	s.response(w, resp, http.StatusOK)
+	s.response(w, r, resp, http.StatusOK)
 }
 
 func (s *sserver) pm25Handler(w http.ResponseWriter, r *http.Request) {
@@ -148,7 +154,7 @@ func (s *sserver) pm25Handler(w http.ResponseWriter, r *http.Request) {
 		resp := &pm25Response{
 			ErrorMsg: "invaild pms7003 sensor",
 		}
-		s.response(w, resp, http.StatusInternalServerError)
+		s.response(w, r, resp, http.StatusInternalServerError)
 		return
 	}
 
@@ -157,12 +163,12 @@ func (s *sserver) pm25Handler(w http.ResponseWriter, r *http.Request) {
 		resp := &pm25Response{
 			ErrorMsg: fmt.Sprintf("failed to get pm2.5, error: %v", err),
 		}
-		s.response(w, resp, http.StatusInternalServerError)
+		s.response(w, r, resp, http.StatusInternalServerError)
 		return
 	}
 
 	resp := &pm25Response{
 		PM25: pm25,
 	}
-	s.response(w, resp, http.StatusOK)
+	s.response(w, r, resp, http.StatusOK)
 }
@@ -9,10 +9,20 @@ import (
 	"github.com/shanghuiyang/rpi-devices/dev"
 	"github.com/shanghuiyang/rpi-devices/iot"
 	"github.com/shanghuiyang/rpi-devices/util"
+	"github.com/shanghuiyang/rpi-devices/util/metrics"
 	"github.com/stianeikeland/go-rpio"
 )
 
 const (
+	// cloudName is the cloud of the values in the config file of the clouds
+	cloudName = "onenet"
+	// mqttName is the mqtt cloud of Home Assistant in the config file of the clouds
+	mqttName = "mqtt"
+	// queueFile persists the values which haven't been pushed to the cloud
+	queueFile = "tempmonitor_queue.json"
+	// metricsAddr is the address of serving the metrics for prometheus
+	metricsAddr = ":9103"
+
 	ledPin                 = 12
 	lowTemperatureWarning  = 18
 	highTemperatureWarning = 30
@@ -37,23 +47,51 @@ func main() {
 		return
 	}
 
-	oneNetCfg := &iot.OneNetConfig{
-		Token: iot.OneNetToken,
-		API:   iot.OneNetAPI,
+	clouds, err := iot.LoadClouds("")
+	if err != nil {
+		log.Printf("[tempmonitor]failed to load the clouds, error: %v", err)
+		return
 	}
-	cloud := iot.NewCloud(oneNetCfg)
-	if cloud == nil {
-		log.Printf("[tempmonitor]failed to new OneNet iot cloud")
+	cloud, err := clouds.Get(cloudName)
+	if err != nil {
+		log.Printf("[tempmonitor]failed to get the cloud, error: %v", err)
+		return
+	}
+	rcloud, err := iot.NewReliableCloud(cloud, &iot.ReliableConfig{File: queueFile})
+	if err != nil {
+		log.Printf("[tempmonitor]failed to load the queue of the cloud, error: %v", err)
 		return
 	}
 
+	// push the temperature to OneNet and Home Assistant, home assistant is optional
+	backends := []*iot.MultiBackend{
+		{Name: "onenet", Cloud: iot.NewMetricsCloud(rcloud, nil)},
+	}
+	if mqtt, err := clouds.MQTT(mqttName); err != nil {
+		log.Printf("[tempmonitor]failed to get the mqtt cloud, home assistant is disabled, error: %v", err)
+	} else {
+		hass := iot.NewHomeAssistant(mqtt, "Temp-Monitor")
+		hass.AddSensor(&iot.HASensor{ID: "temperature", Name: "Temperature", Unit: "°C", DeviceClass: "temperature"})
+		backends = append(backends, &iot.MultiBackend{Name: "hass", Cloud: hass})
+	}
+	mcloud := iot.NewMultiCloud(backends...)
+
 	monitor := tempMonitor{
 		temp:  temp,
-		cloud: cloud,
+		cloud: mcloud,
 		led:   led,
 	}
 
+	go func() {
+		if err := metrics.ListenAndServe(metricsAddr); err != nil {
+			log.Printf("[tempmonitor]failed to serve metrics, error: %v", err)
+		}
+	}()
+
 	util.WaitQuit(func() {
+		mcloud.Close()
+		rcloud.Close()
+		clouds.Close()
 		rpio.Close()
 	})
 
@@ -79,7 +117,9 @@ func (m *tempMonitor) start() {
 			Device: "temperature",
 			Value:  c,
 		}
-		go m.cloud.Push(v)
+		if err := m.cloud.Push(v); err != nil {
+			log.Printf("[tempmonitor]failed to push temperature to cloud, error: %v", err)
+		}
 		go m.led.Blink(5, 500)
 
 		if c <= lowTemperatureWarning || c >= highTemperatureWarning {
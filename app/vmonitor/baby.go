@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/shanghuiyang/rpi-devices/dev"
+	"github.com/shanghuiyang/rpi-devices/util"
+	"github.com/shanghuiyang/rpi-devices/util/sound"
+)
+
+const (
+	// sampleSec is the length of a sample of the sound
+	sampleSec = 5
+	// historySize keeps the levels of the last hour
+	historySize = 3600 / sampleSec
+	// alertCooldown is the min interval between two alerts
+	alertCooldown = 5 * time.Minute
+	// maxSampleGap is the max gap between two samples, the crying across them is detected if the gap is shorter
+	maxSampleGap = 2 * sampleSec * time.Second
+)
+
+// soundSource samples the sound and returns the levels of the windows in dBFS
+type soundSource interface {
+	Sample(window time.Duration) ([]float64, error)
+}
+
+// micSource records the sound from the microphone using util.Record()
+type micSource struct {
+	file string
+}
+
+func newMicSource() *micSource {
+	return &micSource{
+		file: filepath.Join(os.TempDir(), "vmonitor_baby.wav"),
+	}
+}
+
+// Sample ...
+func (m *micSource) Sample(window time.Duration) ([]float64, error) {
+	if err := util.Record(sampleSec, m.file); err != nil {
+		return nil, err
+	}
+	defer os.Remove(m.file)
+	wav, err := sound.ReadWavFile(m.file)
+	if err != nil {
+		return nil, err
+	}
+	return sound.Levels(wav, window), nil
+}
+
+// sensorSource samples a digital sound sensor, a window is loud if the sensor detected a sound in it
+type sensorSource struct {
+	det *dev.VoiceDetector
+}
+
+// Sample ...
+func (s *sensorSource) Sample(window time.Duration) ([]float64, error) {
+	var levels []float64
+	for i := 0; i < int(sampleSec*time.Second/window); i++ {
+		level := sound.Silence
+		end := time.Now().Add(window)
+		for time.Now().Before(end) {
+			if s.det.Detected() {
+				level = 0
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		levels = append(levels, level)
+	}
+	return levels, nil
+}
+
+// notifier notifies the parents, e.g. by email
+type notifier interface {
+	Notify(subject, body string) error
+}
+
+// emailNotifier ...
+type emailNotifier struct {
+	email *util.Email
+	to    []string
+}
+
+// loadEmailNotifier loads the config from a json file like {"email": {...}, "to": ["mom@xxx.com"]}
+func loadEmailNotifier(file string) (*emailNotifier, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		Email *util.EmailConfig `json:"email"`
+		To    []string          `json:"to"`
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Email == nil || len(cfg.To) == 0 {
+		return nil, fmt.Errorf("invalid config in %v", file)
+	}
+	return &emailNotifier{
+		email: util.NewEmail(cfg.Email),
+		to:    cfg.To,
+	}, nil
+}
+
+// Notify ...
+func (e *emailNotifier) Notify(subject, body string) error {
+	return e.email.Send(&util.EmailInfo{
+		To:      e.to,
+		Subject: subject,
+		Body:    body,
+	})
+}
+
+// soundLevel is a point of the level history
+type soundLevel struct {
+	Time time.Time `json:"time"`
+	*sound.Result
+}
+
+// babyMonitor monitors the sound in baby mode, and alerts when the baby is crying
+type babyMonitor struct {
+	src     soundSource
+	cfg     *sound.Config
+	enabled func() bool
+	alert   func(r *sound.Result)
+
+	mu        sync.Mutex
+	history   []*soundLevel
+	lastAlert time.Time
+	// last is the levels of the last sample, and lastTime is when it was fed
+	last     []float64
+	lastTime time.Time
+}
+
+func newBabyMonitor(src soundSource, cfg *sound.Config, enabled func() bool, alert func(r *sound.Result)) *babyMonitor {
+	if cfg == nil {
+		cfg = sound.DefaultConfig
+	}
+	return &babyMonitor{
+		src:     src,
+		cfg:     cfg,
+		enabled: enabled,
+		alert:   alert,
+	}
+}
+
+// start samples the sound in baby mode forever
+func (b *babyMonitor) start() {
+	for {
+		if !b.enabled() {
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		levels, err := b.src.Sample(b.cfg.Window)
+		if err != nil {
+			log.Printf("[vmonitor]failed to sample the sound, error: %v", err)
+			time.Sleep(sampleSec * time.Second)
+			continue
+		}
+		b.feed(levels, time.Now())
+	}
+}
+
+// feed analyzes the levels of a sample following the last sample, and alerts if the baby is crying
+func (b *babyMonitor) feed(levels []float64, t time.Time) *sound.Result {
+	b.mu.Lock()
+	var tail []float64
+	if t.Sub(b.lastTime) <= maxSampleGap {
+		tail = b.last
+	}
+	b.last, b.lastTime = levels, t
+	r := sound.AnalyzeWithTail(tail, levels, b.cfg)
+	b.history = append(b.history, &soundLevel{Time: t, Result: r})
+	if len(b.history) > historySize {
+		b.history = b.history[len(b.history)-historySize:]
+	}
+	alert := r.Crying && t.Sub(b.lastAlert) >= alertCooldown
+	if alert {
+		b.lastAlert = t
+	}
+	b.mu.Unlock()
+
+	if alert {
+		log.Printf("[vmonitor]the baby is crying, level: %.1f dB", r.Level)
+		b.alert(r)
+	}
+	return r
+}
+
+// levels serves the level history in json
+func (b *babyMonitor) levels(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	history := make([]*soundLevel, len(b.history))
+	copy(history, b.history)
+	b.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/shanghuiyang/rpi-devices/util/sound"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBabyMonitor(t *testing.T) {
+	alerts := 0
+	b := newBabyMonitor(nil, nil, func() bool { return true }, func(r *sound.Result) {
+		alerts++
+	})
+
+	quiet := make([]float64, 50)
+	crying := make([]float64, 50)
+	for i := range quiet {
+		quiet[i] = -60
+		crying[i] = -10
+	}
+	now := time.Now()
+	assert.False(t, b.feed(quiet, now).Crying)
+	assert.True(t, b.feed(crying, now.Add(5*time.Second)).Crying)
+	assert.Equal(t, 1, alerts)
+	// no alerts in the cooldown
+	b.feed(crying, now.Add(10*time.Second))
+	assert.Equal(t, 1, alerts)
+	b.feed(crying, now.Add(10*time.Second+alertCooldown))
+	assert.Equal(t, 2, alerts)
+
+	w := httptest.NewRecorder()
+	b.levels(w, httptest.NewRequest("GET", "/baby/levels", nil))
+	var history []struct {
+		Level  float64 `json:"level"`
+		Crying bool    `json:"crying"`
+	}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&history))
+	assert.Len(t, history, 4)
+	assert.InDelta(t, -60, history[0].Level, 0.01)
+	assert.True(t, history[3].Crying)
+}
+
+func TestBabyMonitorAcrossSamples(t *testing.T) {
+	alerts := 0
+	b := newBabyMonitor(nil, nil, func() bool { return true }, func(r *sound.Result) {
+		alerts++
+	})
+
+	// crying for 2 seconds at the end of a sample, and 2 seconds at the beginning of the next one
+	end := make([]float64, 50)
+	begin := make([]float64, 50)
+	for i := range end {
+		end[i], begin[i] = -60, -60
+		if i >= 30 {
+			end[i] = -10
+		}
+		if i < 20 {
+			begin[i] = -10
+		}
+	}
+	now := time.Now()
+	assert.False(t, b.feed(end, now).Crying)
+	assert.True(t, b.feed(begin, now.Add(6*time.Second)).Crying)
+	assert.Equal(t, 1, alerts)
+
+	// not across a long gap
+	b.feed(end, now.Add(time.Hour))
+	assert.False(t, b.feed(begin, now.Add(time.Hour+maxSampleGap+time.Second)).Crying)
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	eventFile    = "event.json"
+	snapshotFile = "snapshot.jpg"
+	maxSnapshot  = 8 << 20
+	purgeEvery   = 10 * time.Minute
+)
+
+// eventHooks are the paths of the hooks of motion, they're called by motion on localhost without authentication
+var eventHooks = map[string]bool{
+	"/events/start":   true,
+	"/events/end":     true,
+	"/events/clip":    true,
+	"/events/picture": true,
+}
+
+// event is a motion event, its metadata and snapshot are saved in its own directory
+type event struct {
+	ID       string    `json:"id"`
+	Camera   string    `json:"camera"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Snapshot bool      `json:"snapshot"`
+	// Clip is the path of the movie recorded by motion, it must be in the dir of the store
+	Clip string `json:"clip,omitempty"`
+	// Size is the disk usage of the event in bytes, including the clip
+	Size int64 `json:"size"`
+}
+
+// Duration ...
+func (e event) Duration() time.Duration {
+	if e.End.IsZero() {
+		return time.Since(e.Start).Truncate(time.Second)
+	}
+	return e.End.Sub(e.Start).Truncate(time.Second)
+}
+
+// eventStore stores the motion events, and deletes the old events
+// when they are older than maxAge or the total size is over maxBytes.
+//
+// the events are received from the hooks of motion, e.g. in motion.conf:
+//	on_event_start curl -s -X POST "http://localhost:8080/events/start?camera=%t"
+//	on_event_end   curl -s -X POST "http://localhost:8080/events/end?camera=%t"
+//	on_movie_end   curl -s -X POST "http://localhost:8080/events/clip?camera=%t&file=%f"
+//	on_picture_save curl -s -X POST "http://localhost:8080/events/picture?camera=%t&file=%f"
+// the target_dir of motion should be the dir of the store, so the clips can be served and deleted,
+// see res/motion/normal_mode.conf.
+type eventStore struct {
+	dir      string
+	maxAge   time.Duration
+	maxBytes int64
+	mux      *http.ServeMux
+
+	mu      sync.Mutex
+	events  []*event          // sorted by the start time
+	ongoing map[string]*event // the ongoing event of every camera
+	seq     int
+}
+
+func newEventStore(dir string, maxAge time.Duration, maxBytes int64) (*eventStore, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &eventStore{
+		dir:      dir,
+		maxAge:   maxAge,
+		maxBytes: maxBytes,
+		mux:      http.NewServeMux(),
+		ongoing:  map[string]*event{},
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	s.mux.HandleFunc("/events/", s.timeline)
+	s.mux.HandleFunc("/events/list", s.list)
+	for path := range eventHooks {
+		s.mux.HandleFunc(path, s.hook)
+	}
+	return s, nil
+}
+
+// load loads the events saved in dir
+func (s *eventStore) load() error {
+	files, err := filepath.Glob(filepath.Join(s.dir, "*", eventFile))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		e := &event{}
+		if err := json.Unmarshal(data, e); err != nil {
+			log.Printf("[vmonitor]skip the broken event %v, error: %v", f, err)
+			continue
+		}
+		if e.End.IsZero() {
+			// vmonitor was stopped during the event
+			e.End = e.Start
+		}
+		s.events = append(s.events, e)
+	}
+	sort.Slice(s.events, func(i, j int) bool {
+		return s.events[i].Start.Before(s.events[j].Start)
+	})
+	return nil
+}
+
+// start starts an event of the camera with an optional jpeg snapshot
+func (s *eventStore) start(camera string, t time.Time, snapshot []byte) (*event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.ongoing[camera]; ok {
+		// the end of the last event was missed
+		e.End = t
+		s.save(e)
+	}
+	e := &event{
+		Camera: camera,
+		Start:  t,
+	}
+	for {
+		s.seq++
+		e.ID = fmt.Sprintf("%v-%v", t.Format("20060102-150405"), s.seq)
+		if _, err := os.Stat(s.path(e)); os.IsNotExist(err) {
+			break
+		}
+	}
+	if err := os.MkdirAll(s.path(e), 0755); err != nil {
+		return nil, err
+	}
+	if len(snapshot) > 0 {
+		if err := ioutil.WriteFile(filepath.Join(s.path(e), snapshotFile), snapshot, 0644); err != nil {
+			return nil, err
+		}
+		e.Snapshot = true
+	}
+	if err := s.save(e); err != nil {
+		return nil, err
+	}
+	s.events = append(s.events, e)
+	s.ongoing[camera] = e
+	log.Printf("[vmonitor]event %v started", e.ID)
+	return e, nil
+}
+
+// end ends the ongoing event of the camera
+func (s *eventStore) end(camera string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.ongoing[camera]
+	if !ok {
+		return fmt.Errorf("no ongoing event of camera %v", camera)
+	}
+	delete(s.ongoing, camera)
+	e.End = t
+	log.Printf("[vmonitor]event %v ended, duration: %v", e.ID, e.Duration())
+	return s.save(e)
+}
+
+// clip attaches a clip to the ongoing or the last event of the camera
+func (s *eventStore) clip(camera, file string) error {
+	file, err := s.file(file)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.current(camera)
+	if e == nil {
+		return fmt.Errorf("no event of camera %v", camera)
+	}
+	e.Clip = file
+	return s.save(e)
+}
+
+// picture moves a picture saved by motion to the snapshot of the ongoing or the last event of the camera
+func (s *eventStore) picture(camera, file string) error {
+	file, err := s.file(file)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.current(camera)
+	if e == nil {
+		return fmt.Errorf("no event of camera %v", camera)
+	}
+	if err := os.Rename(file, filepath.Join(s.path(e), snapshotFile)); err != nil {
+		return err
+	}
+	e.Snapshot = true
+	return s.save(e)
+}
+
+// file checks a file saved by motion is a regular file in the dir of the store
+func (s *eventStore) file(file string) (string, error) {
+	file = filepath.Clean(file)
+	if rel, err := filepath.Rel(s.dir, file); err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%v isn't in %v", file, s.dir)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%v is a directory", file)
+	}
+	return file, nil
+}
+
+// current returns the ongoing or the last event of the camera, s.mu must be held
+func (s *eventStore) current(camera string) *event {
+	if e, ok := s.ongoing[camera]; ok {
+		return e
+	}
+	for i := len(s.events) - 1; i >= 0; i-- {
+		if s.events[i].Camera == camera {
+			return s.events[i]
+		}
+	}
+	return nil
+}
+
+// save saves the metadata of an event and updates its size, s.mu must be held
+func (s *eventStore) save(e *event) error {
+	var size int64
+	if info, err := os.Stat(filepath.Join(s.path(e), snapshotFile)); err == nil {
+		size += info.Size()
+	}
+	if e.Clip != "" {
+		if info, err := os.Stat(e.Clip); err == nil {
+			size += info.Size()
+		}
+	}
+	e.Size = size
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(s.path(e), eventFile), data, 0644)
+}
+
+// purge deletes the oldest events until the retention policy is met,
+// the ongoing events are never deleted.
+func (s *eventStore) purge(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var total int64
+	for _, e := range s.events {
+		total += e.Size
+	}
+	var kept []*event
+	for _, e := range s.events {
+		old := s.maxAge > 0 && now.Sub(e.Start) > s.maxAge
+		full := s.maxBytes > 0 && total > s.maxBytes
+		if (!old && !full) || s.ongoing[e.Camera] == e {
+			kept = append(kept, e)
+			continue
+		}
+		if err := s.remove(e); err != nil {
+			log.Printf("[vmonitor]failed to remove event %v, error: %v", e.ID, err)
+			kept = append(kept, e)
+			continue
+		}
+		total -= e.Size
+		log.Printf("[vmonitor]event %v removed", e.ID)
+	}
+	s.events = kept
+}
+
+func (s *eventStore) remove(e *event) error {
+	if e.Clip != "" {
+		if err := os.Remove(e.Clip); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.RemoveAll(s.path(e))
+}
+
+// keepPurging purges the events periodically
+func (s *eventStore) keepPurging() {
+	for {
+		s.purge(time.Now())
+		time.Sleep(purgeEvery)
+	}
+}
+
+// get returns a copy of the event by id
+func (s *eventStore) get(id string) (event, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.events {
+		if e.ID == id {
+			return *e, true
+		}
+	}
+	return event{}, false
+}
+
+// snapshot returns a copy of the events, the latest first
+func (s *eventStore) snapshot() []event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := make([]event, len(s.events))
+	for i, e := range s.events {
+		events[len(s.events)-1-i] = *e
+	}
+	return events
+}
+
+func (s *eventStore) path(e *event) string {
+	return filepath.Join(s.dir, e.ID)
+}
+
+// ServeHTTP serves:
+//	GET  /events/                     the timeline
+//	GET  /events/list                 the events in json
+//	GET  /events/<id>/snapshot.jpg    the snapshot of an event
+//	GET  /events/<id>/clip            the clip of an event
+//	POST /events/start?camera=        starts an event, the body is an optional jpeg snapshot
+//	POST /events/end?camera=          ends the ongoing event
+//	POST /events/clip?camera=&file=   attaches a clip to the event
+//	POST /events/picture?camera=&file= moves a picture of motion to the snapshot of the event
+func (s *eventStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *eventStore) hook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	camera := r.FormValue("camera")
+	if camera == "" {
+		camera = "0"
+	}
+
+	var err error
+	switch r.URL.Path {
+	case "/events/start":
+		var snapshot []byte
+		snapshot, err = ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSnapshot))
+		if err == nil {
+			_, err = s.start(camera, time.Now(), snapshot)
+		}
+	case "/events/end":
+		err = s.end(camera, time.Now())
+	case "/events/clip":
+		err = s.clip(camera, r.FormValue("file"))
+	case "/events/picture":
+		err = s.picture(camera, r.FormValue("file"))
+	}
+	if err != nil {
+		log.Printf("[vmonitor]failed to handle %v, error: %v", r.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	go s.purge(time.Now())
+	w.Write([]byte("ok"))
+}
+
+func (s *eventStore) list(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.snapshot())
+}
+
+func (s *eventStore) timeline(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/events/")
+	if path == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := timelineTmpl.Execute(w, s.snapshot()); err != nil {
+			log.Printf("[vmonitor]failed to render the timeline, error: %v", err)
+		}
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	e, ok := s.get(parts[0])
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case parts[1] == snapshotFile && e.Snapshot:
+		http.ServeFile(w, r, filepath.Join(s.path(&e), snapshotFile))
+	case parts[1] == "clip" && e.Clip != "":
+		http.ServeFile(w, r, e.Clip)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+var timelineTmpl = template.Must(template.New("timeline").Parse(`<!DOCTYPE html>
+<html>
+<head>
+    <title>Video Monitor Events</title>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <style>
+        body { font-family: sans-serif; background: #222; color: #ddd; }
+        .event { display: inline-block; margin: 6px; padding: 6px; background: #333; vertical-align: top; }
+        .event img { width: 320px; display: block; }
+        a { color: #8cf; }
+    </style>
+</head>
+<body>
+    <h2>Events</h2>
+    {{range .}}
+    <div class="event">
+        {{if .Snapshot}}<img src="/events/{{.ID}}/snapshot.jpg">{{end}}
+        <div>{{.Start.Format "2006-01-02 15:04:05"}}, camera {{.Camera}}</div>
+        <div>{{if .End.IsZero}}ongoing{{else}}{{.Duration}}{{end}}
+            {{if .Clip}} &middot; <a href="/events/{{.ID}}/clip">clip</a>{{end}}</div>
+    </div>
+    {{else}}
+    <p>No events.</p>
+    {{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "events")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := newEventStore(dir, time.Hour, 0)
+	assert.NoError(t, err)
+	svr := httptest.NewServer(s)
+	defer svr.Close()
+
+	// the hooks of motion
+	resp, err := http.Post(svr.URL+"/events/start?camera=1", "image/jpeg", bytes.NewReader([]byte("jpeg")))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	clip := filepath.Join(dir, "clip.mp4")
+	assert.NoError(t, ioutil.WriteFile(clip, make([]byte, 100), 0644))
+	resp, err = http.Post(svr.URL+"/events/clip?camera=1&file="+clip, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = http.Post(svr.URL+"/events/end?camera=1", "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = http.Post(svr.URL+"/events/end?camera=1", "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp, err = http.Post(svr.URL+"/events/clip?camera=1&file=/etc/passwd", "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// another camera
+	start := time.Now()
+	_, err = s.start("2", start, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, s.end("2", start.Add(3*time.Second)))
+	// the picture of motion is saved after the event ends with "output_pictures best"
+	picture := filepath.Join(dir, "picture.jpg")
+	assert.NoError(t, ioutil.WriteFile(picture, []byte("jpeg2"), 0644))
+	resp, err = http.Post(svr.URL+"/events/picture?camera=2&file="+picture, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	_, err = os.Stat(picture)
+	assert.True(t, os.IsNotExist(err))
+
+	resp, err = http.Get(svr.URL + "/events/list")
+	assert.NoError(t, err)
+	var events []event
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&events))
+	resp.Body.Close()
+	assert.Len(t, events, 2)
+	assert.Equal(t, "2", events[0].Camera)
+	assert.Equal(t, 3*time.Second, events[0].Duration())
+	assert.True(t, events[0].Snapshot)
+	assert.Equal(t, "1", events[1].Camera)
+	assert.True(t, events[1].Snapshot)
+	assert.Equal(t, clip, events[1].Clip)
+	assert.Equal(t, int64(104), events[1].Size)
+
+	resp, err = http.Get(svr.URL + "/events/" + events[1].ID + "/snapshot.jpg")
+	assert.NoError(t, err)
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "jpeg", string(data))
+	resp, err = http.Get(svr.URL + "/events/")
+	assert.NoError(t, err)
+	data, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Contains(t, string(data), events[1].ID+"/clip")
+
+	// the events are loaded when restarting
+	s, err = newEventStore(dir, time.Hour, 0)
+	assert.NoError(t, err)
+	assert.Len(t, s.snapshot(), 2)
+
+	// retention by size removes the oldest event and its clip
+	s.maxBytes = 50
+	s.purge(time.Now())
+	events = s.snapshot()
+	assert.Len(t, events, 1)
+	assert.Equal(t, "2", events[0].Camera)
+	_, err = os.Stat(clip)
+	assert.True(t, os.IsNotExist(err))
+
+	// retention by age
+	s.purge(time.Now().Add(2 * time.Hour))
+	assert.Empty(t, s.snapshot())
+}
@@ -2,18 +2,22 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"os/exec"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/shanghuiyang/rpi-devices/dev"
 	"github.com/shanghuiyang/rpi-devices/util"
+	"github.com/shanghuiyang/rpi-devices/util/motion"
+	"github.com/shanghuiyang/rpi-devices/util/sound"
 	"github.com/stianeikeland/go-rpio"
 )
 
@@ -23,6 +27,35 @@ const (
 	pinLed = 21
 	pinBzr = 11
 	pinBtn = 4
+	// pinVoice is the pin of the sound sensor, it's used when micEnabled is false
+	pinVoice = 17
+)
+
+const (
+	// motionCtl is the webcontrol of motion, it must be in text mode, see the webcontrol of res/motion/normal_mode.conf
+	motionCtl   = "http://localhost:8088"
+	motionReady = 10 * time.Second
+
+	// the events are kept for a week, and use 2GB of disk at most
+	eventDir      = "/home/pi/motion_events"
+	eventMaxAge   = 7 * 24 * time.Hour
+	eventMaxBytes = 2 << 30
+
+	// motionStream is the stream of motion, it's proxied on /video to keep track of the viewers
+	motionStream = "http://localhost:8081"
+	// usersFile is a json file like {"user": "password"},
+	// the viewers must log in to watch the video if the file exists.
+	// it's next to the executable like the other files of vmonitor, see appFile().
+	usersFile = "users.json"
+	// ptzFile saves the position, the presets and the tours of the pan-tilt
+	ptzFile = "ptz.json"
+
+	// micEnabled records the sound from the usb microphone in baby mode,
+	// or a digital sound sensor on pinVoice is used.
+	micEnabled = true
+	// emailFile is the config of the email notification of the baby monitor,
+	// like {"email": {"smtp": "smtp.xxx.com", ...}, "to": ["mom@xxx.com"]}
+	emailFile = "email.json"
 )
 
 const (
@@ -57,6 +90,26 @@ var (
 		normalMode: "/home/pi/motion_conf/normal_mode.conf",
 		babyMode:   "/home/pi/motion_conf/baby_mode.conf",
 	}
+	// modeParams are the parameters which differ between the config files of the modes,
+	// they're set by the webcontrol on switching the mode, so motion isn't restarted.
+	modeParams = map[mode]map[string]string{
+		normalMode: {
+			"brightness":         "100",
+			"threshold":          "1500",
+			"locate_motion_mode": "off",
+			"text_left":          "",
+			"text_double":        "off",
+			"on_area_detected":   "",
+		},
+		babyMode: {
+			"brightness":         "150",
+			"threshold":          "800",
+			"locate_motion_mode": "on",
+			"text_left":          "baby mode",
+			"text_double":        "on",
+			"on_area_detected":   "/home/pi/motion.sh",
+		},
+	}
 )
 
 func main() {
@@ -93,7 +146,14 @@ func main() {
 		log.Printf("[vmonitor]failed to new a button, will run the monitor without button")
 	}
 
-	server := newVideoServer(hServo, vServo, led, bzr, btn)
+	var snd soundSource
+	if micEnabled {
+		snd = newMicSource()
+	} else {
+		snd = &sensorSource{det: dev.NewVoiceDetector(pinVoice)}
+	}
+
+	server := newVideoServer(hServo, vServo, led, bzr, btn, snd)
 	if server == nil {
 		log.Printf("[vmonitor]failed to new the video server")
 		return
@@ -109,34 +169,70 @@ func main() {
 }
 
 type videoServer struct {
-	hServo *dev.SG90
-	vServo *dev.SG90
+	ptz    *ptz
 	led    *dev.Led
 	buzzer *dev.Buzzer
 	button *dev.Button
+	motion *motion.Client
+	events *eventStore
+	proxy  *viewerProxy
+	baby   *babyMonitor
+	notify notifier
 
 	mode        mode
 	inServing   bool
-	hAngle      int
-	vAngle      int
-	chAlert     chan int
+	chAlert     chan *viewerEvent
 	pageContext []byte
 }
 
-func newVideoServer(hServo, vServo *dev.SG90, led *dev.Led, buzzer *dev.Buzzer, button *dev.Button) *videoServer {
+func newVideoServer(hServo, vServo *dev.SG90, led *dev.Led, buzzer *dev.Buzzer, button *dev.Button, snd soundSource) *videoServer {
 	v := &videoServer{
-		hServo: hServo,
-		vServo: vServo,
 		led:    led,
 		buzzer: buzzer,
 		button: button,
+		motion: motion.NewClient(motionCtl),
 
 		mode:      normalMode,
 		inServing: true,
-		hAngle:    0,
-		vAngle:    0,
-		chAlert:   make(chan int, 16),
+		chAlert:   make(chan *viewerEvent, 16),
+	}
+
+	p, err := newPTZ(hServo, vServo, defaultLimits, appFile(ptzFile))
+	if err != nil {
+		log.Printf("[vmonitor]failed to load the ptz state, error: %v", err)
+		return nil
 	}
+	v.ptz = p
+
+	v.baby = newBabyMonitor(snd, sound.DefaultConfig, func() bool { return v.mode == babyMode }, v.babyAlert)
+	if n, err := loadEmailNotifier(appFile(emailFile)); err == nil {
+		v.notify = n
+	} else if !os.IsNotExist(err) {
+		log.Printf("[vmonitor]failed to load %v, error: %v", emailFile, err)
+	}
+
+	users, err := loadUsers(appFile(usersFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("[vmonitor]failed to load users, error: %v", err)
+			return nil
+		}
+		users = nil
+		log.Printf("[vmonitor]%v doesn't exist, the video is open to everyone", appFile(usersFile))
+	}
+	v.proxy = newViewerProxy(motionStream, users, func(e *viewerEvent) {
+		select {
+		case v.chAlert <- e:
+		default:
+		}
+	})
+
+	events, err := newEventStore(eventDir, eventMaxAge, eventMaxBytes)
+	if err != nil {
+		log.Printf("[vmonitor]failed to open the event store, error: %v", err)
+		return nil
+	}
+	v.events = events
 
 	if err := v.restartMotion(); err != nil {
 		return nil
@@ -145,19 +241,26 @@ func newVideoServer(hServo, vServo *dev.SG90, led *dev.Led, buzzer *dev.Buzzer,
 }
 
 func (v *videoServer) start() {
-	go v.hServo.Roll(v.hAngle)
-	go v.vServo.Roll(v.vAngle)
+	go v.ptz.restore()
+	go v.baby.start()
 	go v.alert()
-	go v.detectConnecting()
 	go v.detectServing()
 	go v.detectingMode()
+	go v.events.keepPurging()
 
 	if err := v.loadHomePage(); err != nil {
 		log.Fatalf("failed to load home page, error: %v", err)
 		return
 	}
 
-	http.HandleFunc("/", v.handler)
+	// all the apis require the authentication if users.json exists, the video and the viewers check it themselves
+	http.Handle("/", v.proxy.protect(http.HandlerFunc(v.handler)))
+	http.Handle("/events/", v.proxy.protect(v.events))
+	http.HandleFunc("/video", v.video)
+	http.HandleFunc("/viewers", v.proxy.list)
+	http.Handle("/ptz", v.proxy.protect(v.ptz))
+	http.Handle("/ptz/", v.proxy.protect(v.ptz))
+	http.Handle("/baby/levels", v.proxy.protect(http.HandlerFunc(v.baby.levels)))
 	err := http.ListenAndServe(":8080", nil)
 	if err != nil {
 		log.Fatal("ListenAndServe: ", err.Error())
@@ -166,7 +269,7 @@ func (v *videoServer) start() {
 
 func (v *videoServer) loadHomePage() error {
 	if v.mode == normalMode {
-		data, err := ioutil.ReadFile("vmonitor.html")
+		data, err := ioutil.ReadFile(appFile("vmonitor.html"))
 		if err != nil {
 			return err
 		}
@@ -179,7 +282,7 @@ func (v *videoServer) loadHomePage() error {
 		if ip == "" {
 			return errors.New("internal error: failed to get ip")
 		}
-		data, err := ioutil.ReadFile("vmonitor_baby.html")
+		data, err := ioutil.ReadFile(appFile("vmonitor_baby.html"))
 		if err != nil {
 			return err
 		}
@@ -213,6 +316,15 @@ func (v *videoServer) handler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// video proxies the stream of motion, it's closed out of service
+func (v *videoServer) video(w http.ResponseWriter, r *http.Request) {
+	if !v.inServing {
+		http.Error(w, "out of service", http.StatusServiceUnavailable)
+		return
+	}
+	v.proxy.stream(w, r)
+}
+
 func (v *videoServer) homePageHandler(w http.ResponseWriter, r *http.Request) {
 	if v.outOfService() && v.mode == normalMode {
 		w.Write([]byte(pageOutOfService))
@@ -240,51 +352,26 @@ func (v *videoServer) do(op string) {
 
 func (v *videoServer) stop() {
 	v.led.Off()
-	close(v.chAlert)
 }
 
 func (v *videoServer) left() {
 	log.Printf("[vmonitor]op: left")
-	angle := v.hAngle - 15
-	if angle < -90 {
-		return
-	}
-	v.hAngle = angle
-	log.Printf("[vmonitor]servo: %v", angle)
-	v.hServo.Roll(angle)
+	v.ptz.step(-1, 0)
 }
 
 func (v *videoServer) right() {
 	log.Printf("[vmonitor]op: right")
-	angle := v.hAngle + 15
-	if angle > 75 {
-		return
-	}
-	v.hAngle = angle
-	log.Printf("[vmonitor]servo: %v", angle)
-	v.hServo.Roll(angle)
+	v.ptz.step(1, 0)
 }
 
 func (v *videoServer) up() {
 	log.Printf("[vmonitor]op: up")
-	angle := v.vAngle + 15
-	if angle > 90 {
-		return
-	}
-	v.vAngle = angle
-	log.Printf("[vmonitor]servo: %v", angle)
-	v.vServo.Roll(angle)
+	v.ptz.step(0, 1)
 }
 
 func (v *videoServer) down() {
 	log.Printf("[vmonitor]op: down")
-	angle := v.vAngle - 15
-	if angle < -30 {
-		return
-	}
-	v.vAngle = angle
-	log.Printf("[vmonitor]servo: %v", angle)
-	v.vServo.Roll(angle)
+	v.ptz.step(0, -1)
 }
 
 func (v *videoServer) beep(n int, interval int) {
@@ -295,55 +382,37 @@ func (v *videoServer) beep(n int, interval int) {
 	v.buzzer.Beep(n, interval)
 }
 
-func (v *videoServer) detectConnecting() {
-	for {
-		time.Sleep(5 * time.Second)
-		n, err := v.getConCount()
-		if err != nil {
-			log.Printf("[vmonitor]failed to get users count, err: %v", err)
-			continue
-		}
-		v.chAlert <- n
-	}
-}
-
 func (v *videoServer) alert() {
-	conCount := 0
 	for {
-		if v.mode == babyMode {
-			time.Sleep(1 * time.Second)
-			continue
-		}
 		select {
-		case n := <-v.chAlert:
-			if n > conCount {
-				// there are new connections, give an alert
+		case e := <-v.chAlert:
+			if e.Type == viewerConnected && v.mode != babyMode {
+				// there is a new viewer, give an alert
 				go v.beep(2, 100)
 			}
-			conCount = n
 		default:
 			// do nothing
 		}
-		if conCount > 0 {
+		if v.proxy.count() > 0 && v.mode != babyMode {
 			v.led.Blink(1, 1000)
 		}
 		time.Sleep(1 * time.Second)
 	}
 }
 
-// getConCount is get the count of connecting to the server
-func (v *videoServer) getConCount() (int, error) {
-	cmd := `netstat -nat|grep -i "127.0.0.1:8081"|wc -l`
-	out, err := exec.Command("bash", "-c", cmd).CombinedOutput()
-	if err != nil {
-		return 0, err
-	}
-	var count int
-	str := string(out)
-	if n, err := fmt.Sscanf(str, "%d\n", &count); n != 1 || err != nil {
-		return 0, fmt.Errorf("failed to parse the output of netstat")
+// babyAlert alerts when the baby is crying
+func (v *videoServer) babyAlert(r *sound.Result) {
+	go v.beep(3, 200)
+	go v.led.Blink(10, 200)
+	if v.notify == nil {
+		return
 	}
-	return count, nil
+	go func() {
+		body := fmt.Sprintf("the baby is crying at %v, sound level: %.1f dB", time.Now().Format("15:04:05"), r.Level)
+		if err := v.notify.Notify("baby is crying", body); err != nil {
+			log.Printf("[vmonitor]failed to notify, error: %v", err)
+		}
+	}()
 }
 
 func (v *videoServer) detectServing() {
@@ -355,16 +424,22 @@ func (v *videoServer) detectServing() {
 		}
 		if v.outOfService() {
 			if v.inServing {
-				log.Printf("[vmonitor]out of service, stop motion")
-				util.StopMotion()
+				log.Printf("[vmonitor]out of service, pause the detection")
+				if err := v.motion.Pause(0); err != nil {
+					log.Printf("[vmonitor]failed to pause the detection, error: %v", err)
+					continue
+				}
 				v.inServing = false
 			}
 			continue
 		}
 
 		if !v.inServing {
-			log.Printf("[vmonitor]in service time, start motion")
-			util.StartMotion(motionConfs[v.mode])
+			log.Printf("[vmonitor]in service time, resume the detection")
+			if err := v.motion.Resume(0); err != nil {
+				log.Printf("[vmonitor]failed to resume the detection, error: %v", err)
+				continue
+			}
 			v.inServing = true
 		}
 	}
@@ -409,8 +484,8 @@ func (v *videoServer) detectingMode() {
 			log.Printf("[vmonitor]failed to load home page, error: %v", err)
 			continue
 		}
-		if err := v.restartMotion(); err != nil {
-			log.Printf("[vmonitor]failed to restart motion, error: %v", err)
+		if err := v.applyMode(); err != nil {
+			log.Printf("[vmonitor]failed to switch motion to %v mode, error: %v", v.mode, err)
 			continue
 		}
 		go v.led.Blink(5, 100)
@@ -418,11 +493,30 @@ func (v *videoServer) detectingMode() {
 	}
 }
 
+// applyMode sets the parameters of the mode by the webcontrol of motion,
+// and resumes the detection in baby mode since it keeps serving all the time.
+func (v *videoServer) applyMode() error {
+	for param, value := range modeParams[v.mode] {
+		if err := v.motion.Set(0, param, value); err != nil {
+			return err
+		}
+	}
+	if v.mode == babyMode && !v.inServing {
+		if err := v.motion.Resume(0); err != nil {
+			return err
+		}
+		v.inServing = true
+	}
+	return nil
+}
+
+// restartMotion restarts motion with the config file of the mode,
+// it's only for loading the config file on starting, use applyMode() to switch the mode.
 func (v *videoServer) restartMotion() error {
 	if err := util.StopMotion(); err != nil {
 		return err
 	}
-	if err := util.StartMotion(motionConfs[v.mode]); err != nil {
+	if err := v.startMotion(); err != nil {
 		return err
 	}
 	if v.mode == normalMode {
@@ -430,3 +524,26 @@ func (v *videoServer) restartMotion() error {
 	}
 	return nil
 }
+
+// startMotion starts motion with the config file of the mode, and waits until the camera is ready
+func (v *videoServer) startMotion() error {
+	if err := util.StartMotion(motionConfs[v.mode]); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), motionReady)
+	defer cancel()
+	return v.motion.WaitReady(ctx, 0)
+}
+
+// appFile returns the path of a file of vmonitor in the directory of the executable,
+// so that vmonitor finds its files wherever it's started, e.g. by systemd or rc.local.
+func appFile(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return name
+	}
+	return filepath.Join(filepath.Dir(exe), name)
+}
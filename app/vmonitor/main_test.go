@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -10,3 +12,10 @@ func TestStart(t *testing.T) {
 	v := &videoServer{}
 	assert.NotNil(t, v)
 }
+
+func TestAppFile(t *testing.T) {
+	exe, err := os.Executable()
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(filepath.Dir(exe), "users.json"), appFile("users.json"))
+	assert.Equal(t, "/etc/users.json", appFile("/etc/users.json"))
+}
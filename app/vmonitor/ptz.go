@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// servo is the interface of the pan and tilt servos, it's implemented by dev.SG90
+type servo interface {
+	Roll(angle int)
+}
+
+// limits are the soft limits of the pan and tilt angles, they're configured in the state file, e.g.
+//
+//	"limits": {"pan_min": -90, "pan_max": 75, "tilt_min": -30, "tilt_max": 90, "step": 15}
+type limits struct {
+	PanMin  int `json:"pan_min"`
+	PanMax  int `json:"pan_max"`
+	TiltMin int `json:"tilt_min"`
+	TiltMax int `json:"tilt_max"`
+	// Step is the angle of a left/right/up/down op
+	Step int `json:"step"`
+}
+
+// defaultLimits are used if the state file doesn't configure the limits
+var defaultLimits = limits{
+	PanMin:  -90,
+	PanMax:  75,
+	TiltMin: -30,
+	TiltMax: 90,
+	Step:    15,
+}
+
+func (l *limits) validate() error {
+	if l.PanMin >= l.PanMax {
+		return fmt.Errorf("pan_min %v must be less than pan_max %v", l.PanMin, l.PanMax)
+	}
+	if l.TiltMin >= l.TiltMax {
+		return fmt.Errorf("tilt_min %v must be less than tilt_max %v", l.TiltMin, l.TiltMax)
+	}
+	if l.Step <= 0 {
+		return fmt.Errorf("step %v must be positive", l.Step)
+	}
+	return nil
+}
+
+// position is a pan/tilt position in degree
+type position struct {
+	Pan  int `json:"pan"`
+	Tilt int `json:"tilt"`
+}
+
+// tourStop is a stop of a patrol tour
+type tourStop struct {
+	Preset string `json:"preset"`
+	// Dwell is how long the camera stays at the preset in seconds
+	Dwell int `json:"dwell"`
+}
+
+// tour is a patrol tour which cycles through the presets.
+// it only patrols between the hours From and To, e.g. 9 ~ 20, and always if both are 0.
+type tour struct {
+	Stops []tourStop `json:"stops"`
+	From  int        `json:"from"`
+	To    int        `json:"to"`
+}
+
+// active checks if the tour patrols at t
+func (t *tour) active(now time.Time) bool {
+	if t.From == t.To {
+		return true
+	}
+	h := now.Hour()
+	if t.From < t.To {
+		return h >= t.From && h < t.To
+	}
+	// the hours across the midnight, e.g. 20 ~ 9
+	return h >= t.From || h < t.To
+}
+
+// ptzState is the state persisted in the state file
+type ptzState struct {
+	Limits   limits              `json:"limits"`
+	Position position            `json:"position"`
+	Presets  map[string]position `json:"presets"`
+	Tours    map[string]*tour    `json:"tours"`
+	// Touring is the running tour, it's resumed after restart
+	Touring string `json:"touring,omitempty"`
+}
+
+// ptz controls the pan/tilt servos with the presets and the patrol tours,
+// its state is saved to a file and restored when vmonitor restarts.
+type ptz struct {
+	pan    servo
+	tilt   servo
+	limits limits
+	file   string
+
+	mu     sync.Mutex
+	state  ptzState
+	cancel context.CancelFunc // cancels the running tour
+}
+
+// newPTZ loads the state from the file, lim is the default limits if the file doesn't configure them
+func newPTZ(pan, tilt servo, lim limits, file string) (*ptz, error) {
+	p := &ptz{
+		pan:  pan,
+		tilt: tilt,
+		file: file,
+		state: ptzState{
+			Limits:  lim,
+			Presets: map[string]position{},
+			Tours:   map[string]*tour{},
+		},
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &p.state); err != nil {
+			return nil, fmt.Errorf("failed to load %v, error: %v", file, err)
+		}
+		if p.state.Presets == nil {
+			p.state.Presets = map[string]position{}
+		}
+		if p.state.Tours == nil {
+			p.state.Tours = map[string]*tour{}
+		}
+	}
+	if err := p.state.Limits.validate(); err != nil {
+		return nil, fmt.Errorf("invalid limits in %v, error: %v", file, err)
+	}
+	p.limits = p.state.Limits
+	return p, nil
+}
+
+// restore moves the servos to the saved position, and resumes the tour
+func (p *ptz) restore() {
+	p.mu.Lock()
+	pos, touring := p.state.Position, p.state.Touring
+	p.mu.Unlock()
+
+	p.roll(p.clamp(pos))
+	if touring != "" {
+		if err := p.startTour(touring); err != nil {
+			log.Printf("[vmonitor]failed to resume tour %v, error: %v", touring, err)
+		}
+	}
+}
+
+// moveTo moves to an absolute position, the position is clamped to the limits.
+// it stops the running tour.
+func (p *ptz) moveTo(pos position) position {
+	p.stopTour()
+	return p.move(pos)
+}
+
+// step moves by the steps, e.g. step(-1, 0) is moving left by a step
+func (p *ptz) step(pan, tilt int) position {
+	p.mu.Lock()
+	pos := p.state.Position
+	p.mu.Unlock()
+	pos.Pan += pan * p.limits.Step
+	pos.Tilt += tilt * p.limits.Step
+	return p.moveTo(pos)
+}
+
+func (p *ptz) move(pos position) position {
+	pos = p.clamp(pos)
+	p.mu.Lock()
+	last := p.state.Position
+	p.state.Position = pos
+	p.save()
+	p.mu.Unlock()
+
+	if pos != last {
+		log.Printf("[vmonitor]ptz: %+v", pos)
+		p.roll(pos)
+	}
+	return pos
+}
+
+func (p *ptz) roll(pos position) {
+	p.pan.Roll(pos.Pan)
+	p.tilt.Roll(pos.Tilt)
+}
+
+func (p *ptz) clamp(pos position) position {
+	clamp := func(v, min, max int) int {
+		if v < min {
+			return min
+		}
+		if v > max {
+			return max
+		}
+		return v
+	}
+	return position{
+		Pan:  clamp(pos.Pan, p.limits.PanMin, p.limits.PanMax),
+		Tilt: clamp(pos.Tilt, p.limits.TiltMin, p.limits.TiltMax),
+	}
+}
+
+// savePreset saves the current position as a preset
+func (p *ptz) savePreset(name string) error {
+	if name == "" {
+		return errors.New("empty preset name")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state.Presets[name] = p.state.Position
+	return p.save()
+}
+
+// deletePreset deletes a preset, the tours using it skip it
+func (p *ptz) deletePreset(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.state.Presets[name]; !ok {
+		return fmt.Errorf("preset %v doesn't exist", name)
+	}
+	delete(p.state.Presets, name)
+	return p.save()
+}
+
+// gotoPreset moves to a preset, it stops the running tour
+func (p *ptz) gotoPreset(name string) (position, error) {
+	p.mu.Lock()
+	pos, ok := p.state.Presets[name]
+	p.mu.Unlock()
+	if !ok {
+		return position{}, fmt.Errorf("preset %v doesn't exist", name)
+	}
+	return p.moveTo(pos), nil
+}
+
+// saveTour saves a patrol tour
+func (p *ptz) saveTour(name string, t *tour) error {
+	if name == "" {
+		return errors.New("empty tour name")
+	}
+	if len(t.Stops) == 0 {
+		return errors.New("no stops in the tour")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, s := range t.Stops {
+		if _, ok := p.state.Presets[s.Preset]; !ok {
+			return fmt.Errorf("preset %v doesn't exist", s.Preset)
+		}
+		if s.Dwell <= 0 {
+			return fmt.Errorf("invalid dwell of %v: %v", s.Preset, s.Dwell)
+		}
+	}
+	p.state.Tours[name] = t
+	return p.save()
+}
+
+// startTour starts a patrol tour, the running tour is stopped
+func (p *ptz) startTour(name string) error {
+	p.stopTour()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.state.Tours[name]
+	if !ok {
+		return fmt.Errorf("tour %v doesn't exist", name)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	p.state.Touring = name
+	go p.patrol(ctx, t)
+	log.Printf("[vmonitor]tour %v started", name)
+	return p.save()
+}
+
+// stopTour stops the running tour if any
+func (p *ptz) stopTour() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cancel == nil {
+		return
+	}
+	p.cancel()
+	p.cancel = nil
+	log.Printf("[vmonitor]tour %v stopped", p.state.Touring)
+	p.state.Touring = ""
+	p.save()
+}
+
+// patrol cycles through the stops of the tour until ctx is done
+func (p *ptz) patrol(ctx context.Context, t *tour) {
+	for i := 0; ; i = (i + 1) % len(t.Stops) {
+		wait := time.Minute
+		if t.active(time.Now()) {
+			s := t.Stops[i]
+			p.mu.Lock()
+			pos, ok := p.state.Presets[s.Preset]
+			p.mu.Unlock()
+			if ok && ctx.Err() == nil {
+				p.move(pos)
+				wait = time.Duration(s.Dwell) * time.Second
+			} else if !ok {
+				// the preset was deleted
+				wait = time.Second
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// save saves the state to the file, p.mu must be held
+func (p *ptz) save() error {
+	data, err := json.MarshalIndent(&p.state, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(p.file, data, 0644); err != nil {
+		log.Printf("[vmonitor]failed to save ptz state, error: %v", err)
+		return err
+	}
+	return nil
+}
+
+// ptzStatus is the response of the json api
+type ptzStatus struct {
+	Position position            `json:"position"`
+	Limits   limits              `json:"limits"`
+	Presets  map[string]position `json:"presets"`
+	Tours    map[string]*tour    `json:"tours"`
+	Touring  string              `json:"touring"`
+}
+
+func (p *ptz) status() *ptzStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := &ptzStatus{
+		Position: p.state.Position,
+		Limits:   p.limits,
+		Presets:  map[string]position{},
+		Tours:    map[string]*tour{},
+		Touring:  p.state.Touring,
+	}
+	for k, v := range p.state.Presets {
+		s.Presets[k] = v
+	}
+	for k, v := range p.state.Tours {
+		s.Tours[k] = v
+	}
+	return s
+}
+
+// ptzRequest is the request of the json api, the fields used depend on the command
+type ptzRequest struct {
+	Pan    *int       `json:"pan"`
+	Tilt   *int       `json:"tilt"`
+	Name   string     `json:"name"`
+	Stops  []tourStop `json:"stops"`
+	From   int        `json:"from"`
+	To     int        `json:"to"`
+	Delete bool       `json:"delete"`
+}
+
+// ServeHTTP serves the json api:
+//	GET  /ptz                                  the status
+//	POST /ptz/move    {"pan": 30, "tilt": 0}   moves to an absolute position, either can be omitted
+//	POST /ptz/preset  {"name": "door"}         saves the current position as a preset, or deletes it with "delete": true
+//	POST /ptz/goto    {"name": "door"}         moves to a preset
+//	POST /ptz/tour    {"name": "day", "stops": [{"preset": "door", "dwell": 10}], "from": 9, "to": 20}  saves a tour
+//	POST /ptz/start   {"name": "day"}          starts a tour
+//	POST /ptz/stop                             stops the tour
+func (p *ptz) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && r.URL.Path == "/ptz" {
+		p.writeStatus(w)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ptzRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	var err error
+	switch r.URL.Path {
+	case "/ptz/move":
+		pos := p.status().Position
+		if req.Pan != nil {
+			pos.Pan = *req.Pan
+		}
+		if req.Tilt != nil {
+			pos.Tilt = *req.Tilt
+		}
+		p.moveTo(pos)
+	case "/ptz/preset":
+		if req.Delete {
+			err = p.deletePreset(req.Name)
+		} else {
+			err = p.savePreset(req.Name)
+		}
+	case "/ptz/goto":
+		_, err = p.gotoPreset(req.Name)
+	case "/ptz/tour":
+		err = p.saveTour(req.Name, &tour{Stops: req.Stops, From: req.From, To: req.To})
+	case "/ptz/start":
+		err = p.startTour(req.Name)
+	case "/ptz/stop":
+		p.stopTour()
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	p.writeStatus(w)
+}
+
+func (p *ptz) writeStatus(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.status())
+}
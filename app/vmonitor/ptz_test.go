@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockServo struct {
+	mu    sync.Mutex
+	angle int
+}
+
+func (s *mockServo) Roll(angle int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.angle = angle
+}
+
+func (s *mockServo) get() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.angle
+}
+
+func TestPTZ(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ptz")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ptz.json")
+
+	pan, tilt := &mockServo{}, &mockServo{}
+	p, err := newPTZ(pan, tilt, defaultLimits, file)
+	assert.NoError(t, err)
+
+	// steps and the soft limits
+	assert.Equal(t, position{Pan: -15}, p.step(-1, 0))
+	assert.Equal(t, position{Pan: -15, Tilt: -15}, p.step(0, -1))
+	assert.Equal(t, position{Pan: -15, Tilt: -30}, p.step(0, -1))
+	assert.Equal(t, position{Pan: 75, Tilt: 90}, p.moveTo(position{Pan: 100, Tilt: 100}))
+	assert.Equal(t, 75, pan.get())
+	assert.Equal(t, 90, tilt.get())
+
+	// presets
+	assert.NoError(t, p.savePreset("corner"))
+	p.moveTo(position{Pan: -30, Tilt: 10})
+	assert.NoError(t, p.savePreset("door"))
+	pos, err := p.gotoPreset("corner")
+	assert.NoError(t, err)
+	assert.Equal(t, position{Pan: 75, Tilt: 90}, pos)
+	_, err = p.gotoPreset("window")
+	assert.Error(t, err)
+
+	// tours
+	assert.Error(t, p.saveTour("night", &tour{Stops: []tourStop{{Preset: "window", Dwell: 1}}}))
+	assert.NoError(t, p.saveTour("day", &tour{Stops: []tourStop{{Preset: "door", Dwell: 1}, {Preset: "corner", Dwell: 1}}}))
+	assert.NoError(t, p.startTour("day"))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, -30, pan.get())
+	assert.Equal(t, "day", p.status().Touring)
+
+	// the state is restored with the running tour
+	p.stopTour()
+	p.startTour("day")
+	p2, err := newPTZ(&mockServo{}, &mockServo{}, defaultLimits, file)
+	assert.NoError(t, err)
+	s := p2.status()
+	assert.Equal(t, position{Pan: -30, Tilt: 10}, s.Position)
+	assert.Len(t, s.Presets, 2)
+	assert.Equal(t, "day", s.Touring)
+
+	// a manual move stops the tour
+	p.step(1, 0)
+	assert.Equal(t, "", p.status().Touring)
+}
+
+func TestPTZLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ptz")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ptz.json")
+
+	// the limits not in the file are the defaults
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`{"limits": {"pan_min": -45, "pan_max": 45}}`), 0644))
+	p, err := newPTZ(&mockServo{}, &mockServo{}, defaultLimits, file)
+	assert.NoError(t, err)
+	assert.Equal(t, position{Pan: 45, Tilt: 90}, p.moveTo(position{Pan: 100, Tilt: 100}))
+	assert.Equal(t, 15, p.status().Limits.Step)
+
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`{"limits": {"tilt_min": 90, "tilt_max": -30}}`), 0644))
+	_, err = newPTZ(&mockServo{}, &mockServo{}, defaultLimits, file)
+	assert.Error(t, err)
+}
+
+func TestTourActive(t *testing.T) {
+	at := func(h int) time.Time {
+		return time.Date(2020, 1, 1, h, 30, 0, 0, time.Local)
+	}
+	day := &tour{From: 9, To: 20}
+	assert.True(t, day.active(at(9)))
+	assert.False(t, day.active(at(20)))
+	night := &tour{From: 20, To: 9}
+	assert.True(t, night.active(at(23)))
+	assert.True(t, night.active(at(3)))
+	assert.False(t, night.active(at(12)))
+	always := &tour{}
+	assert.True(t, always.active(at(12)))
+}
+
+func TestPTZAPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ptz")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	p, err := newPTZ(&mockServo{}, &mockServo{}, defaultLimits, filepath.Join(dir, "ptz.json"))
+	assert.NoError(t, err)
+	svr := httptest.NewServer(p)
+	defer svr.Close()
+
+	post := func(path, body string) (int, *ptzStatus) {
+		resp, err := http.Post(svr.URL+path, "application/json", bytes.NewBufferString(body))
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		s := &ptzStatus{}
+		if resp.StatusCode == http.StatusOK {
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(s))
+		}
+		return resp.StatusCode, s
+	}
+
+	code, s := post("/ptz/move", `{"pan": 30}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, position{Pan: 30}, s.Position)
+	code, s = post("/ptz/preset", `{"name": "door"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, position{Pan: 30}, s.Presets["door"])
+	code, _ = post("/ptz/goto", `{"name": "window"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = post("/ptz/tour", `{"name": "day", "stops": [{"preset": "door", "dwell": 10}], "from": 9, "to": 20}`)
+	assert.Equal(t, http.StatusOK, code)
+	code, s = post("/ptz/start", `{"name": "day"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "day", s.Touring)
+	code, s = post("/ptz/stop", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "", s.Touring)
+	code, s = post("/ptz/preset", `{"name": "door", "delete": true}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, s.Presets)
+
+	resp, err := http.Get(svr.URL + "/ptz")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	proxyBufSize = 32 << 10
+)
+
+type viewerEventType string
+
+const (
+	viewerConnected    viewerEventType = "connected"
+	viewerDisconnected viewerEventType = "disconnected"
+)
+
+// viewer is a session of watching the video
+type viewer struct {
+	ID    int       `json:"id"`
+	Addr  string    `json:"addr"`
+	User  string    `json:"user,omitempty"`
+	Since time.Time `json:"since"`
+}
+
+// viewerEvent is fired when a viewer connects or disconnects
+type viewerEvent struct {
+	Type    viewerEventType
+	Viewer  viewer
+	Viewers int // the number of viewers after the event
+}
+
+// viewerProxy proxies the mjpeg stream of motion and keeps track of the viewers,
+// it replaces counting the connections to the stream port using netstat.
+type viewerProxy struct {
+	upstream string
+	users    map[string]string // user -> password, nil for no authentication
+	onEvent  func(e *viewerEvent)
+	cli      *http.Client
+
+	mu      sync.Mutex
+	seq     int
+	viewers map[int]*viewer
+}
+
+func newViewerProxy(upstream string, users map[string]string, onEvent func(e *viewerEvent)) *viewerProxy {
+	return &viewerProxy{
+		upstream: upstream,
+		users:    users,
+		onEvent:  onEvent,
+		cli:      &http.Client{},
+		viewers:  map[int]*viewer{},
+	}
+}
+
+// loadUsers loads the users from a json file like {"user": "password"}
+func loadUsers(file string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	users := map[string]string{}
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// stream proxies the stream to a viewer until the viewer disconnects
+func (p *viewerProxy) stream(w http.ResponseWriter, r *http.Request) {
+	user, ok := p.auth(r)
+	if !ok {
+		unauthorized(w)
+		return
+	}
+
+	req, err := http.NewRequest("GET", p.upstream, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	resp, err := p.cli.Do(req.WithContext(r.Context()))
+	if err != nil {
+		log.Printf("[vmonitor]failed to connect to the stream, error: %v", err)
+		http.Error(w, "video isn't available", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	v := p.connect(r.RemoteAddr, user)
+	defer p.disconnect(v)
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(resp.StatusCode)
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, proxyBufSize)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err != nil {
+			if err != io.EOF && r.Context().Err() == nil {
+				log.Printf("[vmonitor]stream of viewer %v broke, error: %v", v.ID, err)
+			}
+			return
+		}
+	}
+}
+
+// list serves the viewers in json
+func (p *viewerProxy) list(w http.ResponseWriter, r *http.Request) {
+	if _, ok := p.auth(r); !ok {
+		unauthorized(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p.snapshot())
+}
+
+// protect requires the same authentication as the video for h,
+// except the hooks of motion from localhost, since motion can't authenticate.
+func (p *viewerProxy) protect(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if eventHooks[r.URL.Path] && isLoopback(r.RemoteAddr) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := p.auth(r); !ok {
+			unauthorized(w)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// isLoopback checks if the remote address of a request is localhost
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Basic realm="vmonitor"`)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+// auth checks the basic authentication of the request, and returns the user
+func (p *viewerProxy) auth(r *http.Request) (string, bool) {
+	if p.users == nil {
+		return "", true
+	}
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	expected, ok := p.users[user]
+	if !ok {
+		return "", false
+	}
+	return user, subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+}
+
+func (p *viewerProxy) connect(addr, user string) *viewer {
+	p.mu.Lock()
+	p.seq++
+	v := &viewer{
+		ID:    p.seq,
+		Addr:  addr,
+		User:  user,
+		Since: time.Now(),
+	}
+	p.viewers[v.ID] = v
+	n := len(p.viewers)
+	p.mu.Unlock()
+
+	log.Printf("[vmonitor]viewer %v connected from %v, viewers: %v", v.ID, addr, n)
+	p.fire(&viewerEvent{Type: viewerConnected, Viewer: *v, Viewers: n})
+	return v
+}
+
+func (p *viewerProxy) disconnect(v *viewer) {
+	p.mu.Lock()
+	delete(p.viewers, v.ID)
+	n := len(p.viewers)
+	p.mu.Unlock()
+
+	log.Printf("[vmonitor]viewer %v disconnected after %v, viewers: %v", v.ID, time.Since(v.Since).Truncate(time.Second), n)
+	p.fire(&viewerEvent{Type: viewerDisconnected, Viewer: *v, Viewers: n})
+}
+
+func (p *viewerProxy) fire(e *viewerEvent) {
+	if p.onEvent != nil {
+		p.onEvent(e)
+	}
+}
+
+// count returns the number of the viewers
+func (p *viewerProxy) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.viewers)
+}
+
+// snapshot returns the viewers sorted by the connect time
+func (p *viewerProxy) snapshot() []viewer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	viewers := make([]viewer, 0, len(p.viewers))
+	for _, v := range p.viewers {
+		viewers = append(viewers, *v)
+	}
+	sort.Slice(viewers, func(i, j int) bool {
+		return viewers[i].ID < viewers[j].ID
+	})
+	return viewers
+}
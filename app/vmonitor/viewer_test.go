@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestViewerProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary=BoundaryString")
+		for i := 0; ; i++ {
+			if _, err := fmt.Fprintf(w, "--BoundaryString\r\nframe %v\r\n", i); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+	defer upstream.Close()
+
+	events := make(chan *viewerEvent, 4)
+	p := newViewerProxy(upstream.URL, map[string]string{"pi": "raspberry"}, func(e *viewerEvent) {
+		events <- e
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/video", p.stream)
+	mux.HandleFunc("/viewers", p.list)
+	svr := httptest.NewServer(mux)
+	defer svr.Close()
+
+	resp, err := http.Get(svr.URL + "/video")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	req, _ := http.NewRequest("GET", svr.URL+"/video", nil)
+	req.SetBasicAuth("pi", "raspberry")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "multipart/x-mixed-replace")
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "--BoundaryString\r\n", line)
+
+	e := <-events
+	assert.Equal(t, viewerConnected, e.Type)
+	assert.Equal(t, "pi", e.Viewer.User)
+	assert.Equal(t, 1, e.Viewers)
+
+	req, _ = http.NewRequest("GET", svr.URL+"/viewers", nil)
+	req.SetBasicAuth("pi", "raspberry")
+	listResp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	var viewers []viewer
+	assert.NoError(t, json.NewDecoder(listResp.Body).Decode(&viewers))
+	listResp.Body.Close()
+	assert.Len(t, viewers, 1)
+	assert.Equal(t, "pi", viewers[0].User)
+
+	// the viewer leaves
+	resp.Body.Close()
+	select {
+	case e = <-events:
+		assert.Equal(t, viewerDisconnected, e.Type)
+		assert.Equal(t, 0, e.Viewers)
+	case <-time.After(2 * time.Second):
+		t.Fatal("no disconnected event")
+	}
+	assert.Equal(t, 0, p.count())
+}
+
+func TestProtect(t *testing.T) {
+	p := newViewerProxy("", map[string]string{"pi": "raspberry"}, nil)
+	h := p.protect(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	tests := []struct {
+		path   string
+		addr   string
+		auth   bool
+		status int
+	}{
+		{"/events/", "192.168.31.10:5000", false, http.StatusUnauthorized},
+		{"/events/", "192.168.31.10:5000", true, http.StatusOK},
+		{"/ptz", "127.0.0.1:5000", false, http.StatusUnauthorized},
+		{"/events/start", "127.0.0.1:5000", false, http.StatusOK},
+		{"/events/end", "[::1]:5000", false, http.StatusOK},
+		{"/events/start", "192.168.31.10:5000", false, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", test.path, nil)
+		r.RemoteAddr = test.addr
+		if test.auth {
+			r.SetBasicAuth("pi", "raspberry")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, test.status, w.Code, "%v from %v", test.path, test.addr)
+	}
+}
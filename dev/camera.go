@@ -1,39 +1,222 @@
+/*
+Package dev ...
+
+Camera captures the frames from a frame source:
+ - V4L2:  a video device like /dev/video0, e.g. a usb camera or the pi camera with bcm2835-v4l2 driver
+ - MJPEG: a mjpeg stream over http like http://localhost:8081, e.g. the stream of motion,
+           use NewMJPEGSnapshotSource to grab a single frame on each Frame() instead of reading the stream in background
+ - Dir:   a directory of jpeg files, it's useful for tests
+
+	cam, err := dev.OpenCamera("/dev/video0", &dev.CameraConfig{Width: 640, Height: 480, Rotation: 180})
+	frame, err := cam.Frame()  // jpeg
+	img, err := cam.Read()     // image.Image
+
+*/
 package dev
 
 import (
-	"os/exec"
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 const (
-	imageFile = "/var/lib/motion/lastsnap.jpg"
+	// photoFile is where TakePhoto() saves the photo
+	photoFile = "camera.jpg"
 )
 
+// Frame is a jpeg frame with the time it was captured
+type Frame struct {
+	Time time.Time
+	JPEG []byte
+}
+
+// Image decodes the frame
+func (f *Frame) Image() (image.Image, error) {
+	return jpeg.Decode(bytes.NewReader(f.JPEG))
+}
+
+// FrameSource provides the jpeg frames
+type FrameSource interface {
+	Frame() (*Frame, error)
+	Close() error
+}
+
+// CameraConfig ...
+type CameraConfig struct {
+	// Width and Height is the resolution, 0 for the resolution of the source.
+	// a V4L2 device captures in the resolution, the frames of other sources are resized.
+	Width  int
+	Height int
+	// Rotation is the clockwise rotation in degree: 0, 90, 180 or 270
+	Rotation int
+}
+
 // Camera ...
 type Camera struct {
-	// steering *SG90
+	src FrameSource
+	cfg CameraConfig
+}
+
+// NewCamera creates a camera with a frame source
+func NewCamera(src FrameSource, cfg *CameraConfig) (*Camera, error) {
+	if src == nil {
+		return nil, errors.New("frame source is nil")
+	}
+	c := &Camera{src: src}
+	if cfg != nil {
+		c.cfg = *cfg
+	}
+	switch c.cfg.Rotation {
+	case 0, 90, 180, 270:
+	default:
+		return nil, fmt.Errorf("invalid rotation: %v", c.cfg.Rotation)
+	}
+	return c, nil
+}
+
+// OpenCamera opens a camera by a video device like "/dev/video0",
+// an url of mjpeg stream like "http://localhost:8081", or a directory of jpeg files.
+func OpenCamera(src string, cfg *CameraConfig) (*Camera, error) {
+	var (
+		fs  FrameSource
+		err error
+	)
+	switch {
+	case strings.HasPrefix(src, "/dev/video"):
+		var w, h int
+		if cfg != nil {
+			w, h = cfg.Width, cfg.Height
+		}
+		fs, err = NewV4L2Source(src, w, h)
+	case strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://"):
+		fs = NewMJPEGSource(src)
+	default:
+		fs, err = NewDirSource(src)
+	}
+	if err != nil {
+		return nil, err
+	}
+	cam, err := NewCamera(fs, cfg)
+	if err != nil {
+		fs.Close()
+		return nil, err
+	}
+	return cam, nil
+}
+
+// Frame captures a jpeg frame
+func (c *Camera) Frame() (*Frame, error) {
+	f, err := c.src.Frame()
+	if err != nil {
+		return nil, err
+	}
+	if c.cfg.Rotation == 0 && c.cfg.Width == 0 && c.cfg.Height == 0 {
+		return f, nil
+	}
+
+	img, err := c.transform(f)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		return nil, err
+	}
+	return &Frame{Time: f.Time, JPEG: buf.Bytes()}, nil
 }
 
-// NewCamera ...
-func NewCamera() *Camera {
-	return &Camera{}
+// Read captures a frame as an image, it makes the camera a frame source of util/cv/detect
+func (c *Camera) Read() (image.Image, error) {
+	f, err := c.src.Frame()
+	if err != nil {
+		return nil, err
+	}
+	return c.transform(f)
 }
 
-// TakePhoto take a photo using motion service.
-// in default, the created photo file will be in folder: /var/lib/motion
-// you can change the directory in the config of motion.
-// the config file is in /etc/motion/motion.conf
-// and you need to change the 'snapshot_filename' to 'lastsnap.jpg'
-// after changing, this will be look like:
-// -----------------------------------------
-// target_dir /var/lib/motion
-// snapshot_filename lastsnap.jpg
-// -----------------------------------------
+// TakePhoto captures a frame and saves it to a jpeg file, and returns the file name
 func (c *Camera) TakePhoto() (string, error) {
-	// curl -s -o /dev/null http://localhost:8088/0/action/snapshot
-	cmd := exec.Command("curl", "-s", "-o", "/dev/null", "http://localhost:8088/0/action/snapshot")
-	_, err := cmd.CombinedOutput()
+	f, err := c.Frame()
 	if err != nil {
 		return "", err
 	}
-	return imageFile, nil
+	file := filepath.Join(os.TempDir(), photoFile)
+	if err := ioutil.WriteFile(file, f.JPEG, 0644); err != nil {
+		return "", err
+	}
+	return file, nil
+}
+
+// Close ...
+func (c *Camera) Close() error {
+	return c.src.Close()
+}
+
+// transform decodes the frame, and resizes and rotates it
+func (c *Camera) transform(f *Frame) (image.Image, error) {
+	img, err := f.Image()
+	if err != nil {
+		return nil, err
+	}
+	if w, h := c.cfg.Width, c.cfg.Height; w > 0 && h > 0 && (img.Bounds().Dx() != w || img.Bounds().Dy() != h) {
+		img = resize(img, w, h)
+	}
+	if c.cfg.Rotation != 0 {
+		img = rotate(img, c.cfg.Rotation)
+	}
+	return img, nil
+}
+
+// resize resizes the image using the nearest neighbour
+func resize(img image.Image, w, h int) image.Image {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		sy := b.Min.Y + y*b.Dy()/h
+		for x := 0; x < w; x++ {
+			dst.Set(x, y, img.At(b.Min.X+x*b.Dx()/w, sy))
+		}
+	}
+	return dst
+}
+
+// rotate rotates the image clockwise by 90, 180 or 270 degree
+func rotate(img image.Image, degree int) image.Image {
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		src = image.NewRGBA(img.Bounds())
+		draw.Draw(src, src.Rect, img, img.Bounds().Min, draw.Src)
+	}
+	b := src.Rect
+	w, h := b.Dx(), b.Dy()
+	var dst *image.RGBA
+	if degree == 180 {
+		dst = image.NewRGBA(image.Rect(0, 0, w, h))
+	} else {
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch degree {
+			case 90:
+				dx, dy = h-1-y, x
+			case 180:
+				dx, dy = w-1-x, h-1-y
+			case 270:
+				dx, dy = y, w-1-x
+			}
+			dst.SetRGBA(dx, dy, src.RGBAAt(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
 }
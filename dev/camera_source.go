@@ -0,0 +1,278 @@
+package dev
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// frameTimeout is the max time to wait for a new frame from a stream
+	frameTimeout = 3 * time.Second
+	// reconnectInterval is the interval of reconnecting to a stream after it breaks
+	reconnectInterval = 2 * time.Second
+)
+
+// MJPEGSource reads the frames from a mjpeg stream over http, e.g. the stream of motion.
+// it keeps reading the stream in background, and Frame() returns the latest frame.
+type MJPEGSource struct {
+	url    string
+	client *http.Client
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	frame  *Frame
+	seq    int64
+	read   int64
+	err    error
+	closed bool
+	body   io.Closer
+}
+
+// NewMJPEGSource ...
+func NewMJPEGSource(url string) *MJPEGSource {
+	s := &MJPEGSource{
+		url:    url,
+		client: &http.Client{},
+	}
+	s.cond = sync.NewCond(&s.mu)
+	go s.loop()
+	return s
+}
+
+// Frame waits for a frame newer than the last one returned
+func (s *MJPEGSource) Frame() (*Frame, error) {
+	deadline := time.Now().Add(frameTimeout)
+	timer := time.AfterFunc(time.Until(deadline), func() {
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+	defer timer.Stop()
+
+	s.mu.Lock()
+	for s.seq == s.read && !s.closed && time.Now().Before(deadline) {
+		s.cond.Wait()
+	}
+	if s.closed {
+		s.mu.Unlock()
+		return nil, errors.New("source is closed")
+	}
+	if s.seq == s.read {
+		err := s.err
+		s.mu.Unlock()
+		if err == nil {
+			err = errors.New("timeout")
+		}
+		return nil, fmt.Errorf("no frame from %v, error: %v", s.url, err)
+	}
+	frame := s.frame
+	s.read = s.seq
+	s.mu.Unlock()
+	return frame, nil
+}
+
+// Close ...
+func (s *MJPEGSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.body != nil {
+		s.body.Close()
+	}
+	s.cond.Broadcast()
+	return nil
+}
+
+func (s *MJPEGSource) loop() {
+	for !s.isClosed() {
+		if err := s.stream(); err != nil && !s.isClosed() {
+			log.Printf("[camera]mjpeg stream %v broke, error: %v", s.url, err)
+			s.mu.Lock()
+			s.err = err
+			s.mu.Unlock()
+			time.Sleep(reconnectInterval)
+		}
+	}
+}
+
+// stream reads the frames until the stream breaks
+func (s *MJPEGSource) stream() error {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	r, err := mjpegReader(resp)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.body = resp.Body
+	s.mu.Unlock()
+
+	for {
+		data, err := readPart(r)
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.frame = &Frame{Time: time.Now(), JPEG: data}
+		s.seq++
+		s.err = nil
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}
+}
+
+func (s *MJPEGSource) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// MJPEGSnapshotSource reads a single frame from a mjpeg stream on each Frame(), e.g. for taking a photo now and then.
+// unlike MJPEGSource, it doesn't keep reading the stream in background.
+type MJPEGSnapshotSource struct {
+	url    string
+	client *http.Client
+}
+
+// NewMJPEGSnapshotSource ...
+func NewMJPEGSnapshotSource(url string) *MJPEGSnapshotSource {
+	return &MJPEGSnapshotSource{
+		url: url,
+		client: &http.Client{
+			Timeout: frameTimeout,
+		},
+	}
+}
+
+// Frame connects to the stream and returns the first frame
+func (s *MJPEGSnapshotSource) Frame() (*Frame, error) {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	r, err := mjpegReader(resp)
+	if err != nil {
+		return nil, err
+	}
+	data, err := readPart(r)
+	if err != nil {
+		return nil, fmt.Errorf("no frame from %v, error: %v", s.url, err)
+	}
+	return &Frame{Time: time.Now(), JPEG: data}, nil
+}
+
+// Close ...
+func (s *MJPEGSnapshotSource) Close() error {
+	return nil
+}
+
+// mjpegReader checks the response of a mjpeg stream, and returns the reader of its frames
+func mjpegReader(resp *http.Response) (*multipart.Reader, error) {
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http status: %v", resp.Status)
+	}
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	boundary := strings.TrimPrefix(params["boundary"], "--")
+	if boundary == "" {
+		return nil, errors.New("not a mjpeg stream, no boundary")
+	}
+	return multipart.NewReader(resp.Body, boundary), nil
+}
+
+// readPart reads the next frame of a mjpeg stream
+func readPart(r *multipart.Reader) ([]byte, error) {
+	part, err := r.NextPart()
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(part)
+}
+
+// DirSource reads the jpeg files in a directory in the order of file names.
+// it rescans the directory after reading the last image,
+// so the new images, e.g. the snapshots of motion, will be picked up.
+type DirSource struct {
+	dir   string
+	files []string
+	next  int
+}
+
+// NewDirSource ...
+func NewDirSource(dir string) (*DirSource, error) {
+	s := &DirSource{dir: dir}
+	if err := s.scan(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Frame reads the next file, the time of the frame is the modification time of the file
+func (s *DirSource) Frame() (*Frame, error) {
+	if s.next >= len(s.files) {
+		if err := s.scan(); err != nil {
+			return nil, err
+		}
+	}
+	file := s.files[s.next]
+	s.next++
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return &Frame{Time: info.ModTime(), JPEG: data}, nil
+}
+
+// Close ...
+func (s *DirSource) Close() error {
+	return nil
+}
+
+func (s *DirSource) scan() error {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	var files []string
+	for _, info := range infos {
+		ext := strings.ToLower(filepath.Ext(info.Name()))
+		if info.IsDir() || (ext != ".jpg" && ext != ".jpeg") {
+			continue
+		}
+		files = append(files, filepath.Join(s.dir, info.Name()))
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no jpeg images in %v", s.dir)
+	}
+	sort.Strings(files)
+	s.files = files
+	s.next = 0
+	return nil
+}
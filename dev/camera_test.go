@@ -0,0 +1,155 @@
+package dev
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newJPEG encodes an image of w x h, the left half is black and the right half is white
+func newJPEG(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.Black
+			if x >= w/2 {
+				c = color.White
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, img, nil))
+	return buf.Bytes()
+}
+
+func TestMJPEGSource(t *testing.T) {
+	frame := newJPEG(t, 64, 48)
+	done := make(chan bool)
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary=BoundaryString")
+		for {
+			fmt.Fprintf(w, "--BoundaryString\r\nContent-type: image/jpeg\r\nContent-Length: %d\r\n\r\n", len(frame))
+			w.Write(frame)
+			fmt.Fprintf(w, "\r\n")
+			w.(http.Flusher).Flush()
+			select {
+			case <-done:
+				return
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	}))
+	defer svr.Close()
+	defer close(done)
+
+	cam, err := OpenCamera(svr.URL, nil)
+	assert.NoError(t, err)
+	f, err := cam.Frame()
+	assert.NoError(t, err)
+	assert.Equal(t, frame, f.JPEG)
+	assert.False(t, f.Time.IsZero())
+	img, err := cam.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 64, 48), img.Bounds())
+	assert.NoError(t, cam.Close())
+
+	_, err = cam.Read()
+	assert.Error(t, err)
+}
+
+func TestMJPEGSnapshotSource(t *testing.T) {
+	frame := newJPEG(t, 64, 48)
+	var conns int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&conns, 1)
+		w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary=BoundaryString")
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "--BoundaryString\r\nContent-type: image/jpeg\r\nContent-Length: %d\r\n\r\n", len(frame))
+			w.Write(frame)
+			fmt.Fprintf(w, "\r\n")
+		}
+	}))
+	defer svr.Close()
+
+	cam, err := NewCamera(NewMJPEGSnapshotSource(svr.URL), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&conns))
+	for i := 1; i <= 2; i++ {
+		f, err := cam.Frame()
+		assert.NoError(t, err)
+		assert.Equal(t, frame, f.JPEG)
+		assert.Equal(t, int32(i), atomic.LoadInt32(&conns))
+	}
+	assert.NoError(t, cam.Close())
+}
+
+func TestDirSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frames")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	_, err = OpenCamera(dir, nil)
+	assert.Error(t, err)
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "b.jpg"), newJPEG(t, 32, 32), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.jpg"), newJPEG(t, 16, 16), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "readme.txt"), []byte("not an image"), 0644))
+
+	cam, err := OpenCamera(dir, nil)
+	assert.NoError(t, err)
+	defer cam.Close()
+	for _, w := range []int{16, 32, 16} {
+		img, err := cam.Read()
+		assert.NoError(t, err)
+		assert.Equal(t, w, img.Bounds().Dx())
+	}
+}
+
+func TestCameraTransform(t *testing.T) {
+	dir, err := ioutil.TempDir("", "frames")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.jpg"), newJPEG(t, 64, 32), 0644))
+
+	_, err = OpenCamera(dir, &CameraConfig{Rotation: 45})
+	assert.Error(t, err)
+
+	// rotated by 90 degree, the black half is on the top
+	cam, err := OpenCamera(dir, &CameraConfig{Rotation: 90})
+	assert.NoError(t, err)
+	img, err := cam.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 32, 64), img.Bounds())
+	top, _, _, _ := img.At(16, 8).RGBA()
+	bottom, _, _, _ := img.At(16, 56).RGBA()
+	assert.True(t, top < 0x2000)
+	assert.True(t, bottom > 0xE000)
+
+	// resized
+	cam, err = OpenCamera(dir, &CameraConfig{Width: 32, Height: 16})
+	assert.NoError(t, err)
+	f, err := cam.Frame()
+	assert.NoError(t, err)
+	img, err = f.Image()
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 32, 16), img.Bounds())
+
+	file, err := cam.TakePhoto()
+	assert.NoError(t, err)
+	defer os.Remove(file)
+	_, err = os.Stat(file)
+	assert.NoError(t, err)
+}
@@ -56,6 +56,9 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/shanghuiyang/rpi-devices/util/metrics"
 )
 
 const (
@@ -81,6 +84,17 @@ func NewDHT11() *DHT11 {
 
 // TempHumidity ...
 func (d *DHT11) TempHumidity() (float64, float64, error) {
+	start := time.Now()
+	t, h, err := d.tempHumidity()
+	metrics.ObserveRead("dht11", start, err)
+	if err == nil {
+		metrics.SetReading("dht11", "temperature", t)
+		metrics.SetReading("dht11", "humidity", h)
+	}
+	return t, h, err
+}
+
+func (d *DHT11) tempHumidity() (float64, float64, error) {
 	chTemp := make(chan float64)
 	chHumi := make(chan float64)
 
@@ -29,6 +29,9 @@ import (
 	"io/ioutil"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/shanghuiyang/rpi-devices/util/metrics"
 )
 
 var (
@@ -51,6 +54,16 @@ func NewDS18B20() *DS18B20 {
 // ca 01 55 00 7f ff 0c 10 bf t=28625
 // ~~~~~~~~~~~~~~~~~~~~~~~~~~~^^^^^^^~~~~~~~~
 func (d *DS18B20) GetTemperature() (float32, error) {
+	start := time.Now()
+	t, err := d.getTemperature()
+	metrics.ObserveRead("ds18b20", start, err)
+	if err == nil {
+		metrics.SetReading("ds18b20", "temperature", float64(t))
+	}
+	return t, err
+}
+
+func (d *DS18B20) getTemperature() (float32, error) {
 	data, err := ioutil.ReadFile(tempFile)
 	if err != nil {
 		return 0, err
@@ -66,6 +66,7 @@ import (
 	"log"
 	"os"
 	"strings"
+	"sync"
 
 	"github.com/shanghuiyang/rpi-devices/util/geo"
 	"github.com/tarm/serial"
@@ -87,6 +88,7 @@ var (
 
 // GPS ...
 type GPS struct {
+	mu   sync.Mutex
 	port *serial.Port
 }
 
@@ -100,7 +102,11 @@ func NewGPS() *GPS {
 }
 
 // Loc ...
+// it is safe to be called from multiple goroutines
 func (g *GPS) Loc() (*geo.Point, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
 	if err := g.port.Flush(); err != nil {
 		return nil, err
 	}
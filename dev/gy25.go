@@ -30,6 +30,7 @@ package dev
 import (
 	"fmt"
 	"math"
+	"sync"
 
 	"github.com/tarm/serial"
 )
@@ -59,6 +60,7 @@ var (
 
 // GY25 ...
 type GY25 struct {
+	mu   sync.Mutex
 	port *serial.Port
 	buf  [bufsize]byte
 }
@@ -74,6 +76,9 @@ func NewGY25(dev string, baud int) *GY25 {
 
 // SetMode ...
 func (g *GY25) SetMode(mode GY25Mode) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
 	if err := g.port.Flush(); err != nil {
 		return err
 	}
@@ -86,7 +91,11 @@ func (g *GY25) SetMode(mode GY25Mode) error {
 }
 
 // Angles ...
+// it is safe to be called from multiple goroutines
 func (g *GY25) Angles() (float64, float64, float64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
 	if err := g.port.Flush(); err != nil {
 		return 0, 0, 0, err
 	}
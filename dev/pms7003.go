@@ -31,8 +31,10 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"time"
 
 	"github.com/shanghuiyang/rpi-devices/util"
+	"github.com/shanghuiyang/rpi-devices/util/metrics"
 	"github.com/tarm/serial"
 )
 
@@ -67,6 +69,17 @@ func NewPMS7003() *PMS7003 {
 
 // Get returns pm2.5 and pm10 in ug/m3
 func (p *PMS7003) Get() (uint16, uint16, error) {
+	start := time.Now()
+	pm25, pm10, err := p.get()
+	metrics.ObserveRead("pms7003", start, err)
+	if err == nil {
+		metrics.SetReading("pms7003", "pm2.5", float64(pm25))
+		metrics.SetReading("pms7003", "pm10", float64(pm10))
+	}
+	return pm25, pm10, err
+}
+
+func (p *PMS7003) get() (uint16, uint16, error) {
 	for i := 0; i < p.maxRetry; i++ {
 		if err := p.port.Flush(); err != nil {
 			return 0, 0, err
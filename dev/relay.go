@@ -12,6 +12,7 @@ Connect to Pi:
 package dev
 
 import (
+	"github.com/shanghuiyang/rpi-devices/util/metrics"
 	"github.com/stianeikeland/go-rpio"
 )
 
@@ -36,6 +37,7 @@ func (r *Relay) On() {
 	if !r.isOn {
 		r.pin.High()
 		r.isOn = true
+		metrics.SetState("relay", uint8(r.pin), 1)
 	}
 }
 
@@ -44,5 +46,6 @@ func (r *Relay) Off() {
 	if r.isOn {
 		r.pin.Low()
 		r.isOn = false
+		metrics.SetState("relay", uint8(r.pin), 0)
 	}
 }
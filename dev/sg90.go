@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/shanghuiyang/rpi-devices/util"
+	"github.com/shanghuiyang/rpi-devices/util/metrics"
 	"github.com/stianeikeland/go-rpio"
 )
 
@@ -60,4 +61,5 @@ func (s *SG90) Roll(angle int) {
 	s.pin.DutyCycle(uint32(duty), 100)
 	time.Sleep(100 * time.Millisecond)
 	s.pin.DutyCycle(0, 100)
+	metrics.SetState("sg90", uint8(s.pin), float64(angle))
 }
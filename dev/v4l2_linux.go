@@ -0,0 +1,239 @@
+package dev
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// the constants in linux/videodev2.h
+const (
+	v4l2BufTypeVideoCapture = 1
+	v4l2MemoryMMAP          = 1
+	v4l2FieldAny            = 0
+	v4l2CapVideoCapture     = 0x00000001
+	v4l2CapStreaming        = 0x04000000
+
+	// v4l2BufCount is the number of the mmap buffers
+	v4l2BufCount = 4
+)
+
+var v4l2PixFmtMJPEG = fourcc('M', 'J', 'P', 'G')
+
+type v4l2Capability struct {
+	driver       [16]byte
+	card         [32]byte
+	busInfo      [32]byte
+	version      uint32
+	capabilities uint32
+	deviceCaps   uint32
+	reserved     [3]uint32
+}
+
+type v4l2PixFormat struct {
+	width        uint32
+	height       uint32
+	pixelformat  uint32
+	field        uint32
+	bytesperline uint32
+	sizeimage    uint32
+	colorspace   uint32
+	priv         uint32
+	flags        uint32
+	ycbcrEnc     uint32
+	quantization uint32
+	xferFunc     uint32
+}
+
+// v4l2Format is struct v4l2_format, the union is aligned to pointers
+type v4l2Format struct {
+	typ uint32
+	_   [unsafe.Sizeof(uintptr(0)) - 4]byte
+	pix v4l2PixFormat
+	_   [200 - unsafe.Sizeof(v4l2PixFormat{})]byte
+}
+
+type v4l2RequestBuffers struct {
+	count    uint32
+	typ      uint32
+	memory   uint32
+	reserved [2]uint32
+}
+
+// v4l2Buffer is struct v4l2_buffer, offset is the union m
+type v4l2Buffer struct {
+	index     uint32
+	typ       uint32
+	bytesused uint32
+	flags     uint32
+	field     uint32
+	timestamp syscall.Timeval
+	timecode  [16]byte
+	sequence  uint32
+	memory    uint32
+	offset    uintptr
+	length    uint32
+	reserved2 uint32
+	requestFD uint32
+}
+
+var (
+	vidiocQueryCap  = ior('V', 0, unsafe.Sizeof(v4l2Capability{}))
+	vidiocSFmt      = iowr('V', 5, unsafe.Sizeof(v4l2Format{}))
+	vidiocReqBufs   = iowr('V', 8, unsafe.Sizeof(v4l2RequestBuffers{}))
+	vidiocQueryBuf  = iowr('V', 9, unsafe.Sizeof(v4l2Buffer{}))
+	vidiocQBuf      = iowr('V', 15, unsafe.Sizeof(v4l2Buffer{}))
+	vidiocDQBuf     = iowr('V', 17, unsafe.Sizeof(v4l2Buffer{}))
+	vidiocStreamOn  = iow('V', 18, unsafe.Sizeof(int32(0)))
+	vidiocStreamOff = iow('V', 19, unsafe.Sizeof(int32(0)))
+)
+
+// V4L2Source captures the mjpeg frames from a video device using video4linux2.
+// the camera must support mjpeg, which most usb cameras and the pi camera do.
+// some usb cameras omit the huffman tables in the frames, which can't be decoded by image/jpeg.
+type V4L2Source struct {
+	mu   sync.Mutex
+	f    *os.File
+	bufs [][]byte
+}
+
+// NewV4L2Source opens a video device like /dev/video0 in the resolution,
+// the driver picks the closest resolution it supports, 0 for the default one.
+func NewV4L2Source(device string, width, height int) (*V4L2Source, error) {
+	f, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	s := &V4L2Source{f: f}
+	if err := s.init(width, height); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("failed to init %v, error: %v", device, err)
+	}
+	return s, nil
+}
+
+func (s *V4L2Source) init(width, height int) error {
+	var caps v4l2Capability
+	if err := s.ioctl(vidiocQueryCap, unsafe.Pointer(&caps)); err != nil {
+		return err
+	}
+	if caps.capabilities&v4l2CapVideoCapture == 0 || caps.capabilities&v4l2CapStreaming == 0 {
+		return fmt.Errorf("not a streaming capture device")
+	}
+
+	format := v4l2Format{typ: v4l2BufTypeVideoCapture}
+	format.pix.width = uint32(width)
+	format.pix.height = uint32(height)
+	format.pix.pixelformat = v4l2PixFmtMJPEG
+	format.pix.field = v4l2FieldAny
+	if err := s.ioctl(vidiocSFmt, unsafe.Pointer(&format)); err != nil {
+		return err
+	}
+	if format.pix.pixelformat != v4l2PixFmtMJPEG {
+		return fmt.Errorf("mjpeg isn't supported")
+	}
+
+	req := v4l2RequestBuffers{
+		count:  v4l2BufCount,
+		typ:    v4l2BufTypeVideoCapture,
+		memory: v4l2MemoryMMAP,
+	}
+	if err := s.ioctl(vidiocReqBufs, unsafe.Pointer(&req)); err != nil {
+		return err
+	}
+	for i := uint32(0); i < req.count; i++ {
+		buf := v4l2Buffer{
+			index:  i,
+			typ:    v4l2BufTypeVideoCapture,
+			memory: v4l2MemoryMMAP,
+		}
+		if err := s.ioctl(vidiocQueryBuf, unsafe.Pointer(&buf)); err != nil {
+			return err
+		}
+		data, err := syscall.Mmap(int(s.f.Fd()), int64(buf.offset), int(buf.length), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+		if err != nil {
+			return err
+		}
+		s.bufs = append(s.bufs, data)
+		if err := s.ioctl(vidiocQBuf, unsafe.Pointer(&buf)); err != nil {
+			return err
+		}
+	}
+
+	typ := int32(v4l2BufTypeVideoCapture)
+	return s.ioctl(vidiocStreamOn, unsafe.Pointer(&typ))
+}
+
+// Frame waits for the next frame
+func (s *V4L2Source) Frame() (*Frame, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil, fmt.Errorf("device is closed")
+	}
+
+	buf := v4l2Buffer{
+		typ:    v4l2BufTypeVideoCapture,
+		memory: v4l2MemoryMMAP,
+	}
+	if err := s.ioctl(vidiocDQBuf, unsafe.Pointer(&buf)); err != nil {
+		return nil, err
+	}
+	data := make([]byte, buf.bytesused)
+	copy(data, s.bufs[buf.index])
+	if err := s.ioctl(vidiocQBuf, unsafe.Pointer(&buf)); err != nil {
+		return nil, err
+	}
+	return &Frame{Time: time.Now(), JPEG: data}, nil
+}
+
+// Close ...
+func (s *V4L2Source) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	typ := int32(v4l2BufTypeVideoCapture)
+	s.ioctl(vidiocStreamOff, unsafe.Pointer(&typ))
+	for _, b := range s.bufs {
+		syscall.Munmap(b)
+	}
+	s.bufs = nil
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+func (s *V4L2Source) ioctl(req uintptr, arg unsafe.Pointer) error {
+	for {
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, s.f.Fd(), req, uintptr(arg))
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return errno
+		}
+		return nil
+	}
+}
+
+func fourcc(a, b, c, d byte) uint32 {
+	return uint32(a) | uint32(b)<<8 | uint32(c)<<16 | uint32(d)<<24
+}
+
+// ior, iow and iowr are the _IOR, _IOW and _IOWR macros of ioctl
+func ior(t, nr, size uintptr) uintptr {
+	return 2<<30 | size<<16 | t<<8 | nr
+}
+
+func iow(t, nr, size uintptr) uintptr {
+	return 1<<30 | size<<16 | t<<8 | nr
+}
+
+func iowr(t, nr, size uintptr) uintptr {
+	return 3<<30 | size<<16 | t<<8 | nr
+}
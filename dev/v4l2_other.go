@@ -0,0 +1,25 @@
+// +build !linux
+
+package dev
+
+import (
+	"errors"
+)
+
+// V4L2Source is only supported on linux
+type V4L2Source struct{}
+
+// NewV4L2Source ...
+func NewV4L2Source(device string, width, height int) (*V4L2Source, error) {
+	return nil, errors.New("v4l2 is only supported on linux")
+}
+
+// Frame ...
+func (s *V4L2Source) Frame() (*Frame, error) {
+	return nil, errors.New("v4l2 is only supported on linux")
+}
+
+// Close ...
+func (s *V4L2Source) Close() error {
+	return nil
+}
@@ -31,8 +31,10 @@ import (
 	"log"
 
 	"math"
+	"time"
 
 	"github.com/shanghuiyang/rpi-devices/util"
+	"github.com/shanghuiyang/rpi-devices/util/metrics"
 	"github.com/tarm/serial"
 )
 
@@ -67,6 +69,16 @@ func NewZE08CH2O() *ZE08CH2O {
 
 // Get returns ch2o in mg/m3
 func (p *ZE08CH2O) Get() (float64, error) {
+	start := time.Now()
+	ch2o, err := p.get()
+	metrics.ObserveRead("ze08ch2o", start, err)
+	if err == nil {
+		metrics.SetReading("ze08ch2o", "ch2o", ch2o)
+	}
+	return ch2o, err
+}
+
+func (p *ZE08CH2O) get() (float64, error) {
 	for i := 0; i < p.maxRetry; i++ {
 		if err := p.port.Flush(); err != nil {
 			return 0, err
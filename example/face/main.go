@@ -18,11 +18,12 @@ const (
 )
 
 func main() {
-	cam := dev.NewCamera()
-	if cam == nil {
-		log.Print("failed to new a camera")
+	cam, err := dev.OpenCamera("/dev/video0", &dev.CameraConfig{Width: 640, Height: 480})
+	if err != nil {
+		log.Printf("failed to open a camera, error: %v", err)
 		return
 	}
+	defer cam.Close()
 
 	var input string
 	auth := oauth.New(appKey, secretKey, oauth.NewCacheMan())
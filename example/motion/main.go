@@ -0,0 +1,57 @@
+/*
+Motion detects the motions in the frames of a camera, and saves the snapshots of the motions.
+It reads the camera by V4L2 and doesn't need the motion daemon, e.g.
+
+	$ ./motion -src /dev/video0
+	$ ./motion -src ./frames   # a directory of jpeg images
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/shanghuiyang/rpi-devices/dev"
+	"github.com/shanghuiyang/rpi-devices/util/cv/motion"
+)
+
+func main() {
+	src := flag.String("src", "/dev/video0", "the frame source, a video device or a directory of jpeg images")
+	flag.Parse()
+
+	cam, err := dev.OpenCamera(*src, &dev.CameraConfig{Width: 640, Height: 480})
+	if err != nil {
+		log.Fatalf("[motion]failed to open %v, error: %v", *src, err)
+	}
+	defer cam.Close()
+
+	// the detector is canceled on quit rather than by util.WaitQuit, which exits at once,
+	// so the End event of the current motion is still saved before the events are closed.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+		sig := <-c
+		log.Printf("[motion]received signal: %v, will quit", sig)
+		cancel()
+	}()
+
+	d := motion.NewDetector(cam, motion.DefaultConfig)
+	go d.Run(ctx)
+	for e := range d.Events() {
+		log.Printf("[motion]motion %v, boxes: %v", e.Type, e.Boxes)
+		file := fmt.Sprintf("%v-%v.jpg", e.Time.Format("20060102-150405"), e.Type)
+		if err := ioutil.WriteFile(file, e.Snapshot, 0644); err != nil {
+			log.Printf("[motion]failed to save the snapshot, error: %v", err)
+		}
+	}
+	log.Printf("[motion]quit")
+}
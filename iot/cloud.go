@@ -1,28 +1,54 @@
 package iot
 
+import (
+	"log"
+	"time"
+)
+
 // Cloud is the interface of IOT clound
 type Cloud interface {
 	Push(v *Value) error
 }
 
+// BatchCloud is a cloud which can push many values in one request
+type BatchCloud interface {
+	Cloud
+	PushBatch(vs []*Value) error
+}
+
 // Value ...
 type Value struct {
 	Device string
 	Value  interface{}
+	// Time is when the value was read, the time of the cloud receiving it is used if it's zero
+	Time time.Time
+}
+
+// PushBatch pushes the values in one request if the cloud is a BatchCloud,
+// or pushes them one by one.
+func PushBatch(cloud Cloud, vs []*Value) error {
+	if bc, ok := cloud.(BatchCloud); ok {
+		return bc.PushBatch(vs)
+	}
+	for _, v := range vs {
+		if err := cloud.Push(v); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// NewCloud ...
+// NewCloud creates a cloud from a config, e.g. *OneNetConfig, it returns nil if the config isn't registered.
+// use LoadClouds() to create the clouds from a config file.
 func NewCloud(config interface{}) Cloud {
-	var cloud Cloud
-	switch config.(type) {
-	case *WsnConfig:
-		cfg := config.(*WsnConfig)
-		cloud = NewWsnClound(cfg)
-	case *OneNetConfig:
-		cfg := config.(*OneNetConfig)
-		cloud = NewOneNetCloud(cfg)
-	default:
-		cloud = nil
+	r := lookupConfig(config)
+	if r == nil {
+		return nil
+	}
+	cloud, err := r.factory(config, noClouds)
+	if err != nil {
+		log.Printf("[iot]failed to create %v cloud, error: %v", r.kind, err)
+		return nil
 	}
 	return cloud
 }
@@ -0,0 +1,390 @@
+package iot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// CloudsFile is the default config file of the clouds
+	CloudsFile = "clouds.json"
+	// CloudsFileEnv is the environment variable of the config file, it overrides CloudsFile
+	CloudsFileEnv = "IOT_CLOUDS"
+
+	// cloudsEnvPrefix is the prefix of the environment variables overriding the configs
+	cloudsEnvPrefix = "IOT_"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Clouds are the named clouds in a config file in json, e.g.
+//
+//	{
+//		"clouds": {
+//			"onenet": {"type": "onenet", "token": "your_onenet_token", "api": "http://api.heclouds.com/devices/540381180/datapoints"},
+//			"mqtt": {"type": "mqtt", "broker": "tcp://localhost:1883", "qos": 1},
+//			"all": {"type": "multi", "backends": [{"cloud": "onenet", "interval": "1m"}, {"cloud": "mqtt"}]},
+//			"poller": {"type": "poller", "api": "http://192.168.31.10:8080/commands"}
+//		}
+//	}
+//
+// "type" is the registered kind of a cloud, and the other fields are its config.
+// a duration is a string like "10s", or a number in nanoseconds.
+//
+// a field can be overridden by the environment variable IOT_<CLOUD>_<FIELD> in upper case,
+// the chars other than letters and digits in the name of the cloud are replaced with "_",
+// e.g. IOT_ONENET_TOKEN overrides the token of the cloud "onenet".
+// the maps and the slices are in json in the environment variables.
+//
+// the configs are validated on loading, and the clouds are created when they're used the first time,
+// so an app only connects to the clouds it uses.
+type Clouds struct {
+	configs map[string]*cloudConfig
+
+	mu       sync.Mutex
+	clouds   map[string]Cloud
+	building map[string]bool
+	// created are the names of the created clouds in order, they're closed in reverse order
+	created []string
+}
+
+// cloudConfig is the config of a named cloud
+type cloudConfig struct {
+	reg *registration
+	cfg interface{}
+}
+
+// referrer is a config referring to the other clouds by their names
+type referrer interface {
+	references() []string
+}
+
+// LoadClouds loads the clouds from a config file,
+// the file is IOT_CLOUDS or CloudsFile if it's empty.
+func LoadClouds(file string) (*Clouds, error) {
+	if file == "" {
+		file = os.Getenv(CloudsFileEnv)
+	}
+	if file == "" {
+		file = CloudsFile
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	clouds, err := ParseClouds(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %v, error: %v", file, err)
+	}
+	return clouds, nil
+}
+
+// ParseClouds parses the config in json, and overrides it with the environment variables
+func ParseClouds(data []byte) (*Clouds, error) {
+	return parseClouds(data, os.LookupEnv)
+}
+
+func parseClouds(data []byte, lookupEnv func(key string) (string, bool)) (*Clouds, error) {
+	var file struct {
+		Clouds map[string]map[string]json.RawMessage `json:"clouds"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, err
+	}
+	if len(file.Clouds) == 0 {
+		return nil, errors.New("no clouds")
+	}
+
+	c := &Clouds{
+		configs:  map[string]*cloudConfig{},
+		clouds:   map[string]Cloud{},
+		building: map[string]bool{},
+	}
+	for _, name := range sortedKeys(file.Clouds) {
+		cfg, err := parseCloud(name, file.Clouds[name], lookupEnv)
+		if err != nil {
+			return nil, fmt.Errorf("cloud %q: %v", name, err)
+		}
+		c.configs[name] = cfg
+	}
+	for _, name := range c.Names() {
+		r, ok := c.configs[name].cfg.(referrer)
+		if !ok {
+			continue
+		}
+		for _, ref := range r.references() {
+			if _, ok := c.configs[ref]; !ok {
+				return nil, fmt.Errorf("cloud %q: no cloud %q", name, ref)
+			}
+		}
+	}
+	return c, nil
+}
+
+func parseCloud(name string, fields map[string]json.RawMessage, lookupEnv func(key string) (string, bool)) (*cloudConfig, error) {
+	prefix := cloudsEnvPrefix + envName(name) + "_"
+	var kind string
+	if raw, ok := fields["type"]; ok {
+		if err := json.Unmarshal(raw, &kind); err != nil {
+			return nil, fmt.Errorf("invalid type, error: %v", err)
+		}
+		delete(fields, "type")
+	}
+	if s, ok := lookupEnv(prefix + "TYPE"); ok {
+		kind = s
+	}
+	if kind == "" {
+		return nil, errors.New("type is required")
+	}
+	reg := lookupKind(kind)
+	if reg == nil {
+		return nil, fmt.Errorf("unknown type %q, it must be one of %v", kind, strings.Join(Kinds(), ", "))
+	}
+
+	cfg := reg.newConfig()
+	if err := decodeConfig(fields, cfg); err != nil {
+		return nil, err
+	}
+	if err := overrideConfig(prefix, cfg, lookupEnv); err != nil {
+		return nil, err
+	}
+	if v, ok := cfg.(validator); ok {
+		if err := v.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &cloudConfig{reg: reg, cfg: cfg}, nil
+}
+
+// Get returns the cloud of the name, it's created when it's got the first time
+func (c *Clouds) Get(name string) (Cloud, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.get(name)
+}
+
+// MQTT returns the mqtt cloud of the name, e.g. for Home Assistant
+func (c *Clouds) MQTT(name string) (*MQTTCloud, error) {
+	cloud, err := c.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	mqtt, ok := cloud.(*MQTTCloud)
+	if !ok {
+		return nil, fmt.Errorf("cloud %q isn't a mqtt cloud", name)
+	}
+	return mqtt, nil
+}
+
+// Commander returns the cloud of the name which sends the commands, e.g. a mqtt cloud or a command poller
+func (c *Clouds) Commander(name string) (Commander, error) {
+	cloud, err := c.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	cmd, ok := cloud.(Commander)
+	if !ok {
+		return nil, fmt.Errorf("cloud %q doesn't send commands", name)
+	}
+	return cmd, nil
+}
+
+// Names returns the names of the clouds in the config file
+func (c *Clouds) Names() []string {
+	names := make([]string, 0, len(c.configs))
+	for name := range c.configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close closes the created clouds which can be closed, in reverse order of creating them
+func (c *Clouds) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var err error
+	for i := len(c.created) - 1; i >= 0; i-- {
+		closer, ok := c.clouds[c.created[i]].(interface{ Close() error })
+		if !ok {
+			continue
+		}
+		if e := closer.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	c.created = nil
+	c.clouds = map[string]Cloud{}
+	return err
+}
+
+// get creates the cloud if it hasn't been created, c.mu is held
+func (c *Clouds) get(name string) (Cloud, error) {
+	if cloud, ok := c.clouds[name]; ok {
+		return cloud, nil
+	}
+	cc, ok := c.configs[name]
+	if !ok {
+		return nil, fmt.Errorf("no cloud %q", name)
+	}
+	if c.building[name] {
+		return nil, fmt.Errorf("cloud %q refers to itself", name)
+	}
+	c.building[name] = true
+	defer delete(c.building, name)
+
+	cloud, err := cc.reg.factory(cc.cfg, c.get)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cloud %q, error: %v", name, err)
+	}
+	c.clouds[name] = cloud
+	c.created = append(c.created, name)
+	return cloud, nil
+}
+
+// decodeConfig decodes the fields in json to the struct pointed by cfg,
+// it fails on the unknown fields, and a duration can be a string like "10s".
+func decodeConfig(fields map[string]json.RawMessage, cfg interface{}) error {
+	rv := reflect.ValueOf(cfg).Elem()
+	index := jsonFields(rv.Type())
+	for _, key := range sortedKeys(fields) {
+		i, ok := index[key]
+		if !ok {
+			return fmt.Errorf("unknown field %q", key)
+		}
+		if err := decodeField(rv.Field(i), fields[key]); err != nil {
+			return fmt.Errorf("invalid %v, error: %v", key, err)
+		}
+	}
+	return nil
+}
+
+func decodeField(f reflect.Value, raw json.RawMessage) error {
+	if f.Type() == durationType {
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return err
+			}
+			f.SetInt(int64(d))
+			return nil
+		}
+	}
+	return json.Unmarshal(raw, f.Addr().Interface())
+}
+
+// overrideConfig sets the fields of the config from the environment variables <prefix><FIELD>
+func overrideConfig(prefix string, cfg interface{}, lookupEnv func(key string) (string, bool)) error {
+	rv := reflect.ValueOf(cfg).Elem()
+	index := jsonFields(rv.Type())
+	for _, key := range sortedKeys(index) {
+		env := prefix + envName(key)
+		s, ok := lookupEnv(env)
+		if !ok {
+			continue
+		}
+		if err := setField(rv.Field(index[key]), s); err != nil {
+			return fmt.Errorf("invalid %v, error: %v", env, err)
+		}
+	}
+	return nil
+}
+
+// setField sets a field from the string of an environment variable
+func setField(f reflect.Value, s string) error {
+	if f.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		f.SetInt(int64(d))
+		return nil
+	}
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(s, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(v)
+	default:
+		return json.Unmarshal([]byte(s), f.Addr().Interface())
+	}
+	return nil
+}
+
+// jsonFields returns the indexes of the exported fields by their names in json
+func jsonFields(t reflect.Type) map[string]int {
+	index := map[string]int{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		index[name] = i
+	}
+	return index
+}
+
+// envName converts a name to the part of an environment variable, e.g. "pm2.5" to "PM2_5"
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func sortedKeys(m interface{}) []string {
+	keys := reflect.ValueOf(m).MapKeys()
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = k.String()
+	}
+	sort.Strings(names)
+	return names
+}
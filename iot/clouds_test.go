@@ -0,0 +1,114 @@
+package iot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadClouds(t *testing.T) {
+	// the example config file
+	clouds, err := LoadClouds("clouds.json")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"all", "influx", "mqtt", "onenet", "poller", "wsn"}, clouds.Names())
+	assert.Equal(t, 10*time.Second, clouds.configs["influx"].cfg.(*InfluxConfig).FlushInterval)
+	multi := clouds.configs["all"].cfg.(*MultiConfig)
+	assert.Equal(t, time.Minute, multi.Backends[0].Interval)
+
+	_, err = LoadClouds("no-such-file.json")
+	assert.Error(t, err)
+}
+
+func TestParseCloudsEnv(t *testing.T) {
+	data := []byte(`{
+		"clouds": {
+			"onenet": {"type": "onenet", "token": "token", "api": "http://api.heclouds.com/devices/1/datapoints"},
+			"home-mqtt": {"type": "mqtt"}
+		}
+	}`)
+	env := map[string]string{
+		"IOT_ONENET_TOKEN":       "secret",
+		"IOT_HOME_MQTT_QOS":      "1",
+		"IOT_HOME_MQTT_RETAIN":   "true",
+		"IOT_HOME_MQTT_USERNAME": "user",
+	}
+	clouds, err := parseClouds(data, func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", clouds.configs["onenet"].cfg.(*OneNetConfig).Token)
+	mqtt := clouds.configs["home-mqtt"].cfg.(*MQTTConfig)
+	assert.Equal(t, byte(1), mqtt.QoS)
+	assert.True(t, mqtt.Retain)
+	assert.Equal(t, "user", mqtt.Username)
+
+	env["IOT_HOME_MQTT_QOS"] = "one"
+	_, err = parseClouds(data, func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	})
+	assert.EqualError(t, err, `cloud "home-mqtt": invalid IOT_HOME_MQTT_QOS, error: strconv.ParseUint: parsing "one": invalid syntax`)
+}
+
+func TestParseCloudsErrors(t *testing.T) {
+	noEnv := func(key string) (string, bool) { return "", false }
+	tests := []struct {
+		config string
+		err    string
+	}{
+		{`{"clouds": {}}`, `no clouds`},
+		{`{"clouds": {"a": {"token": "x"}}}`, `cloud "a": type is required`},
+		{`{"clouds": {"a": {"type": "aws"}}}`, `cloud "a": unknown type "aws", it must be one of influx, metrics, mqtt, multi, onenet, poller, wsn`},
+		{`{"clouds": {"a": {"type": "poller"}}}`, `cloud "a": api is required`},
+		{`{"clouds": {"a": {"type": "wsn", "tokn": "x"}}}`, `cloud "a": unknown field "tokn"`},
+		{`{"clouds": {"a": {"type": "wsn", "token": 1}}}`, `cloud "a": invalid token, error: json: cannot unmarshal number into Go value of type string`},
+		{`{"clouds": {"a": {"type": "onenet", "token": "x"}}}`, `cloud "a": api is required`},
+		{`{"clouds": {"a": {"type": "mqtt", "broker": "http://localhost"}}}`, `cloud "a": invalid broker "http://localhost", the scheme must be one of tcp, mqtt, tls, ssl, mqtts`},
+		{`{"clouds": {"a": {"type": "influx", "flush_interval": "-1s"}}}`, `cloud "a": flush_interval is negative`},
+		{`{"clouds": {"a": {"type": "multi", "backends": [{"cloud": "b"}]}}}`, `cloud "a": no cloud "b"`},
+	}
+	for _, test := range tests {
+		_, err := parseClouds([]byte(test.config), noEnv)
+		assert.EqualError(t, err, test.err)
+	}
+}
+
+func TestCloudsGet(t *testing.T) {
+	clouds, err := parseClouds([]byte(`{
+		"clouds": {
+			"metrics": {"type": "metrics", "gauge": "test_clouds_value"},
+			"all": {"type": "multi", "backends": [{"cloud": "metrics", "devices": ["temp"]}]},
+			"loop": {"type": "multi", "backends": [{"cloud": "loop"}]}
+		}
+	}`), func(key string) (string, bool) { return "", false })
+	assert.NoError(t, err)
+
+	all, err := clouds.Get("all")
+	assert.NoError(t, err)
+	assert.IsType(t, &MultiCloud{}, all)
+	metrics, err := clouds.Get("metrics")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"metrics", "all"}, clouds.created)
+
+	_, err = clouds.Get("loop")
+	assert.EqualError(t, err, `failed to create cloud "loop", error: cloud "loop" refers to itself`)
+	_, err = clouds.Get("none")
+	assert.EqualError(t, err, `no cloud "none"`)
+	_, err = clouds.MQTT("metrics")
+	assert.EqualError(t, err, `cloud "metrics" isn't a mqtt cloud`)
+	_, err = clouds.Commander("metrics")
+	assert.EqualError(t, err, `cloud "metrics" doesn't send commands`)
+
+	assert.NoError(t, all.Push(&Value{Device: "temp", Value: 22.5}))
+	assert.NoError(t, clouds.Close())
+	assert.Equal(t, 22.5, metrics.(*MetricsCloud).values.Value("temp"))
+}
+
+func TestNewCloud(t *testing.T) {
+	assert.IsType(t, &OneNetCloud{}, NewCloud(&OneNetConfig{Token: "token", API: "http://api.heclouds.com/devices/1/datapoints"}))
+	assert.IsType(t, &WsnCloud{}, NewCloud(&WsnConfig{Token: "token"}))
+	assert.Nil(t, NewCloud(&MultiConfig{Backends: []*MultiBackend{{Name: "onenet"}}}))
+	assert.Nil(t, NewCloud("onenet"))
+}
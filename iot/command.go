@@ -0,0 +1,166 @@
+package iot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	logTagCommand = "command"
+
+	// CommandAccepted is the status of the acknowledgement of a command, it's reported before handling the command
+	CommandAccepted = "accepted"
+	// CommandDone is the status of a command handled successfully
+	CommandDone = "done"
+	// CommandFailed is the status of a command failed
+	CommandFailed = "failed"
+)
+
+// ErrUnknownCommand is returned by a CommandHandler if it doesn't know the command
+var ErrUnknownCommand = errors.New("unknown command")
+
+// Command is a command from a cloud to a device, e.g.
+//
+//	{"id": "1", "device": "air-cleaner", "name": "on"}
+//	{"id": "2", "device": "servo", "name": "roll", "args": {"angle": 45}}
+type Command struct {
+	// ID is the id of the command in the cloud, the acknowledgement and the result are reported with it
+	ID     string `json:"id"`
+	Device string `json:"device"`
+	// Name is what to do, e.g. "on" and "off"
+	Name string `json:"name"`
+	// Args are the arguments in json, use Decode() to get them
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+// CommandResult is the acknowledgement or the result of a command reported to the cloud
+type CommandResult struct {
+	ID     string `json:"id"`
+	Device string `json:"device"`
+	Name   string `json:"name"`
+	// Status is one of CommandAccepted, CommandDone and CommandFailed
+	Status string      `json:"status"`
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	// Time is the unix time in seconds
+	Time int64 `json:"time"`
+}
+
+// CommandHandler handles a command of a device, the result and the error are reported to the cloud.
+// the result is encoded in json, and it can be nil.
+type CommandHandler func(cmd *Command) (interface{}, error)
+
+// Commander is a cloud which sends the commands to the devices, e.g. MQTTCloud and CommandPoller
+type Commander interface {
+	// SubscribeCommands calls the handler on the commands of the device,
+	// it replaces the handler subscribed before.
+	SubscribeCommands(device string, handler CommandHandler) error
+}
+
+// Decode decodes the arguments to v, it's like json.Unmarshal()
+func (c *Command) Decode(v interface{}) error {
+	if len(c.Args) == 0 {
+		return errors.New("no arguments")
+	}
+	return json.Unmarshal(c.Args, v)
+}
+
+// parseCommand parses a command in json, or a command name in plain text like "on"
+func parseCommand(payload []byte) (*Command, error) {
+	payload = bytes.TrimSpace(payload)
+	if len(payload) == 0 {
+		return nil, errors.New("empty command")
+	}
+	if payload[0] != '{' {
+		return &Command{Name: string(payload)}, nil
+	}
+	var cmd Command
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		return nil, err
+	}
+	if cmd.Name == "" {
+		return nil, errors.New("name of the command is required")
+	}
+	return &cmd, nil
+}
+
+// commandHandlers are the handlers of the devices
+type commandHandlers struct {
+	mu       sync.Mutex
+	handlers map[string]CommandHandler
+}
+
+// set sets the handler of the device, it returns false if the device had a handler
+func (h *commandHandlers) set(device string, handler CommandHandler) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.handlers == nil {
+		h.handlers = map[string]CommandHandler{}
+	}
+	_, ok := h.handlers[device]
+	h.handlers[device] = handler
+	return !ok
+}
+
+func (h *commandHandlers) get(device string) CommandHandler {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.handlers[device]
+}
+
+// handle acknowledges the command, calls its handler, and reports the result with report
+func (h *commandHandlers) handle(cmd *Command, report func(r *CommandResult) error) {
+	handler := h.get(cmd.Device)
+	if handler == nil {
+		log.Printf("[%v]no handler of %v, rejected command %v", logTagCommand, cmd.Device, cmd.Name)
+		err := fmt.Errorf("no handler of device %q", cmd.Device)
+		if err := report(newCommandResult(cmd, CommandFailed, nil, err)); err != nil {
+			log.Printf("[%v]failed to report the result of command %v of %v, error: %v", logTagCommand, cmd.Name, cmd.Device, err)
+		}
+		return
+	}
+	log.Printf("[%v]received command %v of %v", logTagCommand, cmd.Name, cmd.Device)
+	if err := report(newCommandResult(cmd, CommandAccepted, nil, nil)); err != nil {
+		log.Printf("[%v]failed to acknowledge command %v of %v, error: %v", logTagCommand, cmd.Name, cmd.Device, err)
+	}
+
+	result, err := callCommandHandler(handler, cmd)
+	status := CommandDone
+	if err != nil {
+		status = CommandFailed
+		log.Printf("[%v]command %v of %v failed, error: %v", logTagCommand, cmd.Name, cmd.Device, err)
+	}
+	if err := report(newCommandResult(cmd, status, result, err)); err != nil {
+		log.Printf("[%v]failed to report the result of command %v of %v, error: %v", logTagCommand, cmd.Name, cmd.Device, err)
+	}
+}
+
+// callCommandHandler calls the handler, a panic of the handler is returned as an error
+func callCommandHandler(handler CommandHandler, cmd *Command) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(cmd)
+}
+
+func newCommandResult(cmd *Command, status string, result interface{}, err error) *CommandResult {
+	r := &CommandResult{
+		ID:     cmd.ID,
+		Device: cmd.Device,
+		Name:   cmd.Name,
+		Status: status,
+		Result: result,
+		Time:   time.Now().Unix(),
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
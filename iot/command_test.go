@@ -0,0 +1,167 @@
+package iot
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// resultsOf returns the results of the commands published to the topic
+func (b *fakeBroker) resultsOf(topic string) []*CommandResult {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var results []*CommandResult
+	for _, m := range b.messages {
+		if m.topic != topic {
+			continue
+		}
+		var r CommandResult
+		if err := json.Unmarshal(m.payload, &r); err == nil {
+			results = append(results, &r)
+		}
+	}
+	return results
+}
+
+func TestParseCommand(t *testing.T) {
+	cmd, err := parseCommand([]byte(" on\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "on", cmd.Name)
+
+	cmd, err = parseCommand([]byte(`{"id": "1", "device": "servo", "name": "roll", "args": {"angle": 45}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "roll", cmd.Name)
+	var args struct {
+		Angle int `json:"angle"`
+	}
+	assert.NoError(t, cmd.Decode(&args))
+	assert.Equal(t, 45, args.Angle)
+
+	_, err = parseCommand([]byte(`{"device": "servo"}`))
+	assert.Error(t, err)
+	_, err = parseCommand(nil)
+	assert.Error(t, err)
+}
+
+func TestMQTTCommands(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	broker := newFakeBroker(ln, 0)
+
+	cloud := NewMQTTCloud(&MQTTConfig{Broker: "tcp://" + ln.Addr().String(), QoS: 1})
+	defer cloud.Close()
+	waitFor(t, cloud.Connected)
+
+	var mu sync.Mutex
+	state := false
+	err = cloud.SubscribeCommands("fan", func(cmd *Command) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch cmd.Name {
+		case "on":
+			state = true
+		case "off":
+			state = false
+		case "panic":
+			panic("boom")
+		default:
+			return nil, ErrUnknownCommand
+		}
+		return map[string]bool{"on": state}, nil
+	})
+	assert.NoError(t, err)
+
+	host, _ := os.Hostname()
+	topic := "rpi/" + host + "/fan/cmd"
+	broker.publish(topic, "on")
+	waitFor(t, func() bool { return len(broker.resultsOf(topic+"/result")) == 2 })
+	results := broker.resultsOf(topic + "/result")
+	assert.Equal(t, CommandAccepted, results[0].Status)
+	assert.Equal(t, CommandDone, results[1].Status)
+	assert.Equal(t, "fan", results[1].Device)
+	assert.Equal(t, map[string]interface{}{"on": true}, results[1].Result)
+
+	broker.publish(topic, `{"id": "2", "device": "light", "name": "jump"}`)
+	broker.publish(topic, "panic")
+	waitFor(t, func() bool { return len(broker.resultsOf(topic+"/result")) == 6 })
+	results = broker.resultsOf(topic + "/result")
+	assert.Equal(t, "2", results[3].ID)
+	assert.Equal(t, "fan", results[3].Device)
+	assert.Equal(t, CommandFailed, results[3].Status)
+	assert.Equal(t, "unknown command", results[3].Error)
+	assert.Equal(t, "panic: boom", results[5].Error)
+}
+
+func TestCommandPoller(t *testing.T) {
+	var mu sync.Mutex
+	var cmds []*Command
+	resps := map[string][]*CommandResult{}
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/commands":
+			data, _ := json.Marshal(cmds)
+			cmds = nil
+			w.Write(data)
+		case r.Method == "POST":
+			var result CommandResult
+			body, _ := ioutil.ReadAll(r.Body)
+			assert.NoError(t, json.Unmarshal(body, &result))
+			resps[r.URL.Path] = append(resps[r.URL.Path], &result)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer svr.Close()
+	numResps := func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(resps[path])
+	}
+
+	poller := NewCommandPoller(&CommandPollerConfig{
+		API:      svr.URL + "/commands",
+		Token:    "token",
+		Interval: 10 * time.Millisecond,
+	})
+	defer poller.Close()
+	assert.NoError(t, poller.SubscribeCommands("relay", func(cmd *Command) (interface{}, error) {
+		if cmd.Name != "on" {
+			return nil, errors.New("relay is broken")
+		}
+		return nil, nil
+	}))
+
+	mu.Lock()
+	cmds = []*Command{
+		{ID: "a", Device: "relay", Name: "on"},
+		{ID: "b", Device: "relay", Name: "off"},
+		{ID: "c", Name: "on"},
+		{Device: "relay", Name: "on"},
+	}
+	mu.Unlock()
+	waitFor(t, func() bool {
+		return numResps("/commands/a/resp") == 2 && numResps("/commands/b/resp") == 2 && numResps("/commands/c/resp") == 1
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, CommandAccepted, resps["/commands/a/resp"][0].Status)
+	assert.Equal(t, CommandDone, resps["/commands/a/resp"][1].Status)
+	assert.Equal(t, "a", resps["/commands/a/resp"][1].ID)
+	assert.Equal(t, "relay is broken", resps["/commands/b/resp"][1].Error)
+	assert.Equal(t, "device of the command is required", resps["/commands/c/resp"][0].Error)
+	assert.Len(t, resps, 3)
+}
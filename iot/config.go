@@ -1,8 +1,14 @@
 package iot
 
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
 const (
-	// WsnToken is the token of wsn iot cloud
-	WsnToken = "your_wsn_token"
 	// WsnNumericalAPI is the api of wsn iot cloud for pushing numerical datapoints
 	WsnNumericalAPI = "http://www.wsncloud.com/api/data/v1/numerical/insert"
 	// WsnGenericAPI is the api of wsn iot cloud for pushing generic datapoints
@@ -10,10 +16,19 @@ const (
 )
 
 const (
-	// OneNetToken is the token of OneNet iot cloud
-	OneNetToken = "your_onenet_token"
-	// OneNetAPI is the api of OneNet iot cloud for pushing datapoints
-	OneNetAPI = "http://api.heclouds.com/devices/540381180/datapoints"
+	// MQTTBroker is the address of the mqtt broker, use tls:// or ssl:// for tls
+	MQTTBroker = "tcp://localhost:1883"
+	// MQTTTopic is the template of the topics which the values are published to
+	MQTTTopic = "rpi/{host}/{device}"
+	// MQTTStatusTopic is the template of the topic of the online/offline status
+	MQTTStatusTopic = "rpi/{host}/{client}/status"
+)
+
+const (
+	// InfluxURL is the address of influxdb
+	InfluxURL = "http://localhost:8086"
+	// InfluxDatabase is the database of influxdb 1.x
+	InfluxDatabase = "rpi"
 )
 
 // WsnConfig ...
@@ -27,3 +42,162 @@ type OneNetConfig struct {
 	Token string `json:"token"`
 	API   string `json:"api"`
 }
+
+// MQTTConfig ...
+type MQTTConfig struct {
+	// Broker is the address of the broker, e.g. tcp://localhost:1883 or tls://broker:8883
+	Broker   string `json:"broker"`
+	ClientID string `json:"client_id"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Topic is the template of the topics, {host}, {client} and {device} are replaced
+	Topic string `json:"topic"`
+	// QoS is the qos of publishing, 0 or 1
+	QoS byte `json:"qos"`
+	// Retain makes the broker keep the last value of a topic as its state
+	Retain bool `json:"retain"`
+	// JSON publishes the values like {"value": 22.5, "time": 1609459200}, or only the values
+	JSON bool `json:"json"`
+	// StatusTopic is the template of the topic of the retained "online" status,
+	// and "offline" is published as the last will. no status is published if it's empty.
+	StatusTopic string `json:"status_topic"`
+	// KeepAlive is the keep alive in seconds
+	KeepAlive int `json:"keep_alive"`
+	// CAFile, CertFile and KeyFile are the files in pem for tls
+	CAFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// MetricsConfig ...
+type MetricsConfig struct {
+	// Gauge is the name of the gauge of the values, the default is iot_value
+	Gauge string `json:"gauge"`
+}
+
+// InfluxConfig ...
+type InfluxConfig struct {
+	// URL is the address of influxdb, e.g. http://localhost:8086
+	URL string `json:"url"`
+	// Database, RetentionPolicy, Username and Password are for the /write api of influxdb 1.x
+	Database        string `json:"database"`
+	RetentionPolicy string `json:"retention_policy"`
+	Username        string `json:"username"`
+	Password        string `json:"password"`
+	// Org, Bucket and Token are for the /api/v2/write api of influxdb 2.x, it's used if Bucket isn't empty
+	Org    string `json:"org"`
+	Bucket string `json:"bucket"`
+	Token  string `json:"token"`
+	// Measurement is the measurement of the points, the devices and the host are the tags
+	Measurement string `json:"measurement"`
+	// Tags are the extra tags of the points
+	Tags map[string]string `json:"tags"`
+	// Precision is the precision of the timestamps, one of ns, us, ms and s
+	Precision string `json:"precision"`
+	// BatchSize and FlushInterval control when the buffered points are written
+	BatchSize     int           `json:"batch_size"`
+	FlushInterval time.Duration `json:"flush_interval"`
+}
+
+// CommandPollerConfig is the config of a CommandPoller
+type CommandPollerConfig struct {
+	// API is the url of the commands, e.g. http://192.168.31.10:8080/commands
+	API string `json:"api"`
+	// Token is sent in the header "Authorization: Bearer <token>" if it isn't empty
+	Token string `json:"token"`
+	// Interval is the interval of polling the commands, the default is 5s
+	Interval time.Duration `json:"interval"`
+}
+
+// Validate ...
+func (cfg *WsnConfig) Validate() error {
+	if cfg.Token == "" {
+		return errors.New("token is required")
+	}
+	if cfg.API != "" {
+		return validateURL("api", cfg.API, "http", "https")
+	}
+	return nil
+}
+
+// Validate ...
+func (cfg *OneNetConfig) Validate() error {
+	if cfg.Token == "" {
+		return errors.New("token is required")
+	}
+	// the api contains the id of the device, so there is no default one
+	if cfg.API == "" {
+		return errors.New("api is required")
+	}
+	return validateURL("api", cfg.API, "http", "https")
+}
+
+// Validate ...
+func (cfg *MQTTConfig) Validate() error {
+	if cfg.Broker != "" {
+		if err := validateURL("broker", cfg.Broker, "tcp", "mqtt", "tls", "ssl", "mqtts"); err != nil {
+			return err
+		}
+	}
+	if cfg.QoS > 1 {
+		return fmt.Errorf("qos %v isn't supported, it must be 0 or 1", cfg.QoS)
+	}
+	if cfg.KeepAlive < 0 {
+		return errors.New("keep_alive is negative")
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return errors.New("cert_file and key_file must be set together")
+	}
+	return nil
+}
+
+// Validate ...
+func (cfg *InfluxConfig) Validate() error {
+	if cfg.URL != "" {
+		if err := validateURL("url", cfg.URL, "http", "https"); err != nil {
+			return err
+		}
+	}
+	if cfg.Bucket != "" && cfg.Org == "" {
+		return errors.New("org is required for the bucket")
+	}
+	if _, ok := influxPrecisions[cfg.Precision]; cfg.Precision != "" && !ok {
+		return fmt.Errorf("invalid precision %q, it must be one of ns, us, ms and s", cfg.Precision)
+	}
+	if cfg.BatchSize < 0 {
+		return errors.New("batch_size is negative")
+	}
+	if cfg.FlushInterval < 0 {
+		return errors.New("flush_interval is negative")
+	}
+	return nil
+}
+
+// Validate ...
+func (cfg *CommandPollerConfig) Validate() error {
+	if cfg.API == "" {
+		return errors.New("api is required")
+	}
+	if err := validateURL("api", cfg.API, "http", "https"); err != nil {
+		return err
+	}
+	if cfg.Interval < 0 {
+		return errors.New("interval is negative")
+	}
+	return nil
+}
+
+// validateURL checks if the url is valid and its scheme is one of the schemes
+func validateURL(field, rawurl string, schemes ...string) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return fmt.Errorf("invalid %v, error: %v", field, err)
+	}
+	for _, s := range schemes {
+		if u.Scheme == s && u.Host != "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid %v %q, the scheme must be one of %v", field, rawurl, strings.Join(schemes, ", "))
+}
@@ -0,0 +1,66 @@
+package iot
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+const (
+	// maxErrorBody is the max size of the response body read for an error
+	maxErrorBody = 4 << 10
+)
+
+// APIError is an error returned by the api of a cloud,
+// either a http status other than 2xx, or an error code in the response.
+type APIError struct {
+	// Status is the http status code
+	Status int
+	// Code is the error code of the cloud api, 0 if it isn't provided
+	Code    int
+	Message string
+}
+
+// Error ...
+func (e *APIError) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("api error: status %v, code %v, %v", e.Status, e.Code, e.Message)
+	}
+	return fmt.Sprintf("api error: status %v, %v", e.Status, e.Message)
+}
+
+// Temporary returns true if the request may succeed after retrying, e.g. 5xx and 429.
+// the other errors like an invalid token or a bad request fail again and again.
+func (e *APIError) Temporary() bool {
+	return e.Status >= 500 || e.Status == http.StatusTooManyRequests
+}
+
+// permanentError is an error which fails again and again on retrying, e.g. a value can't be encoded
+type permanentError struct {
+	error
+}
+
+// isTemporary checks if a push should be retried, only an APIError or a permanentError can be permanent,
+// the other errors like timeout or no network are temporary.
+func isTemporary(err error) bool {
+	switch e := err.(type) {
+	case *APIError:
+		return e.Temporary()
+	case *permanentError:
+		return false
+	}
+	return true
+}
+
+// checkStatus returns an APIError if the status isn't 2xx
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	return &APIError{
+		Status:  resp.StatusCode,
+		Message: string(body),
+	}
+}
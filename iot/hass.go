@@ -0,0 +1,254 @@
+package iot
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+)
+
+const (
+	logTagHass = "hass"
+
+	// HADiscoveryPrefix is the default discovery prefix of Home Assistant
+	HADiscoveryPrefix = "homeassistant"
+
+	haOn  = "ON"
+	haOff = "OFF"
+)
+
+// haInvalidChars are the chars which aren't allowed in the node id and object id of a discovery topic
+var haInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// HASensor is a sensor of Home Assistant
+type HASensor struct {
+	// ID is the device of the values, e.g. "pm2.5", the values pushed with it are the states of the sensor
+	ID   string
+	Name string
+	// Unit is the unit of measurement, e.g. "°C"
+	Unit string
+	// DeviceClass is the device class of Home Assistant, e.g. "temperature" and "pm25"
+	DeviceClass string
+	// Icon is an icon of material design, e.g. "mdi:fan"
+	Icon string
+}
+
+// HASwitch is a switch of Home Assistant
+type HASwitch struct {
+	// ID is the device of the states of the switch, e.g. "fan"
+	ID   string
+	Name string
+	Icon string
+	// On and Off are called on the commands from Home Assistant,
+	// the app reports the state with SetState() when it turns on/off the switch.
+	On  func()
+	Off func()
+}
+
+// HomeAssistant publishes the sensors and switches of an app to Home Assistant using mqtt discovery,
+// so they appear in Home Assistant automatically.
+// see https://www.home-assistant.io/docs/mqtt/discovery/
+//
+// the states are published to the topics of the devices in the mqtt cloud,
+// and the commands of a switch are subscribed from the topic of its device plus "/set".
+// the configs and the last states are published again when it reconnects or Home Assistant restarts.
+type HomeAssistant struct {
+	mqtt   *MQTTCloud
+	prefix string
+	node   string
+	name   string
+
+	mu       sync.Mutex
+	configs  []*haConfig
+	switches map[string]*HASwitch
+	states   map[string][]byte
+	order    []string
+}
+
+// haConfig is a discovery config
+type haConfig struct {
+	topic   string
+	payload []byte
+}
+
+// NewHomeAssistant creates a HomeAssistant on a mqtt cloud, name is the name of the device in Home Assistant
+func NewHomeAssistant(mqtt *MQTTCloud, name string) *HomeAssistant {
+	h := &HomeAssistant{
+		mqtt:     mqtt,
+		prefix:   HADiscoveryPrefix,
+		node:     haInvalidChars.ReplaceAllString(mqtt.ClientID(), "_"),
+		name:     name,
+		switches: map[string]*HASwitch{},
+		states:   map[string][]byte{},
+	}
+	mqtt.OnConnect(h.republish)
+	// Home Assistant publishes "online" to the status topic when it starts
+	err := mqtt.Subscribe(h.prefix+"/status", func(topic string, payload []byte) {
+		if string(payload) == mqttOnline {
+			log.Printf("[%v]home assistant is online", logTagHass)
+			h.republish()
+		}
+	})
+	if err != nil {
+		log.Printf("[%v]failed to subscribe the status of home assistant, error: %v", logTagHass, err)
+	}
+	return h
+}
+
+// AddSensor ...
+func (h *HomeAssistant) AddSensor(s *HASensor) {
+	cfg := h.entity(s.ID, s.Name, s.Icon)
+	cfg["state_topic"] = h.mqtt.Topic(s.ID)
+	if s.Unit != "" {
+		cfg["unit_of_measurement"] = s.Unit
+	}
+	if s.DeviceClass != "" {
+		cfg["device_class"] = s.DeviceClass
+	}
+	if h.mqtt.cfg.JSON {
+		cfg["value_template"] = "{{ value_json.value }}"
+	}
+	h.addConfig("sensor", s.ID, cfg)
+}
+
+// AddSwitch adds a switch, and subscribes its commands
+func (h *HomeAssistant) AddSwitch(s *HASwitch) {
+	cmdTopic := h.mqtt.Topic(s.ID) + "/set"
+	cfg := h.entity(s.ID, s.Name, s.Icon)
+	cfg["state_topic"] = h.mqtt.Topic(s.ID)
+	cfg["command_topic"] = cmdTopic
+	cfg["payload_on"] = haOn
+	cfg["payload_off"] = haOff
+
+	h.mu.Lock()
+	h.switches[s.ID] = s
+	h.mu.Unlock()
+
+	err := h.mqtt.Subscribe(cmdTopic, func(topic string, payload []byte) {
+		switch string(payload) {
+		case haOn:
+			log.Printf("[%v]turn on %v", logTagHass, s.ID)
+			s.On()
+		case haOff:
+			log.Printf("[%v]turn off %v", logTagHass, s.ID)
+			s.Off()
+		default:
+			log.Printf("[%v]invalid command %q of %v", logTagHass, payload, s.ID)
+		}
+	})
+	if err != nil {
+		log.Printf("[%v]failed to subscribe the commands of %v, error: %v", logTagHass, s.ID, err)
+	}
+	h.addConfig("switch", s.ID, cfg)
+}
+
+// SetState reports the state of a switch
+func (h *HomeAssistant) SetState(id string, on bool) error {
+	return h.Push(&Value{Device: id, Value: on})
+}
+
+// Push publishes the state of a sensor or a switch, the value of a switch is a bool or a number, 0 is off.
+// the state is kept and published after connecting if it's disconnected now.
+func (h *HomeAssistant) Push(v *Value) error {
+	h.mu.Lock()
+	_, isSwitch := h.switches[v.Device]
+	h.mu.Unlock()
+
+	var payload []byte
+	if isSwitch {
+		payload = []byte(haOff)
+		if isOn(v.Value) {
+			payload = []byte(haOn)
+		}
+	} else {
+		var err error
+		payload, err = h.mqtt.payload(v)
+		if err != nil {
+			return &permanentError{fmt.Errorf("failed to encode the value of %v, error: %v", v.Device, err)}
+		}
+	}
+
+	h.mu.Lock()
+	if _, ok := h.states[v.Device]; !ok {
+		h.order = append(h.order, v.Device)
+	}
+	h.states[v.Device] = payload
+	h.mu.Unlock()
+
+	if !h.mqtt.Connected() {
+		return nil
+	}
+	return h.mqtt.Publish(h.mqtt.Topic(v.Device), payload, true)
+}
+
+// entity returns the common config of an entity
+func (h *HomeAssistant) entity(id, name, icon string) map[string]interface{} {
+	cfg := map[string]interface{}{
+		"name":      name,
+		"unique_id": h.node + "_" + haInvalidChars.ReplaceAllString(id, "_"),
+		"device": map[string]interface{}{
+			"identifiers":  []string{h.node},
+			"name":         h.name,
+			"manufacturer": "rpi-devices",
+			"model":        "Raspberry Pi",
+		},
+	}
+	if icon != "" {
+		cfg["icon"] = icon
+	}
+	if status := h.mqtt.StatusTopic(); status != "" {
+		cfg["availability_topic"] = status
+	}
+	return cfg
+}
+
+// addConfig adds a discovery config, and publishes it if it's connected
+func (h *HomeAssistant) addConfig(component, id string, cfg map[string]interface{}) {
+	payload, err := json.Marshal(cfg)
+	if err != nil {
+		log.Printf("[%v]failed to marshal the config of %v, error: %v", logTagHass, id, err)
+		return
+	}
+	c := &haConfig{
+		topic:   fmt.Sprintf("%v/%v/%v/%v/config", h.prefix, component, h.node, haInvalidChars.ReplaceAllString(id, "_")),
+		payload: payload,
+	}
+	h.mu.Lock()
+	h.configs = append(h.configs, c)
+	h.mu.Unlock()
+
+	if !h.mqtt.Connected() {
+		return
+	}
+	if err := h.mqtt.Publish(c.topic, c.payload, true); err != nil {
+		log.Printf("[%v]failed to publish the config of %v, error: %v", logTagHass, id, err)
+	}
+}
+
+// republish publishes all the configs and the last states
+func (h *HomeAssistant) republish() {
+	h.mu.Lock()
+	msgs := make([]*haConfig, len(h.configs), len(h.configs)+len(h.order))
+	copy(msgs, h.configs)
+	for _, id := range h.order {
+		msgs = append(msgs, &haConfig{topic: h.mqtt.Topic(id), payload: h.states[id]})
+	}
+	h.mu.Unlock()
+
+	for _, c := range msgs {
+		if err := h.mqtt.Publish(c.topic, c.payload, true); err != nil {
+			log.Printf("[%v]failed to publish %v, error: %v", logTagHass, c.topic, err)
+			return
+		}
+	}
+}
+
+// isOn checks if the value of a switch is on
+func isOn(v interface{}) bool {
+	if s, ok := v.(string); ok {
+		return s == haOn
+	}
+	f, _ := toFloat(v)
+	return f != 0
+}
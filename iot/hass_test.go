@@ -0,0 +1,81 @@
+package iot
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHomeAssistant(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	broker := newFakeBroker(ln, 0)
+
+	cloud := NewMQTTCloud(&MQTTConfig{
+		Broker:      "tcp://" + ln.Addr().String(),
+		ClientID:    "autoair",
+		QoS:         1,
+		StatusTopic: MQTTStatusTopic,
+	})
+	defer cloud.Close()
+	waitFor(t, cloud.Connected)
+
+	var mu sync.Mutex
+	state := false
+	ha := NewHomeAssistant(cloud, "Auto-Air")
+	ha.AddSensor(&HASensor{ID: "pm2.5", Name: "PM2.5", Unit: "µg/m³", DeviceClass: "pm25"})
+	ha.AddSwitch(&HASwitch{
+		ID:   "air-cleaner",
+		Name: "Air Cleaner",
+		On: func() {
+			mu.Lock()
+			state = true
+			mu.Unlock()
+			ha.SetState("air-cleaner", true)
+		},
+		Off: func() {
+			mu.Lock()
+			state = false
+			mu.Unlock()
+			ha.SetState("air-cleaner", false)
+		},
+	})
+
+	host, _ := os.Hostname()
+	var cfg map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(broker.retainedOf("homeassistant/sensor/autoair/pm2_5/config")), &cfg))
+	assert.Equal(t, "PM2.5", cfg["name"])
+	assert.Equal(t, "autoair_pm2_5", cfg["unique_id"])
+	assert.Equal(t, "rpi/"+host+"/pm2.5", cfg["state_topic"])
+	assert.Equal(t, "rpi/"+host+"/autoair/status", cfg["availability_topic"])
+	assert.Equal(t, "pm25", cfg["device_class"])
+
+	assert.NoError(t, json.Unmarshal([]byte(broker.retainedOf("homeassistant/switch/autoair/air-cleaner/config")), &cfg))
+	cmdTopic := "rpi/" + host + "/air-cleaner/set"
+	assert.Equal(t, cmdTopic, cfg["command_topic"])
+
+	assert.NoError(t, ha.Push(&Value{Device: "pm2.5", Value: 35}))
+	assert.Equal(t, "35", broker.retainedOf("rpi/"+host+"/pm2.5"))
+
+	// the command calls the on/off function of the app
+	broker.publish(cmdTopic, "ON")
+	waitFor(t, func() bool { return broker.retainedOf("rpi/"+host+"/air-cleaner") == "ON" })
+	mu.Lock()
+	assert.True(t, state)
+	mu.Unlock()
+	assert.NoError(t, ha.Push(&Value{Device: "air-cleaner", Value: 0}))
+	assert.Equal(t, "OFF", broker.retainedOf("rpi/"+host+"/air-cleaner"))
+
+	// publish the configs and states again when home assistant restarts
+	n := broker.numMessages()
+	broker.publish("homeassistant/status", "online")
+	waitFor(t, func() bool { return broker.numMessages() == n+5 })
+	m := broker.lastMessage()
+	assert.Equal(t, "rpi/"+host+"/air-cleaner", m.topic)
+	assert.Equal(t, "OFF", string(m.payload))
+}
@@ -0,0 +1,336 @@
+package iot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shanghuiyang/rpi-devices/util"
+)
+
+const (
+	logTagInflux = "influx"
+
+	defaultInfluxMeasurement   = "rpi"
+	defaultInfluxPrecision     = "s"
+	defaultInfluxBatchSize     = 100
+	defaultInfluxFlushInterval = 10 * time.Second
+	// influxMaxBuffered is the max number of the buffered points in the batches
+	influxMaxBuffered = 100
+	influxTimeout     = 10 * time.Second
+)
+
+// influxPrecisions are the units of the precisions, and the precisions of the v1 api
+var influxPrecisions = map[string]struct {
+	unit time.Duration
+	v1   string
+}{
+	"ns": {time.Nanosecond, "ns"},
+	"us": {time.Microsecond, "u"},
+	"ms": {time.Millisecond, "ms"},
+	"s":  {time.Second, "s"},
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	influxStringEscaper      = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+)
+
+// InfluxCloud is the implement of Cloud which writes the values to influxdb in line protocol,
+// both the /write api of influxdb 1.x and the /api/v2/write api of influxdb 2.x are supported.
+// a value is written as a point of the measurement with the tags of the device and the host,
+// and a field which has the same type for all the devices, see influxFields(),
+// since influxdb rejects a field whose type is different from the type written before.
+//
+// Push() buffers the values, they're written in a batch when the batch is full or every flush interval.
+// PushBatch() writes the values at once, so a ReliableCloud wrapping it retries the failed writes.
+// Close() must be called to write the buffered values.
+type InfluxCloud struct {
+	cfg    InfluxConfig
+	url    string
+	tags   string
+	unit   time.Duration
+	client *http.Client
+
+	mu  sync.Mutex
+	buf []*Value
+
+	wake      chan struct{}
+	quit      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewInfluxCloud ...
+func NewInfluxCloud(cfg *InfluxConfig) *InfluxCloud {
+	c := &InfluxCloud{
+		cfg:    *cfg,
+		client: &http.Client{Timeout: influxTimeout},
+		wake:   make(chan struct{}, 1),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if c.cfg.URL == "" {
+		c.cfg.URL = InfluxURL
+	}
+	if c.cfg.Measurement == "" {
+		c.cfg.Measurement = defaultInfluxMeasurement
+	}
+	if _, ok := influxPrecisions[c.cfg.Precision]; !ok {
+		if c.cfg.Precision != "" {
+			log.Printf("[%v]invalid precision %v, use %v", logTagInflux, c.cfg.Precision, defaultInfluxPrecision)
+		}
+		c.cfg.Precision = defaultInfluxPrecision
+	}
+	if c.cfg.BatchSize <= 0 {
+		c.cfg.BatchSize = defaultInfluxBatchSize
+	}
+	if c.cfg.FlushInterval <= 0 {
+		c.cfg.FlushInterval = defaultInfluxFlushInterval
+	}
+	c.unit = influxPrecisions[c.cfg.Precision].unit
+	c.url = c.writeURL()
+
+	tags := map[string]string{}
+	if host, err := os.Hostname(); err == nil {
+		tags["host"] = host
+	}
+	for k, v := range c.cfg.Tags {
+		tags[k] = v
+	}
+	c.tags = formatInfluxTags(tags)
+
+	go c.run()
+	return c
+}
+
+// Push buffers a value, it's written in a batch later
+func (c *InfluxCloud) Push(v *Value) error {
+	if v.Time.IsZero() {
+		stamped := *v
+		stamped.Time = time.Now()
+		v = &stamped
+	}
+	c.mu.Lock()
+	c.buf = append(c.buf, v)
+	full := len(c.buf) >= c.cfg.BatchSize
+	c.mu.Unlock()
+	if full {
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// PushBatch writes the values in one request
+func (c *InfluxCloud) PushBatch(vs []*Value) error {
+	if len(vs) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	now := time.Now()
+	for _, v := range vs {
+		line, err := c.line(v, now)
+		if err != nil {
+			// a bad value won't be written whenever it's retried, skip it
+			log.Printf("[%v]skip the value of %v, error: %v", logTagInflux, v.Device, err)
+			continue
+		}
+		buf.WriteString(line)
+		buf.WriteByte('\n')
+	}
+	if buf.Len() == 0 {
+		return &permanentError{errors.New("no valid values")}
+	}
+	return c.write(buf.Bytes())
+}
+
+// Flush writes the buffered values, the values are kept in the buffer if it fails and can be retried
+func (c *InfluxCloud) Flush() error {
+	c.mu.Lock()
+	vs := c.buf
+	c.buf = nil
+	c.mu.Unlock()
+
+	for len(vs) > 0 {
+		n := c.cfg.BatchSize
+		if n > len(vs) {
+			n = len(vs)
+		}
+		err := c.PushBatch(vs[:n])
+		if err != nil && isTemporary(err) {
+			c.requeue(vs)
+			return err
+		}
+		if err != nil {
+			log.Printf("[%v]influxdb rejected %v values, dropped them, error: %v", logTagInflux, n, err)
+		}
+		vs = vs[n:]
+	}
+	return nil
+}
+
+// Close writes the buffered values and stops flushing
+func (c *InfluxCloud) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.quit)
+	})
+	<-c.done
+	return c.Flush()
+}
+
+// requeue puts the values back to the head of the buffer, the oldest ones are dropped if there are too many
+func (c *InfluxCloud) requeue(vs []*Value) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buf = append(vs, c.buf...)
+	max := influxMaxBuffered * c.cfg.BatchSize
+	if len(c.buf) > max {
+		n := len(c.buf) - max
+		c.buf = c.buf[n:]
+		log.Printf("[%v]too many buffered values, dropped %v values", logTagInflux, n)
+	}
+}
+
+func (c *InfluxCloud) run() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.quit:
+			return
+		case <-ticker.C:
+		case <-c.wake:
+		}
+		if err := c.Flush(); err != nil {
+			log.Printf("[%v]failed to write to influxdb, error: %v", logTagInflux, err)
+		}
+	}
+}
+
+func (c *InfluxCloud) write(body []byte) error {
+	req, err := http.NewRequest("POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if c.cfg.Bucket != "" {
+		if c.cfg.Token != "" {
+			req.Header.Set("Authorization", "Token "+c.cfg.Token)
+		}
+	} else if c.cfg.Username != "" {
+		req.SetBasicAuth(c.cfg.Username, c.cfg.Password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp)
+}
+
+// writeURL returns the url of the v2 api if the bucket is set, or the v1 api
+func (c *InfluxCloud) writeURL() string {
+	q := url.Values{}
+	base := strings.TrimRight(c.cfg.URL, "/")
+	if c.cfg.Bucket != "" {
+		q.Set("org", c.cfg.Org)
+		q.Set("bucket", c.cfg.Bucket)
+		q.Set("precision", c.cfg.Precision)
+		return base + "/api/v2/write?" + q.Encode()
+	}
+	db := c.cfg.Database
+	if db == "" {
+		db = InfluxDatabase
+	}
+	q.Set("db", db)
+	if c.cfg.RetentionPolicy != "" {
+		q.Set("rp", c.cfg.RetentionPolicy)
+	}
+	q.Set("precision", influxPrecisions[c.cfg.Precision].v1)
+	return base + "/write?" + q.Encode()
+}
+
+// line formats a value in line protocol, now is used if the value has no time
+func (c *InfluxCloud) line(v *Value, now time.Time) (string, error) {
+	fields, err := influxFields(v.Value)
+	if err != nil {
+		return "", err
+	}
+	t := v.Time
+	if t.IsZero() {
+		t = now
+	}
+	return fmt.Sprintf("%v,device=%v%v %v %v",
+		influxMeasurementEscaper.Replace(c.cfg.Measurement),
+		influxTagEscaper.Replace(v.Device),
+		c.tags,
+		fields,
+		t.UnixNano()/int64(c.unit),
+	), nil
+}
+
+// formatInfluxTags formats the tags sorted by the keys, with a leading comma
+func formatInfluxTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k, v := range tags {
+		if k == "" || v == "" || k == "device" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, ",%v=%v", influxTagEscaper.Replace(k), influxTagEscaper.Replace(tags[k]))
+	}
+	return b.String()
+}
+
+// influxFields formats the fields of a value, each field always has the same type:
+// a number is the float field "value", a bool is the bool field "state",
+// a string is the string field "text", and a gps point is two float fields lat and lon.
+// the numbers are always floats, since an integer could be an int, a uint16 or a float64 decoded from json.
+func influxFields(value interface{}) (string, error) {
+	if pt, ok := value.(*util.Point); ok {
+		return fmt.Sprintf("lat=%v,lon=%v", formatInfluxFloat(float64(pt.Lat), 32), formatInfluxFloat(float64(pt.Lon), 32)), nil
+	}
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return "", fmt.Errorf("invalid float %v", f)
+		}
+		return "value=" + formatInfluxFloat(f, rv.Type().Bits()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "value=" + formatInfluxFloat(float64(rv.Int()), 64), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "value=" + formatInfluxFloat(float64(rv.Uint()), 64), nil
+	case reflect.Bool:
+		return fmt.Sprintf("state=%v", rv.Bool()), nil
+	case reflect.String:
+		return fmt.Sprintf(`text="%v"`, influxStringEscaper.Replace(rv.String())), nil
+	}
+	return "", fmt.Errorf("unsupported type %T", value)
+}
+
+func formatInfluxFloat(f float64, bits int) string {
+	return strconv.FormatFloat(f, 'g', -1, bits)
+}
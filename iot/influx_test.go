@@ -0,0 +1,183 @@
+package iot
+
+import (
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shanghuiyang/rpi-devices/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// influxServer records the requests of writing
+type influxServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   []string
+}
+
+func newInfluxServer() *influxServer {
+	s := &influxServer{status: http.StatusNoContent}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mu.Lock()
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, string(body))
+		status := s.status
+		s.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	return s
+}
+
+func (s *influxServer) setStatus(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status = status
+}
+
+func (s *influxServer) lines() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var lines []string
+	for _, b := range s.bodies {
+		lines = append(lines, strings.Split(strings.TrimSpace(b), "\n")...)
+	}
+	return lines
+}
+
+func (s *influxServer) numRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+func TestInfluxCloudV1(t *testing.T) {
+	svr := newInfluxServer()
+	defer svr.Close()
+	cloud := NewInfluxCloud(&InfluxConfig{
+		URL:      svr.URL,
+		Database: "home",
+		Username: "user",
+		Password: "pass",
+		Tags:     map[string]string{"room": "living room"},
+	})
+	defer cloud.Close()
+
+	host, _ := os.Hostname()
+	at := time.Unix(1600000000, 0)
+	assert.NoError(t, cloud.PushBatch([]*Value{
+		{Device: "temp", Value: float32(22.5), Time: at},
+		{Device: "pm2.5", Value: uint16(35), Time: at},
+		{Device: "temp", Value: 22, Time: at},
+		{Device: "relay", Value: true, Time: at},
+		{Device: "name", Value: `say "hi"`, Time: at},
+		{Device: "gps", Value: &util.Point{Lat: 31.5, Lon: 121.5}, Time: at},
+		{Device: "bad", Value: math.NaN(), Time: at},
+	}))
+
+	assert.Equal(t, 1, svr.numRequests())
+	r := svr.requests[0]
+	assert.Equal(t, "/write", r.URL.Path)
+	assert.Equal(t, "home", r.URL.Query().Get("db"))
+	assert.Equal(t, "s", r.URL.Query().Get("precision"))
+	user, pass, ok := r.BasicAuth()
+	assert.True(t, ok)
+	assert.Equal(t, "user", user)
+	assert.Equal(t, "pass", pass)
+
+	tags := `,host=` + host + `,room=living\ room`
+	assert.Equal(t, []string{
+		`rpi,device=temp` + tags + ` value=22.5 1600000000`,
+		`rpi,device=pm2.5` + tags + ` value=35 1600000000`,
+		`rpi,device=temp` + tags + ` value=22 1600000000`,
+		`rpi,device=relay` + tags + ` state=true 1600000000`,
+		`rpi,device=name` + tags + ` text="say \"hi\"" 1600000000`,
+		`rpi,device=gps` + tags + ` lat=31.5,lon=121.5 1600000000`,
+	}, svr.lines())
+}
+
+func TestInfluxCloudV2(t *testing.T) {
+	svr := newInfluxServer()
+	defer svr.Close()
+	cloud := NewInfluxCloud(&InfluxConfig{
+		URL:         svr.URL,
+		Org:         "home",
+		Bucket:      "sensors",
+		Token:       "token",
+		Measurement: "air",
+		Precision:   "ms",
+	})
+	defer cloud.Close()
+
+	at := time.Unix(1600000000, 0)
+	assert.NoError(t, cloud.PushBatch([]*Value{{Device: "temp", Value: 22.5, Time: at}}))
+	r := svr.requests[0]
+	assert.Equal(t, "/api/v2/write", r.URL.Path)
+	assert.Equal(t, "home", r.URL.Query().Get("org"))
+	assert.Equal(t, "sensors", r.URL.Query().Get("bucket"))
+	assert.Equal(t, "ms", r.URL.Query().Get("precision"))
+	assert.Equal(t, "Token token", r.Header.Get("Authorization"))
+	assert.True(t, strings.HasSuffix(svr.lines()[0], " value=22.5 1600000000000"))
+	assert.True(t, strings.HasPrefix(svr.lines()[0], "air,device=temp,"))
+}
+
+func TestInfluxCloudBatch(t *testing.T) {
+	svr := newInfluxServer()
+	defer svr.Close()
+	cloud := NewInfluxCloud(&InfluxConfig{
+		URL:           svr.URL,
+		BatchSize:     3,
+		FlushInterval: 100 * time.Millisecond,
+	})
+
+	// a full batch is written at once
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, cloud.Push(&Value{Device: "temp", Value: i}))
+	}
+	waitFor(t, func() bool { return svr.numRequests() == 1 })
+	assert.Len(t, svr.lines(), 3)
+
+	// the others are written on the flush interval
+	assert.NoError(t, cloud.Push(&Value{Device: "temp", Value: 3}))
+	waitFor(t, func() bool { return svr.numRequests() == 2 })
+	assert.Len(t, svr.lines(), 4)
+
+	// and on closing
+	assert.NoError(t, cloud.Push(&Value{Device: "temp", Value: 4}))
+	assert.NoError(t, cloud.Close())
+	assert.Len(t, svr.lines(), 5)
+}
+
+func TestInfluxCloudErrors(t *testing.T) {
+	svr := newInfluxServer()
+	defer svr.Close()
+	cloud := NewInfluxCloud(&InfluxConfig{URL: svr.URL, FlushInterval: time.Hour})
+	defer cloud.Close()
+
+	// the values are kept on a temporary error
+	svr.setStatus(http.StatusServiceUnavailable)
+	assert.NoError(t, cloud.Push(&Value{Device: "temp", Value: 22.5}))
+	err := cloud.Flush()
+	assert.Error(t, err)
+	assert.True(t, isTemporary(err))
+	assert.Len(t, cloud.buf, 1)
+
+	// and dropped on a bad request
+	svr.setStatus(http.StatusBadRequest)
+	assert.NoError(t, cloud.Flush())
+	assert.Len(t, cloud.buf, 0)
+
+	err = cloud.PushBatch([]*Value{{Device: "temp", Value: 22.5}})
+	assert.Error(t, err)
+	assert.False(t, isTemporary(err))
+}
@@ -0,0 +1,101 @@
+package iot
+
+import (
+	"reflect"
+
+	"github.com/shanghuiyang/rpi-devices/util"
+	"github.com/shanghuiyang/rpi-devices/util/metrics"
+)
+
+const (
+	// defaultMetricsGauge is the default name of the gauge of the values
+	defaultMetricsGauge = "iot_value"
+)
+
+// MetricsCloud is the implement of Cloud which sets the values to a gauge labeled by the devices,
+// so they're exposed on /metrics by the metrics package.
+// it can wrap another cloud, then the values are pushed to it as well, and the pushes are counted by the results.
+type MetricsCloud struct {
+	cloud  Cloud
+	values *metrics.Gauge
+	pushes *metrics.Counter
+}
+
+// NewMetricsCloud wraps the cloud, the cloud can be nil
+func NewMetricsCloud(cloud Cloud, cfg *MetricsConfig) *MetricsCloud {
+	name := defaultMetricsGauge
+	if cfg != nil && cfg.Gauge != "" {
+		name = cfg.Gauge
+	}
+	return &MetricsCloud{
+		cloud:  cloud,
+		values: metrics.NewGauge(name, "The last value pushed to the iot cloud.", "device"),
+		pushes: metrics.NewCounter("iot_pushes_total", "The number of the values pushed to the iot cloud.", "device", "result"),
+	}
+}
+
+// Push sets the value to the gauge, and pushes it to the wrapped cloud.
+// a gps point is set to two gauges with the devices <device>_lat and <device>_lon,
+// and the values which aren't numbers or bools are only pushed to the wrapped cloud.
+func (m *MetricsCloud) Push(v *Value) error {
+	m.set(v)
+	if m.cloud == nil {
+		return nil
+	}
+	err := m.cloud.Push(v)
+	m.count(v, err)
+	return err
+}
+
+// PushBatch ...
+func (m *MetricsCloud) PushBatch(vs []*Value) error {
+	for _, v := range vs {
+		m.set(v)
+	}
+	if m.cloud == nil {
+		return nil
+	}
+	err := PushBatch(m.cloud, vs)
+	for _, v := range vs {
+		m.count(v, err)
+	}
+	return err
+}
+
+func (m *MetricsCloud) set(v *Value) {
+	if pt, ok := v.Value.(*util.Point); ok {
+		m.values.Set(float64(pt.Lat), v.Device+"_lat")
+		m.values.Set(float64(pt.Lon), v.Device+"_lon")
+		return
+	}
+	if f, ok := toFloat(v.Value); ok {
+		m.values.Set(f, v.Device)
+	}
+}
+
+func (m *MetricsCloud) count(v *Value, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.pushes.Inc(v.Device, result)
+}
+
+// toFloat converts a number or a bool to float64
+func toFloat(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		if rv.Bool() {
+			return 1, true
+		}
+		return 0, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
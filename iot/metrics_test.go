@@ -0,0 +1,26 @@
+package iot
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shanghuiyang/rpi-devices/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsCloud(t *testing.T) {
+	cloud := &mockCloud{n: 1, err: errors.New("network is unreachable")}
+	m := NewMetricsCloud(cloud, &MetricsConfig{Gauge: "test_iot_value"})
+
+	assert.Error(t, m.Push(&Value{Device: "temp", Value: float32(22.5)}))
+	assert.NoError(t, m.Push(&Value{Device: "relay", Value: true}))
+	assert.NoError(t, m.Push(&Value{Device: "gps", Value: &util.Point{Lat: 31.5, Lon: 121.5}}))
+	assert.NoError(t, m.Push(&Value{Device: "name", Value: "rpi"}))
+
+	assert.Equal(t, 22.5, m.values.Value("temp"))
+	assert.Equal(t, 1.0, m.values.Value("relay"))
+	assert.Equal(t, 121.5, m.values.Value("gps_lon"))
+	assert.Equal(t, 1.0, m.pushes.Value("temp", "error"))
+	assert.Equal(t, 1.0, m.pushes.Value("name", "ok"))
+	assert.Len(t, cloud.get(), 3)
+}
@@ -0,0 +1,670 @@
+package iot
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	logTagMQTT = "mqtt"
+
+	defaultMQTTKeepAlive = 60
+	// mqttTimeout is the timeout of connecting, writing a packet and waiting for an ack
+	mqttTimeout    = 10 * time.Second
+	mqttMinBackoff = 1 * time.Second
+	mqttMaxBackoff = 2 * time.Minute
+
+	mqttOnline  = "online"
+	mqttOffline = "offline"
+)
+
+var errMQTTClosed = errors.New("mqtt cloud is closed")
+
+// MQTTHandler handles a message received from a subscribed topic
+type MQTTHandler func(topic string, payload []byte)
+
+// MQTTCloud is the implement of Cloud which publishes the values to a mqtt 3.1.1 broker, e.g. mosquitto.
+// it keeps connected in the background, and reconnects with backoff if the connection is lost.
+// Close() must be called to disconnect gracefully, otherwise the broker publishes the last will.
+type MQTTCloud struct {
+	cfg      MQTTConfig
+	host     string
+	clientID string
+
+	mu   sync.Mutex
+	sess *mqttSession
+
+	// subMu guards subs and hooks
+	subMu sync.Mutex
+	subs  []*mqttSubscription
+	hooks []func()
+
+	commands commandHandlers
+
+	messages  chan *mqttMessage
+	quit      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// mqttSubscription ...
+type mqttSubscription struct {
+	filter  string
+	handler MQTTHandler
+}
+
+// mqttPayload is a value published in json
+type mqttPayload struct {
+	Value interface{} `json:"value"`
+	Time  int64       `json:"time,omitempty"`
+}
+
+// NewMQTTCloud ...
+func NewMQTTCloud(cfg *MQTTConfig) *MQTTCloud {
+	c := &MQTTCloud{
+		cfg:      *cfg,
+		messages: make(chan *mqttMessage, 16),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if c.cfg.Broker == "" {
+		c.cfg.Broker = MQTTBroker
+	}
+	if c.cfg.Topic == "" {
+		c.cfg.Topic = MQTTTopic
+	}
+	if c.cfg.KeepAlive <= 0 {
+		c.cfg.KeepAlive = defaultMQTTKeepAlive
+	}
+	if c.cfg.QoS > 1 {
+		log.Printf("[%v]qos %v isn't supported, use qos 1", logTagMQTT, c.cfg.QoS)
+		c.cfg.QoS = 1
+	}
+
+	host, err := os.Hostname()
+	if err != nil {
+		host = "rpi"
+	}
+	c.host = host
+	c.clientID = c.cfg.ClientID
+	if c.clientID == "" {
+		// every app has its own client id, or the broker kicks out the apps with the same id
+		c.clientID = fmt.Sprintf("%v-%v", host, filepath.Base(os.Args[0]))
+	}
+
+	go c.run()
+	go c.dispatch()
+	return c
+}
+
+// Push publishes a value to the topic of its device
+func (c *MQTTCloud) Push(v *Value) error {
+	payload, err := c.payload(v)
+	if err != nil {
+		return &permanentError{fmt.Errorf("failed to encode the value of %v, error: %v", v.Device, err)}
+	}
+	s, err := c.session()
+	if err != nil {
+		return err
+	}
+	return s.publish(c.Topic(v.Device), payload, c.cfg.QoS, c.cfg.Retain)
+}
+
+// Publish publishes a payload to a topic
+func (c *MQTTCloud) Publish(topic string, payload []byte, retain bool) error {
+	s, err := c.session()
+	if err != nil {
+		return err
+	}
+	return s.publish(topic, payload, c.cfg.QoS, retain)
+}
+
+// Subscribe subscribes a topic filter, the wildcards + and # are supported.
+// the handlers are called one by one in a goroutine, and the filters are subscribed again after reconnecting.
+func (c *MQTTCloud) Subscribe(filter string, handler MQTTHandler) error {
+	c.subMu.Lock()
+	c.subs = append(c.subs, &mqttSubscription{filter: filter, handler: handler})
+	c.subMu.Unlock()
+
+	c.mu.Lock()
+	s := c.sess
+	c.mu.Unlock()
+	if s == nil || s.closed() {
+		// it'll be subscribed on connecting
+		return nil
+	}
+	return s.subscribe(filter, c.cfg.QoS)
+}
+
+// SubscribeCommands subscribes the commands of the device from the topic of the device plus "/cmd",
+// and publishes the acknowledgements and the results to the topic of the device plus "/cmd/result".
+// a command is in json, or only its name in plain text like "on".
+func (c *MQTTCloud) SubscribeCommands(device string, handler CommandHandler) error {
+	if !c.commands.set(device, handler) {
+		return nil
+	}
+	cmdTopic := c.Topic(device) + "/cmd"
+	return c.Subscribe(cmdTopic, func(topic string, payload []byte) {
+		cmd, err := parseCommand(payload)
+		if err != nil {
+			log.Printf("[%v]invalid command %q of %v, error: %v", logTagMQTT, payload, device, err)
+			return
+		}
+		// the device of the topic is trusted, not the one in the payload
+		cmd.Device = device
+		c.commands.handle(cmd, func(r *CommandResult) error {
+			data, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			return c.Publish(cmdTopic+"/result", data, false)
+		})
+	})
+}
+
+// OnConnect adds a hook which is called after connecting or reconnecting to the broker,
+// e.g. for publishing the retained configs again.
+func (c *MQTTCloud) OnConnect(hook func()) {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	c.hooks = append(c.hooks, hook)
+}
+
+// Topic returns the topic which the values of the device are published to
+func (c *MQTTCloud) Topic(device string) string {
+	return c.topic(c.cfg.Topic, device)
+}
+
+// StatusTopic returns the topic of the online/offline status, it's empty if the status isn't published
+func (c *MQTTCloud) StatusTopic() string {
+	if c.cfg.StatusTopic == "" {
+		return ""
+	}
+	return c.topic(c.cfg.StatusTopic, "")
+}
+
+// Connected checks if it's connected to the broker now
+func (c *MQTTCloud) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sess != nil && !c.sess.closed()
+}
+
+// ClientID ...
+func (c *MQTTCloud) ClientID() string {
+	return c.clientID
+}
+
+// Close publishes the "offline" status and disconnects from the broker
+func (c *MQTTCloud) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.quit)
+	})
+	<-c.done
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.sess
+	if s == nil || s.closed() {
+		return nil
+	}
+	defer s.close(errMQTTClosed)
+	if c.cfg.StatusTopic != "" {
+		if err := s.publish(c.StatusTopic(), []byte(mqttOffline), c.cfg.QoS, true); err != nil {
+			return err
+		}
+	}
+	return s.write(&mqttPacket{typ: mqttDisconnect})
+}
+
+// topic replaces {host}, {client} and {device} in the template
+func (c *MQTTCloud) topic(tmpl, device string) string {
+	r := strings.NewReplacer("{host}", c.host, "{client}", c.clientID, "{device}", device)
+	return r.Replace(tmpl)
+}
+
+// payload encodes a value, a string or a number is published as it is if the json isn't enabled
+func (c *MQTTCloud) payload(v *Value) ([]byte, error) {
+	if c.cfg.JSON {
+		p := &mqttPayload{Value: v.Value}
+		if !v.Time.IsZero() {
+			p.Time = v.Time.Unix()
+		}
+		return json.Marshal(p)
+	}
+	switch x := v.Value.(type) {
+	case string:
+		return []byte(x), nil
+	case []byte:
+		return x, nil
+	}
+	switch reflect.ValueOf(v.Value).Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return []byte(fmt.Sprintf("%v", v.Value)), nil
+	}
+	return json.Marshal(v.Value)
+}
+
+// run keeps connected until the cloud is closed
+func (c *MQTTCloud) run() {
+	defer close(c.done)
+	backoff := mqttMinBackoff
+	for {
+		s, err := c.session()
+		if err == errMQTTClosed {
+			return
+		}
+		if err != nil {
+			log.Printf("[%v]failed to connect to %v, retry in %v, error: %v", logTagMQTT, c.cfg.Broker, backoff, err)
+			select {
+			case <-c.quit:
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > mqttMaxBackoff {
+				backoff = mqttMaxBackoff
+			}
+			continue
+		}
+		backoff = mqttMinBackoff
+
+		c.subMu.Lock()
+		hooks := c.hooks
+		c.subMu.Unlock()
+		for _, hook := range hooks {
+			hook()
+		}
+		c.keepAlive(s)
+		select {
+		case <-c.quit:
+			return
+		default:
+		}
+		log.Printf("[%v]disconnected from %v, error: %v", logTagMQTT, c.cfg.Broker, s.err)
+	}
+}
+
+// dispatch calls the handlers of the received messages
+func (c *MQTTCloud) dispatch() {
+	for {
+		select {
+		case <-c.quit:
+			return
+		case m := <-c.messages:
+			c.subMu.Lock()
+			subs := c.subs
+			c.subMu.Unlock()
+			for _, sub := range subs {
+				if mqttMatch(sub.filter, m.topic) {
+					sub.handler(m.topic, m.payload)
+				}
+			}
+		}
+	}
+}
+
+// received is called by the session when a message is received
+func (c *MQTTCloud) received(m *mqttMessage) {
+	select {
+	case c.messages <- m:
+	default:
+		log.Printf("[%v]too many messages, dropped a message of %v", logTagMQTT, m.topic)
+	}
+}
+
+// keepAlive pings the broker until the session is closed
+func (c *MQTTCloud) keepAlive(s *mqttSession) {
+	interval := time.Duration(c.cfg.KeepAlive) * time.Second
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.quit:
+			return
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if time.Since(s.lastReceived()) > interval {
+				s.close(errors.New("keep alive timeout"))
+				return
+			}
+			s.write(&mqttPacket{typ: mqttPingreq})
+		}
+	}
+}
+
+// session returns the current session, or connects to the broker if it's disconnected
+func (c *MQTTCloud) session() (*mqttSession, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.quit:
+		return nil, errMQTTClosed
+	default:
+	}
+	if c.sess != nil && !c.sess.closed() {
+		return c.sess, nil
+	}
+	s, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[%v]connected to %v as %v", logTagMQTT, c.cfg.Broker, c.clientID)
+	c.sess = s
+	return s, nil
+}
+
+func (c *MQTTCloud) connect() (*mqttSession, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &mqttConnectOptions{
+		clientID:  c.clientID,
+		username:  c.cfg.Username,
+		password:  c.cfg.Password,
+		keepAlive: uint16(c.cfg.KeepAlive),
+	}
+	if c.cfg.StatusTopic != "" {
+		opts.willTopic = c.topic(c.cfg.StatusTopic, "")
+		opts.willMessage = mqttOffline
+		opts.willQoS = c.cfg.QoS
+		opts.willRetain = true
+	}
+	conn.SetDeadline(time.Now().Add(mqttTimeout))
+	if err := writeMQTTPacket(conn, newMQTTConnect(opts)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	p, err := readMQTTPacket(r)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if p.typ != mqttConnack || len(p.body) < 2 {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected packet %v, expect connack", p.typ)
+	}
+	if rc := p.body[1]; rc != 0 {
+		conn.Close()
+		msg, ok := mqttConnackMessages[rc]
+		if !ok {
+			msg = fmt.Sprintf("return code %v", rc)
+		}
+		return nil, fmt.Errorf("the broker refused the connection: %v", msg)
+	}
+	conn.SetDeadline(time.Time{})
+
+	s := newMQTTSession(conn, r, c.received)
+	go s.read()
+	if c.cfg.StatusTopic != "" {
+		if err := s.publish(opts.willTopic, []byte(mqttOnline), c.cfg.QoS, true); err != nil {
+			s.close(err)
+			return nil, err
+		}
+	}
+	c.subMu.Lock()
+	subs := c.subs
+	c.subMu.Unlock()
+	for _, sub := range subs {
+		if err := s.subscribe(sub.filter, c.cfg.QoS); err != nil {
+			s.close(err)
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (c *MQTTCloud) dial() (net.Conn, error) {
+	u, err := url.Parse(c.cfg.Broker)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: mqttTimeout}
+	switch u.Scheme {
+	case "tcp", "mqtt":
+		return dialer.Dial("tcp", hostPort(u, "1883"))
+	case "tls", "ssl", "mqtts":
+		cfg, err := c.tlsConfig(u.Hostname())
+		if err != nil {
+			return nil, err
+		}
+		return tls.DialWithDialer(dialer, "tcp", hostPort(u, "8883"), cfg)
+	default:
+		return nil, fmt.Errorf("invalid broker %v, the scheme must be tcp or tls", c.cfg.Broker)
+	}
+}
+
+func (c *MQTTCloud) tlsConfig(host string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: c.cfg.InsecureSkipVerify,
+	}
+	if c.cfg.CAFile != "" {
+		data, err := ioutil.ReadFile(c.cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in %v", c.cfg.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if c.cfg.CertFile != "" || c.cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.cfg.CertFile, c.cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// hostPort returns the host:port of the url, with the default port if it isn't provided
+func hostPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// mqttSession is a connection to the broker
+type mqttSession struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	// onMessage is called when a message is received
+	onMessage func(m *mqttMessage)
+
+	// mu guards writing packets, nextID and pending
+	mu      sync.Mutex
+	nextID  uint16
+	pending map[uint16]chan *mqttPacket
+
+	// lastRecv is the time of the last received packet in unix nano
+	lastRecv int64
+
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+func newMQTTSession(conn net.Conn, r *bufio.Reader, onMessage func(m *mqttMessage)) *mqttSession {
+	return &mqttSession{
+		conn:      conn,
+		r:         r,
+		onMessage: onMessage,
+		pending:   map[uint16]chan *mqttPacket{},
+		lastRecv:  time.Now().UnixNano(),
+		done:      make(chan struct{}),
+	}
+}
+
+// publish publishes a message, and waits for the puback if qos is 1
+func (s *mqttSession) publish(topic string, payload []byte, qos byte, retain bool) error {
+	m := &mqttMessage{
+		topic:   topic,
+		qos:     qos,
+		retain:  retain,
+		payload: payload,
+	}
+	if qos == 0 {
+		return s.write(newMQTTPublish(m))
+	}
+	_, err := s.request(func(id uint16) *mqttPacket {
+		m.id = id
+		return newMQTTPublish(m)
+	})
+	return err
+}
+
+// subscribe subscribes a topic filter, and waits for the suback
+func (s *mqttSession) subscribe(filter string, qos byte) error {
+	ack, err := s.request(func(id uint16) *mqttPacket {
+		return newMQTTSubscribe(id, filter, qos)
+	})
+	if err != nil {
+		return err
+	}
+	if len(ack.body) < 3 || ack.body[2] == 0x80 {
+		return fmt.Errorf("the broker refused to subscribe %v", filter)
+	}
+	return nil
+}
+
+// request writes a packet with a new packet identifier, and waits for its ack
+func (s *mqttSession) request(newPacket func(id uint16) *mqttPacket) (*mqttPacket, error) {
+	ack := make(chan *mqttPacket, 1)
+	s.mu.Lock()
+	s.nextID++
+	if s.nextID == 0 {
+		s.nextID = 1
+	}
+	id := s.nextID
+	s.pending[id] = ack
+	err := s.writeLocked(newPacket(id))
+	s.mu.Unlock()
+	if err != nil {
+		s.forget(id)
+		return nil, err
+	}
+
+	select {
+	case p := <-ack:
+		return p, nil
+	case <-s.done:
+		s.forget(id)
+		return nil, s.err
+	case <-time.After(mqttTimeout):
+		s.forget(id)
+		err := errors.New("timeout waiting for ack")
+		s.close(err)
+		return nil, err
+	}
+}
+
+func (s *mqttSession) forget(id uint16) {
+	s.mu.Lock()
+	delete(s.pending, id)
+	s.mu.Unlock()
+}
+
+func (s *mqttSession) write(p *mqttPacket) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeLocked(p)
+}
+
+// writeLocked writes a packet, s.mu must be held. the session is closed if it fails.
+func (s *mqttSession) writeLocked(p *mqttPacket) error {
+	s.conn.SetWriteDeadline(time.Now().Add(mqttTimeout))
+	if err := writeMQTTPacket(s.conn, p); err != nil {
+		s.close(err)
+		return err
+	}
+	return nil
+}
+
+// read reads the packets until the connection is closed
+func (s *mqttSession) read() {
+	for {
+		p, err := readMQTTPacket(s.r)
+		if err != nil {
+			s.close(err)
+			return
+		}
+		atomic.StoreInt64(&s.lastRecv, time.Now().UnixNano())
+		switch p.typ {
+		case mqttPuback, mqttSuback:
+			id, err := p.packetID()
+			if err != nil {
+				log.Printf("[%v]invalid ack, error: %v", logTagMQTT, err)
+				continue
+			}
+			s.mu.Lock()
+			ack, ok := s.pending[id]
+			delete(s.pending, id)
+			s.mu.Unlock()
+			if ok {
+				ack <- p
+			}
+		case mqttPublish:
+			m, err := parseMQTTPublish(p)
+			if err != nil {
+				log.Printf("[%v]invalid publish, error: %v", logTagMQTT, err)
+				continue
+			}
+			if m.qos > 0 {
+				s.write(newMQTTPuback(m.id))
+			}
+			s.onMessage(m)
+		case mqttPingresp:
+			// lastRecv has been updated
+		default:
+			log.Printf("[%v]ignore an unexpected packet %v", logTagMQTT, p.typ)
+		}
+	}
+}
+
+func (s *mqttSession) lastReceived() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&s.lastRecv))
+}
+
+func (s *mqttSession) close(err error) {
+	s.once.Do(func() {
+		s.err = err
+		s.conn.Close()
+		close(s.done)
+	})
+}
+
+func (s *mqttSession) closed() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
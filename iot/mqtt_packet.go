@@ -0,0 +1,242 @@
+package iot
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// the types of mqtt 3.1.1 control packets
+const (
+	mqttConnect    byte = 1
+	mqttConnack    byte = 2
+	mqttPublish    byte = 3
+	mqttPuback     byte = 4
+	mqttSubscribe  byte = 8
+	mqttSuback     byte = 9
+	mqttPingreq    byte = 12
+	mqttPingresp   byte = 13
+	mqttDisconnect byte = 14
+)
+
+const (
+	// mqttProtocolLevel is the protocol level of mqtt 3.1.1
+	mqttProtocolLevel = 4
+	// mqttMaxLength is the max remaining length of a packet
+	mqttMaxLength = 268435455
+)
+
+// mqttConnackMessages are the messages of the return codes in a connack packet
+var mqttConnackMessages = map[byte]string{
+	1: "unacceptable protocol version",
+	2: "identifier rejected",
+	3: "server unavailable",
+	4: "bad user name or password",
+	5: "not authorized",
+}
+
+// mqttPacket is a control packet, body is the variable header and the payload
+type mqttPacket struct {
+	typ   byte
+	flags byte
+	body  []byte
+}
+
+// mqttConnectOptions ...
+type mqttConnectOptions struct {
+	clientID    string
+	username    string
+	password    string
+	keepAlive   uint16
+	willTopic   string
+	willMessage string
+	willQoS     byte
+	willRetain  bool
+}
+
+// mqttMessage is a received publish packet
+type mqttMessage struct {
+	topic   string
+	id      uint16
+	qos     byte
+	retain  bool
+	payload []byte
+}
+
+func readMQTTPacket(r *bufio.Reader) (*mqttPacket, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length, multiplier := 0, 1
+	for i := 0; ; i++ {
+		if i == 4 {
+			return nil, errors.New("malformed remaining length")
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		length += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	p := &mqttPacket{
+		typ:   header >> 4,
+		flags: header & 0x0f,
+		body:  make([]byte, length),
+	}
+	if _, err := io.ReadFull(r, p.body); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func writeMQTTPacket(w io.Writer, p *mqttPacket) error {
+	if len(p.body) > mqttMaxLength {
+		return fmt.Errorf("packet is too large: %v bytes", len(p.body))
+	}
+	buf := make([]byte, 0, 5+len(p.body))
+	buf = append(buf, p.typ<<4|p.flags)
+	length := len(p.body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+		if length > 0 {
+			b |= 0x80
+		}
+		buf = append(buf, b)
+		if length == 0 {
+			break
+		}
+	}
+	buf = append(buf, p.body...)
+	_, err := w.Write(buf)
+	return err
+}
+
+func appendMQTTString(buf []byte, s string) []byte {
+	buf = append(buf, byte(len(s)>>8), byte(len(s)))
+	return append(buf, s...)
+}
+
+func readMQTTString(buf []byte) (string, []byte, error) {
+	if len(buf) < 2 {
+		return "", nil, errors.New("malformed string")
+	}
+	n := int(binary.BigEndian.Uint16(buf))
+	if len(buf) < 2+n {
+		return "", nil, errors.New("malformed string")
+	}
+	return string(buf[2 : 2+n]), buf[2+n:], nil
+}
+
+func appendMQTTID(buf []byte, id uint16) []byte {
+	return append(buf, byte(id>>8), byte(id))
+}
+
+func newMQTTConnect(opts *mqttConnectOptions) *mqttPacket {
+	var flags byte = 0x02 // clean session
+	if opts.willTopic != "" {
+		flags |= 0x04 | opts.willQoS<<3
+		if opts.willRetain {
+			flags |= 0x20
+		}
+	}
+	if opts.username != "" {
+		flags |= 0x80
+		if opts.password != "" {
+			flags |= 0x40
+		}
+	}
+	body := appendMQTTString(nil, "MQTT")
+	body = append(body, mqttProtocolLevel, flags, byte(opts.keepAlive>>8), byte(opts.keepAlive))
+	body = appendMQTTString(body, opts.clientID)
+	if opts.willTopic != "" {
+		body = appendMQTTString(body, opts.willTopic)
+		body = appendMQTTString(body, opts.willMessage)
+	}
+	if opts.username != "" {
+		body = appendMQTTString(body, opts.username)
+		if opts.password != "" {
+			body = appendMQTTString(body, opts.password)
+		}
+	}
+	return &mqttPacket{typ: mqttConnect, body: body}
+}
+
+func newMQTTPublish(m *mqttMessage) *mqttPacket {
+	flags := m.qos << 1
+	if m.retain {
+		flags |= 0x01
+	}
+	body := appendMQTTString(nil, m.topic)
+	if m.qos > 0 {
+		body = appendMQTTID(body, m.id)
+	}
+	body = append(body, m.payload...)
+	return &mqttPacket{typ: mqttPublish, flags: flags, body: body}
+}
+
+func parseMQTTPublish(p *mqttPacket) (*mqttMessage, error) {
+	m := &mqttMessage{
+		qos:    (p.flags >> 1) & 0x03,
+		retain: p.flags&0x01 != 0,
+	}
+	topic, rest, err := readMQTTString(p.body)
+	if err != nil {
+		return nil, err
+	}
+	m.topic = topic
+	if m.qos > 0 {
+		if len(rest) < 2 {
+			return nil, errors.New("malformed publish packet")
+		}
+		m.id = binary.BigEndian.Uint16(rest)
+		rest = rest[2:]
+	}
+	m.payload = rest
+	return m, nil
+}
+
+func newMQTTSubscribe(id uint16, filter string, qos byte) *mqttPacket {
+	body := appendMQTTID(nil, id)
+	body = appendMQTTString(body, filter)
+	body = append(body, qos)
+	return &mqttPacket{typ: mqttSubscribe, flags: 0x02, body: body}
+}
+
+func newMQTTPuback(id uint16) *mqttPacket {
+	return &mqttPacket{typ: mqttPuback, body: appendMQTTID(nil, id)}
+}
+
+// packetID returns the packet identifier of a puback or suback packet
+func (p *mqttPacket) packetID() (uint16, error) {
+	if len(p.body) < 2 {
+		return 0, errors.New("malformed packet")
+	}
+	return binary.BigEndian.Uint16(p.body), nil
+}
+
+// mqttMatch checks if a topic matches a filter with the wildcards + and #
+func mqttMatch(filter, topic string) bool {
+	fs := strings.Split(filter, "/")
+	ts := strings.Split(topic, "/")
+	for i, f := range fs {
+		if f == "#" {
+			return true
+		}
+		if i >= len(ts) {
+			return false
+		}
+		if f != "+" && f != ts[i] {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
@@ -0,0 +1,330 @@
+package iot
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/shanghuiyang/rpi-devices/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBroker is an in-process stand-in of a mqtt broker,
+// it records the published messages and the connected clients, and forwards the messages to the subscribers.
+type fakeBroker struct {
+	ln net.Listener
+	// rc is the return code of connack
+	rc byte
+
+	mu       sync.Mutex
+	clients  []string
+	conns    []*brokerConn
+	messages []*mqttMessage
+	retained map[string]string
+}
+
+// brokerConn is a connection of a client
+type brokerConn struct {
+	conn    net.Conn
+	mu      sync.Mutex
+	filters []string
+}
+
+func (c *brokerConn) write(p *mqttPacket) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeMQTTPacket(c.conn, p)
+}
+
+func newFakeBroker(ln net.Listener, rc byte) *fakeBroker {
+	b := &fakeBroker{
+		ln:       ln,
+		rc:       rc,
+		retained: map[string]string{},
+	}
+	go b.serve()
+	return b
+}
+
+func (b *fakeBroker) serve() {
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *fakeBroker) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	p, err := readMQTTPacket(r)
+	if err != nil || p.typ != mqttConnect {
+		return
+	}
+	clientID, will := parseConnect(p)
+	c := &brokerConn{conn: conn}
+	c.write(&mqttPacket{typ: mqttConnack, body: []byte{0, b.rc}})
+	if b.rc != 0 {
+		return
+	}
+	b.mu.Lock()
+	b.clients = append(b.clients, clientID)
+	b.conns = append(b.conns, c)
+	b.mu.Unlock()
+
+	for {
+		p, err := readMQTTPacket(r)
+		if err != nil {
+			break
+		}
+		switch p.typ {
+		case mqttPublish:
+			m, err := parseMQTTPublish(p)
+			if err != nil {
+				return
+			}
+			if m.qos > 0 {
+				c.write(newMQTTPuback(m.id))
+			}
+			b.deliver(m)
+		case mqttSubscribe:
+			id, _ := p.packetID()
+			filter, _, _ := readMQTTString(p.body[2:])
+			b.mu.Lock()
+			c.filters = append(c.filters, filter)
+			b.mu.Unlock()
+			c.write(&mqttPacket{typ: mqttSuback, body: []byte{byte(id >> 8), byte(id), 0}})
+		case mqttPingreq:
+			c.write(&mqttPacket{typ: mqttPingresp})
+		case mqttDisconnect:
+			b.remove(c)
+			return
+		}
+	}
+	// the connection is lost without a disconnect
+	b.remove(c)
+	if will != nil {
+		b.deliver(will)
+	}
+}
+
+// parseConnect returns the client id and the last will of a connect packet
+func parseConnect(p *mqttPacket) (string, *mqttMessage) {
+	_, rest, _ := readMQTTString(p.body)
+	flags := rest[1]
+	clientID, rest, _ := readMQTTString(rest[4:])
+	if flags&0x04 == 0 {
+		return clientID, nil
+	}
+	topic, rest, _ := readMQTTString(rest)
+	msg, _, _ := readMQTTString(rest)
+	return clientID, &mqttMessage{
+		topic:   topic,
+		qos:     (flags >> 3) & 0x03,
+		retain:  flags&0x20 != 0,
+		payload: []byte(msg),
+	}
+}
+
+// deliver records a message, and forwards it to the subscribers
+func (b *fakeBroker) deliver(m *mqttMessage) {
+	b.mu.Lock()
+	b.messages = append(b.messages, m)
+	if m.retain {
+		b.retained[m.topic] = string(m.payload)
+	}
+	var subscribers []*brokerConn
+	for _, c := range b.conns {
+		for _, f := range c.filters {
+			if mqttMatch(f, m.topic) {
+				subscribers = append(subscribers, c)
+				break
+			}
+		}
+	}
+	b.mu.Unlock()
+
+	for _, c := range subscribers {
+		c.write(newMQTTPublish(&mqttMessage{topic: m.topic, payload: m.payload}))
+	}
+}
+
+// publish publishes a message like another client
+func (b *fakeBroker) publish(topic, payload string) {
+	b.deliver(&mqttMessage{topic: topic, payload: []byte(payload)})
+}
+
+func (b *fakeBroker) remove(c *brokerConn) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, conn := range b.conns {
+		if conn == c {
+			b.conns = append(b.conns[:i], b.conns[i+1:]...)
+			return
+		}
+	}
+}
+
+// kick drops all the connections like a network outage
+func (b *fakeBroker) kick() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range b.conns {
+		c.conn.Close()
+	}
+}
+
+func (b *fakeBroker) numClients() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.clients)
+}
+
+func (b *fakeBroker) numMessages() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.messages)
+}
+
+func (b *fakeBroker) lastMessage() *mqttMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.messages[len(b.messages)-1]
+}
+
+func (b *fakeBroker) retainedOf(topic string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.retained[topic]
+}
+
+func TestMQTTCloud(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	broker := newFakeBroker(ln, 0)
+
+	host, _ := os.Hostname()
+	status := "rpi/" + host + "/test/status"
+	cloud := NewMQTTCloud(&MQTTConfig{
+		Broker:      "tcp://" + ln.Addr().String(),
+		ClientID:    "test",
+		QoS:         1,
+		Retain:      true,
+		StatusTopic: MQTTStatusTopic,
+	})
+
+	assert.NoError(t, cloud.Push(&Value{Device: "temp", Value: 22.5}))
+	m := broker.lastMessage()
+	assert.Equal(t, "rpi/"+host+"/temp", m.topic)
+	assert.Equal(t, "22.5", string(m.payload))
+	assert.Equal(t, byte(1), m.qos)
+	assert.True(t, m.retain)
+	assert.Equal(t, "online", broker.retainedOf(status))
+
+	assert.NoError(t, cloud.Push(&Value{Device: "gps", Value: &util.Point{Lat: 31.5, Lon: 121.5}}))
+	assert.Equal(t, `{"lat":31.5,"lon":121.5}`, string(broker.lastMessage().payload))
+
+	// the broker publishes the last will, and the cloud reconnects
+	broker.kick()
+	waitFor(t, func() bool { return broker.numClients() == 2 && broker.retainedOf(status) == "online" })
+	assert.NoError(t, cloud.Push(&Value{Device: "temp", Value: 23}))
+	assert.Equal(t, "23", string(broker.lastMessage().payload))
+
+	assert.NoError(t, cloud.Close())
+	assert.Equal(t, "offline", broker.retainedOf(status))
+	assert.Error(t, cloud.Push(&Value{Device: "temp", Value: 23}))
+}
+
+func TestMQTTCloudRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	newFakeBroker(ln, 5)
+
+	cloud := NewMQTTCloud(&MQTTConfig{Broker: "tcp://" + ln.Addr().String()})
+	defer cloud.Close()
+	err = cloud.Push(&Value{Device: "temp", Value: 22.5})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not authorized")
+}
+
+func TestMQTTCloudSubscribe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer ln.Close()
+	broker := newFakeBroker(ln, 0)
+
+	cloud := NewMQTTCloud(&MQTTConfig{Broker: "tcp://" + ln.Addr().String(), QoS: 1})
+	defer cloud.Close()
+	var mu sync.Mutex
+	var received []string
+	handler := func(topic string, payload []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, topic+"="+string(payload))
+	}
+	count := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received)
+	}
+
+	// subscribe before connecting
+	assert.NoError(t, cloud.Subscribe("cmd/+/set", handler))
+	waitFor(t, cloud.Connected)
+	assert.NoError(t, cloud.Subscribe("all/#", handler))
+	broker.publish("cmd/fan/set", "ON")
+	broker.publish("cmd/fan/state", "ON")
+	broker.publish("all/a/b", "1")
+	waitFor(t, func() bool { return count() == 2 })
+	assert.Equal(t, []string{"cmd/fan/set=ON", "all/a/b=1"}, received)
+
+	// subscribe again after reconnecting
+	broker.kick()
+	waitFor(t, func() bool { return broker.numClients() == 2 && cloud.Connected() })
+	broker.publish("cmd/light/set", "OFF")
+	waitFor(t, func() bool { return count() == 3 })
+	assert.Equal(t, "cmd/light/set=OFF", received[2])
+}
+
+func TestMQTTCloudTLS(t *testing.T) {
+	// borrow the self-signed certificate of httptest for 127.0.0.1
+	svr := httptest.NewTLSServer(nil)
+	defer svr.Close()
+	dir, err := ioutil.TempDir("", "mqtt")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: svr.Certificate().Raw})
+	assert.NoError(t, ioutil.WriteFile(caFile, ca, 0644))
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", svr.TLS)
+	assert.NoError(t, err)
+	defer ln.Close()
+	broker := newFakeBroker(ln, 0)
+
+	cloud := NewMQTTCloud(&MQTTConfig{
+		Broker: "tls://" + ln.Addr().String(),
+		CAFile: caFile,
+		Topic:  "home/{device}",
+		JSON:   true,
+	})
+	defer cloud.Close()
+	assert.NoError(t, cloud.Push(&Value{Device: "pm2.5", Value: 35}))
+	// no puback for qos 0
+	waitFor(t, func() bool { return broker.numMessages() == 1 })
+	m := broker.lastMessage()
+	assert.Equal(t, "home/pm2.5", m.topic)
+	assert.Equal(t, `{"value":35}`, string(m.payload))
+	assert.Equal(t, byte(0), m.qos)
+}
@@ -0,0 +1,233 @@
+package iot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	logTagMulti = "multi"
+
+	defaultMultiQueueSize = 100
+)
+
+// MultiBackend is a cloud of a MultiCloud, with the filters of the values pushed to it
+type MultiBackend struct {
+	// Name is the name of the backend in the logs, e.g. "onenet",
+	// it's the name of the cloud in the config file if the MultiCloud is loaded by LoadClouds()
+	Name  string `json:"cloud"`
+	Cloud Cloud  `json:"-"`
+	// Devices is the allowlist of the devices, the values of all the devices are pushed if it's empty
+	Devices []string `json:"devices"`
+	// Rename renames the devices before pushing, e.g. {"temperature": "temp"}
+	Rename map[string]string `json:"rename"`
+	// Interval is the min interval of pushing the values of a device,
+	// the values within the interval since the last pushed one are dropped. 0 means no limit.
+	Interval time.Duration `json:"interval"`
+	// QueueSize is the max number of the pushes waiting for the backend, the default is 100
+	QueueSize int `json:"queue_size"`
+}
+
+// MultiConfig is the config of a MultiCloud in the config file, the backends refer to the other clouds by names
+type MultiConfig struct {
+	Backends []*MultiBackend `json:"backends"`
+}
+
+// MultiCloud is the implement of Cloud which pushes the values to many backends,
+// e.g. OneNet for the charts, mqtt for Home Assistant, and influxdb for the history.
+//
+// each backend has its own queue and goroutine, so a slow or broken backend doesn't block the others,
+// and Push() never blocks on the backends. the errors of a backend are logged, and the pushes are
+// dropped when its queue is full. wrap a backend with a ReliableCloud if its values mustn't be lost.
+type MultiCloud struct {
+	mu       sync.RWMutex
+	closed   bool
+	backends []*multiBackend
+}
+
+type multiBackend struct {
+	MultiBackend
+	devices map[string]bool
+
+	mu   sync.Mutex
+	last map[string]time.Time
+
+	queue chan []*Value
+	done  chan struct{}
+}
+
+// NewMultiCloud creates a MultiCloud of the backends, Close() must be called to stop it
+func NewMultiCloud(backends ...*MultiBackend) *MultiCloud {
+	m := &MultiCloud{}
+	for _, cfg := range backends {
+		if cfg.Cloud == nil {
+			log.Printf("[%v]cloud of backend %v is nil, skip it", logTagMulti, cfg.Name)
+			continue
+		}
+		b := &multiBackend{
+			MultiBackend: *cfg,
+			last:         map[string]time.Time{},
+			done:         make(chan struct{}),
+		}
+		if len(b.Devices) > 0 {
+			b.devices = map[string]bool{}
+			for _, d := range b.Devices {
+				b.devices[d] = true
+			}
+		}
+		if b.QueueSize <= 0 {
+			b.QueueSize = defaultMultiQueueSize
+		}
+		b.queue = make(chan []*Value, b.QueueSize)
+		go b.run()
+		m.backends = append(m.backends, b)
+	}
+	return m
+}
+
+// UnmarshalJSON decodes a backend in the config file, the interval can be a string like "1m"
+func (b *MultiBackend) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	return decodeConfig(fields, b)
+}
+
+// Validate ...
+func (cfg *MultiConfig) Validate() error {
+	if len(cfg.Backends) == 0 {
+		return errors.New("backends are required")
+	}
+	for i, b := range cfg.Backends {
+		if b == nil || b.Name == "" {
+			return fmt.Errorf("cloud of backend %v is required", i)
+		}
+		if b.Interval < 0 {
+			return fmt.Errorf("interval of backend %v is negative", b.Name)
+		}
+	}
+	return nil
+}
+
+func (cfg *MultiConfig) references() []string {
+	names := make([]string, len(cfg.Backends))
+	for i, b := range cfg.Backends {
+		names[i] = b.Name
+	}
+	return names
+}
+
+// newMultiCloudFromConfig is the factory of the MultiClouds in the config file
+func newMultiCloudFromConfig(config interface{}, get func(name string) (Cloud, error)) (Cloud, error) {
+	cfg := config.(*MultiConfig)
+	backends := make([]*MultiBackend, len(cfg.Backends))
+	for i, b := range cfg.Backends {
+		cloud, err := get(b.Name)
+		if err != nil {
+			return nil, err
+		}
+		backend := *b
+		backend.Cloud = cloud
+		backends[i] = &backend
+	}
+	return NewMultiCloud(backends...), nil
+}
+
+// Push pushes a value to the backends, see PushBatch()
+func (m *MultiCloud) Push(v *Value) error {
+	return m.PushBatch([]*Value{v})
+}
+
+// PushBatch queues the values for the backends without blocking,
+// the values are stamped with the current time if their times are zero.
+// it only returns an error if the cloud has been closed.
+func (m *MultiCloud) PushBatch(vs []*Value) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return errors.New("cloud is closed")
+	}
+
+	now := time.Now()
+	stamped := make([]*Value, len(vs))
+	for i, v := range vs {
+		if v.Time.IsZero() {
+			cp := *v
+			cp.Time = now
+			v = &cp
+		}
+		stamped[i] = v
+	}
+	for _, b := range m.backends {
+		filtered := b.filter(stamped)
+		if len(filtered) == 0 {
+			continue
+		}
+		select {
+		case b.queue <- filtered:
+		default:
+			log.Printf("[%v]queue of %v is full, dropped %v values", logTagMulti, b.Name, len(filtered))
+		}
+	}
+	return nil
+}
+
+// Close pushes the queued values and stops, the backends aren't closed
+func (m *MultiCloud) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	for _, b := range m.backends {
+		close(b.queue)
+	}
+	m.mu.Unlock()
+
+	for _, b := range m.backends {
+		<-b.done
+	}
+	return nil
+}
+
+// filter returns the allowed values renamed for the backend,
+// and drops the values of a device pushed within the interval.
+func (b *multiBackend) filter(vs []*Value) []*Value {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var filtered []*Value
+	for _, v := range vs {
+		if b.devices != nil && !b.devices[v.Device] {
+			continue
+		}
+		if b.Interval > 0 {
+			if last, ok := b.last[v.Device]; ok && v.Time.Sub(last) < b.Interval {
+				continue
+			}
+			b.last[v.Device] = v.Time
+		}
+		if name, ok := b.Rename[v.Device]; ok {
+			renamed := *v
+			renamed.Device = name
+			v = &renamed
+		}
+		filtered = append(filtered, v)
+	}
+	return filtered
+}
+
+func (b *multiBackend) run() {
+	defer close(b.done)
+	for vs := range b.queue {
+		if err := PushBatch(b.Cloud, vs); err != nil {
+			log.Printf("[%v]failed to push %v values to %v, error: %v", logTagMulti, len(vs), b.Name, err)
+		}
+	}
+}
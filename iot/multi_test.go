@@ -0,0 +1,77 @@
+package iot
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingCloud blocks the pushes until it's released
+type blockingCloud struct {
+	release chan struct{}
+}
+
+func (b *blockingCloud) Push(v *Value) error {
+	<-b.release
+	return nil
+}
+
+func TestMultiCloud(t *testing.T) {
+	all := &mockBatchCloud{&mockCloud{}}
+	temp := &mockCloud{}
+	broken := &mockCloud{n: 100, err: errors.New("network is unreachable")}
+	m := NewMultiCloud(
+		&MultiBackend{Name: "all", Cloud: all},
+		&MultiBackend{
+			Name:     "temp",
+			Cloud:    temp,
+			Devices:  []string{"temperature"},
+			Rename:   map[string]string{"temperature": "temp"},
+			Interval: time.Minute,
+		},
+		&MultiBackend{Name: "broken", Cloud: broken},
+		&MultiBackend{Name: "nil"},
+	)
+
+	at := time.Now()
+	assert.NoError(t, m.PushBatch([]*Value{
+		{Device: "temperature", Value: 22.5, Time: at},
+		{Device: "humidity", Value: 50, Time: at},
+	}))
+	// within the interval
+	assert.NoError(t, m.Push(&Value{Device: "temperature", Value: 23, Time: at.Add(30 * time.Second)}))
+	assert.NoError(t, m.Push(&Value{Device: "temperature", Value: 24, Time: at.Add(time.Minute)}))
+	assert.NoError(t, m.Close())
+	assert.Error(t, m.Push(&Value{Device: "temperature", Value: 25}))
+
+	assert.Len(t, all.get(), 4)
+	assert.Equal(t, 3, all.pushes)
+
+	vs := temp.get()
+	assert.Len(t, vs, 2)
+	assert.Equal(t, "temp", vs[0].Device)
+	assert.Equal(t, 22.5, vs[0].Value)
+	assert.Equal(t, 24, vs[1].Value)
+	assert.Equal(t, 3, broken.pushes)
+}
+
+func TestMultiCloudIsolation(t *testing.T) {
+	slow := &blockingCloud{release: make(chan struct{})}
+	fast := &mockCloud{}
+	m := NewMultiCloud(
+		&MultiBackend{Name: "slow", Cloud: slow, QueueSize: 1},
+		&MultiBackend{Name: "fast", Cloud: fast},
+	)
+
+	// the slow backend doesn't block the pushes and the fast backend
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, m.Push(&Value{Device: "temperature", Value: i}))
+	}
+	waitFor(t, func() bool { return len(fast.get()) == 5 })
+	assert.False(t, fast.get()[0].Time.IsZero())
+
+	close(slow.release)
+	assert.NoError(t, m.Close())
+}
@@ -3,6 +3,7 @@ package iot
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"time"
 )
@@ -28,11 +29,28 @@ type Datastream struct {
 	Datapoints []*Datapoint `json:"datapoints"`
 }
 
+// oneNetResponse is the response of OneNet api
+type oneNetResponse struct {
+	Errno int    `json:"errno"`
+	Error string `json:"error"`
+}
+
 // Datapoint ...
 type Datapoint struct {
+	// At is the time of the datapoint in the format of oneNetTimeFormat in oneNetZone, the server time is used if it's empty
+	At    string      `json:"at,omitempty"`
 	Value interface{} `json:"value"`
 }
 
+const (
+	// oneNetTimeFormat is the format of the time of a datapoint
+	oneNetTimeFormat = "2006-01-02T15:04:05"
+	oneNetTimeout    = 15 * time.Second
+)
+
+// oneNetZone is the time zone of the times of the datapoints, OneNet reads them in China Standard Time
+var oneNetZone = time.FixedZone("CST", 8*60*60)
+
 // NewOneNetCloud ...
 func NewOneNetCloud(cfg *OneNetConfig) *OneNetCloud {
 	return &OneNetCloud{
@@ -43,34 +61,72 @@ func NewOneNetCloud(cfg *OneNetConfig) *OneNetCloud {
 
 // Push ...
 func (o *OneNetCloud) Push(v *Value) error {
-	datapoint := OneNetData{
-		Datastreams: []*Datastream{
-			{
-				ID: v.Device,
-				Datapoints: []*Datapoint{
-					{
-						Value: v.Value,
-					},
-				},
-			},
-		},
+	return o.PushBatch([]*Value{v})
+}
+
+// PushBatch pushes the values of many devices in one request,
+// the values of a device are pushed as the datapoints of a datastream.
+func (o *OneNetCloud) PushBatch(vs []*Value) error {
+	if len(vs) == 0 {
+		return nil
 	}
-	data, err := json.Marshal(datapoint)
+	var streams []*Datastream
+	index := map[string]*Datastream{}
+	for _, v := range vs {
+		ds, ok := index[v.Device]
+		if !ok {
+			ds = &Datastream{ID: v.Device}
+			index[v.Device] = ds
+			streams = append(streams, ds)
+		}
+		dp := &Datapoint{Value: v.Value}
+		if !v.Time.IsZero() {
+			dp.At = v.Time.In(oneNetZone).Format(oneNetTimeFormat)
+		}
+		ds.Datapoints = append(ds.Datapoints, dp)
+	}
+	data, err := json.Marshal(&OneNetData{Datastreams: streams})
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest("POST", o.api, bytes.NewBuffer(data))
+	return o.request("POST", o.api, data)
+}
+
+// request requests the api, and returns an APIError if the errno in the response isn't 0
+func (o *OneNetCloud) request(method, api string, body []byte) error {
+	req, err := http.NewRequest(method, api, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
 	req.Header.Set("api-key", o.token)
-	req.Header.Set("Content-Type", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	client := &http.Client{
-		Timeout: 15 * time.Second,
+		Timeout: oneNetTimeout,
 	}
 	resp, err := client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+
+	// OneNet responses {"errno": 0, "error": "succ"} on success
+	var result oneNetResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode the response, error: %v", err)
+	}
+	if result.Errno != 0 {
+		return &APIError{
+			Status:  resp.StatusCode,
+			Code:    result.Errno,
+			Message: result.Error,
+		}
+	}
 	return nil
 }
@@ -0,0 +1,68 @@
+package iot
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOneNetErrors(t *testing.T) {
+	var status int
+	var body string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "token", r.Header.Get("api-key"))
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer svr.Close()
+	cloud := NewOneNetCloud(&OneNetConfig{Token: "token", API: svr.URL})
+	v := &Value{Device: "temperature", Value: 22.5}
+
+	status, body = http.StatusOK, `{"errno": 0, "error": "succ"}`
+	assert.NoError(t, cloud.Push(v))
+
+	status, body = http.StatusOK, `{"errno": 2, "error": "auth failed"}`
+	err := cloud.Push(v)
+	assert.Error(t, err)
+	assert.False(t, isTemporary(err))
+
+	status, body = http.StatusServiceUnavailable, "busy"
+	err = cloud.Push(v)
+	assert.Error(t, err)
+	assert.True(t, isTemporary(err))
+}
+
+func TestOneNetPushBatch(t *testing.T) {
+	var data OneNetData
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&data))
+		w.Write([]byte(`{"errno": 0, "error": "succ"}`))
+	}))
+	defer svr.Close()
+	cloud := NewOneNetCloud(&OneNetConfig{Token: "token", API: svr.URL})
+
+	at := time.Date(2020, 12, 1, 8, 30, 0, 0, oneNetZone)
+	err := cloud.PushBatch([]*Value{
+		{Device: "temp", Value: 21.5, Time: at},
+		{Device: "pm2.5", Value: 35, Time: at},
+		{Device: "temp", Value: 22.0, Time: at.Add(time.Minute)},
+		{Device: "temp", Value: 22.5},
+		// on a pi in utc
+		{Device: "temp", Value: 23.0, Time: time.Date(2020, 12, 1, 0, 32, 0, 0, time.UTC)},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, data.Datastreams, 2)
+	temp := data.Datastreams[0]
+	assert.Equal(t, "temp", temp.ID)
+	assert.Len(t, temp.Datapoints, 4)
+	assert.Equal(t, "2020-12-01T08:30:00", temp.Datapoints[0].At)
+	assert.Equal(t, "2020-12-01T08:31:00", temp.Datapoints[1].At)
+	assert.Equal(t, "", temp.Datapoints[2].At)
+	assert.Equal(t, "2020-12-01T08:32:00", temp.Datapoints[3].At)
+	assert.Equal(t, 22.0, temp.Datapoints[1].Value)
+	assert.Equal(t, "pm2.5", data.Datastreams[1].ID)
+}
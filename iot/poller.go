@@ -0,0 +1,204 @@
+package iot
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	logTagPoller = "poller"
+
+	defaultPollInterval = 5 * time.Second
+	pollerTimeout       = 15 * time.Second
+)
+
+// CommandPoller is a Commander which polls the commands from a http server of your own,
+// e.g. for the devices behind a NAT without a mqtt broker:
+//
+//	GET  <api>              returns the new commands in a json array, e.g. [{"id": "1", "device": "fan", "name": "on"}]
+//	POST <api>/<id>/resp    receives the acknowledgement and the result of a command, a CommandResult in json
+//
+// the server should return each command only once, and the id and the device of a command are required.
+//
+// it isn't the api of OneNet: the http api of OneNet only sends the commands to the devices connected by edp or mqtt,
+// and there is no api for a http device to get its commands. use the mqtt cloud for the commands from OneNet.
+//
+// it's the kind "poller" in the config file of the clouds, get it with Clouds.Commander().
+// it implements Cloud only for the config file, Push() fails since it doesn't push any values.
+type CommandPoller struct {
+	api      string
+	token    string
+	interval time.Duration
+	commands commandHandlers
+
+	mu        sync.Mutex
+	polling   bool
+	failing   bool
+	quit      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewCommandPoller creates a CommandPoller, it starts polling on the first SubscribeCommands()
+func NewCommandPoller(cfg *CommandPollerConfig) *CommandPoller {
+	p := &CommandPoller{
+		api:      cfg.API,
+		token:    cfg.Token,
+		interval: cfg.Interval,
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if p.interval <= 0 {
+		p.interval = defaultPollInterval
+	}
+	return p
+}
+
+// Push fails, a CommandPoller doesn't push any values
+func (p *CommandPoller) Push(v *Value) error {
+	return &permanentError{errors.New("a command poller doesn't push values")}
+}
+
+// SubscribeCommands calls the handler on the commands of the device
+func (p *CommandPoller) SubscribeCommands(device string, handler CommandHandler) error {
+	if p.api == "" {
+		return errors.New("api is required")
+	}
+	p.commands.set(device, handler)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.polling {
+		return nil
+	}
+	select {
+	case <-p.quit:
+		return errors.New("poller is closed")
+	default:
+	}
+	p.polling = true
+	go p.poll()
+	return nil
+}
+
+// Close stops polling the commands
+func (p *CommandPoller) Close() error {
+	p.mu.Lock()
+	p.closeOnce.Do(func() {
+		close(p.quit)
+	})
+	polling := p.polling
+	p.mu.Unlock()
+	if polling {
+		<-p.done
+	}
+	return nil
+}
+
+func (p *CommandPoller) poll() {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.quit:
+			return
+		case <-ticker.C:
+		}
+		// only the first failure is logged, the server might be down for hours
+		cmds, err := p.pollCommands()
+		if err != nil {
+			if !p.failing {
+				log.Printf("[%v]failed to poll the commands, error: %v", logTagPoller, err)
+			}
+			p.failing = true
+			continue
+		}
+		if p.failing {
+			log.Printf("[%v]polling the commands recovered", logTagPoller)
+			p.failing = false
+		}
+		for _, cmd := range cmds {
+			p.handleCommand(cmd)
+		}
+	}
+}
+
+// pollCommands gets the new commands
+func (p *CommandPoller) pollCommands() ([]*Command, error) {
+	var cmds []*Command
+	if err := p.request("GET", p.api, nil, &cmds); err != nil {
+		return nil, err
+	}
+	return cmds, nil
+}
+
+func (p *CommandPoller) handleCommand(cmd *Command) {
+	if cmd == nil || cmd.ID == "" {
+		log.Printf("[%v]skip a command without id", logTagPoller)
+		return
+	}
+	report := func(r *CommandResult) error {
+		data, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		return p.request("POST", fmt.Sprintf("%v/%v/resp", p.api, url.PathEscape(cmd.ID)), data, nil)
+	}
+
+	var err error
+	switch {
+	case cmd.Name == "":
+		err = errors.New("name of the command is required")
+	case cmd.Device == "":
+		err = errors.New("device of the command is required")
+	}
+	if err != nil {
+		log.Printf("[%v]invalid command %v, error: %v", logTagPoller, cmd.ID, err)
+		if err := report(newCommandResult(cmd, CommandFailed, nil, err)); err != nil {
+			log.Printf("[%v]failed to report the result of command %v, error: %v", logTagPoller, cmd.ID, err)
+		}
+		return
+	}
+	p.commands.handle(cmd, report)
+}
+
+// request requests the api, and decodes the response to v if it isn't nil
+func (p *CommandPoller) request(method, api string, body []byte, v interface{}) error {
+	req, err := http.NewRequest(method, api, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{
+		Timeout: pollerTimeout,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode the response, error: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package iot
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Factory creates a cloud from its config,
+// get returns the other clouds by their names in the config file for the clouds wrapping them.
+type Factory func(cfg interface{}, get func(name string) (Cloud, error)) (Cloud, error)
+
+// registration is a kind of cloud
+type registration struct {
+	kind      string
+	newConfig func() interface{}
+	factory   Factory
+}
+
+// validator is a config which can be validated, the errors are shown to the users with the name of the cloud
+type validator interface {
+	Validate() error
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]*registration{}
+)
+
+func init() {
+	Register("wsn", func() interface{} { return &WsnConfig{} }, func(cfg interface{}, _ func(string) (Cloud, error)) (Cloud, error) {
+		c := *cfg.(*WsnConfig)
+		if c.API == "" {
+			c.API = WsnNumericalAPI
+		}
+		return NewWsnClound(&c), nil
+	})
+	Register("onenet", func() interface{} { return &OneNetConfig{} }, func(cfg interface{}, _ func(string) (Cloud, error)) (Cloud, error) {
+		return NewOneNetCloud(cfg.(*OneNetConfig)), nil
+	})
+	Register("mqtt", func() interface{} { return &MQTTConfig{} }, func(cfg interface{}, _ func(string) (Cloud, error)) (Cloud, error) {
+		return NewMQTTCloud(cfg.(*MQTTConfig)), nil
+	})
+	Register("influx", func() interface{} { return &InfluxConfig{} }, func(cfg interface{}, _ func(string) (Cloud, error)) (Cloud, error) {
+		return NewInfluxCloud(cfg.(*InfluxConfig)), nil
+	})
+	Register("metrics", func() interface{} { return &MetricsConfig{} }, func(cfg interface{}, _ func(string) (Cloud, error)) (Cloud, error) {
+		return NewMetricsCloud(nil, cfg.(*MetricsConfig)), nil
+	})
+	Register("multi", func() interface{} { return &MultiConfig{} }, newMultiCloudFromConfig)
+	Register("poller", func() interface{} { return &CommandPollerConfig{} }, func(cfg interface{}, _ func(string) (Cloud, error)) (Cloud, error) {
+		return NewCommandPoller(cfg.(*CommandPollerConfig)), nil
+	})
+}
+
+// Register registers a kind of cloud, so the clouds of the kind can be created from the config file.
+// newConfig returns a pointer to an empty config, it's decoded from the json of a cloud,
+// and validated if it has a method Validate() error.
+// it panics if the kind is registered twice.
+func Register(kind string, newConfig func() interface{}, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[kind]; ok {
+		panic(fmt.Sprintf("iot: cloud %v is registered twice", kind))
+	}
+	registry[kind] = &registration{
+		kind:      kind,
+		newConfig: newConfig,
+		factory:   factory,
+	}
+}
+
+// Kinds returns the registered kinds of clouds
+func Kinds() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	kinds := make([]string, 0, len(registry))
+	for k := range registry {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+func lookupKind(kind string) *registration {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return registry[kind]
+}
+
+// lookupConfig finds the kind of a config by its type
+func lookupConfig(cfg interface{}) *registration {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	t := reflect.TypeOf(cfg)
+	for _, r := range registry {
+		if reflect.TypeOf(r.newConfig()) == t {
+			return r
+		}
+	}
+	return nil
+}
+
+// noClouds is the get of the clouds created without a config file
+func noClouds(name string) (Cloud, error) {
+	return nil, fmt.Errorf("no cloud %q", name)
+}
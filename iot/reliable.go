@@ -0,0 +1,560 @@
+package iot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shanghuiyang/rpi-devices/util"
+	"github.com/shanghuiyang/rpi-devices/util/metrics"
+)
+
+const (
+	logTagReliable = "reliable"
+
+	defaultMaxSize    = 10000
+	defaultBatchSize  = 50
+	defaultMinBackoff = 5 * time.Second
+	defaultMaxBackoff = 10 * time.Minute
+	defaultCompactAt  = 1 << 20
+
+	// headExt is the extension of the file which keeps the offset of the head of the queue
+	headExt = ".head"
+)
+
+// the metrics of the ReliableClouds, labeled by the names of the queues
+var (
+	reliableQueued = metrics.NewGauge("iot_queue_values",
+		"The number of the values waiting in the queue of a reliable cloud.", "queue")
+	reliableSent = metrics.NewCounter("iot_queue_sent_total",
+		"The number of the values pushed successfully by a reliable cloud.", "queue")
+	reliableDropped = metrics.NewCounter("iot_queue_dropped_total",
+		"The number of the values dropped because the queue was full or the cloud rejected them.", "queue")
+	reliableFailed = metrics.NewCounter("iot_queue_failed_pushes_total",
+		"The number of the failed pushes of a reliable cloud, which are retried.", "queue")
+)
+
+// ReliableConfig ...
+type ReliableConfig struct {
+	// Name is the label of the queue in the metrics, the default is the base name of File, or "memory"
+	Name string `json:"name"`
+	// File is where the queue is persisted, the values are only queued in memory if it's empty.
+	// the values are appended to the file, and the offset of the head of the queue is kept in File+".head".
+	File string `json:"file"`
+	// CompactAt is the size in bytes of the pushed values at the beginning of the file
+	// to compact the file at, the default is 1MB. the file is truncated anyway once the queue is empty.
+	CompactAt int64 `json:"compact_at"`
+	// MaxSize is the max number of the queued values, the oldest ones are dropped when it's full
+	MaxSize int `json:"max_size"`
+	// BatchSize is the max number of the values in a push if the cloud is a BatchCloud
+	BatchSize int `json:"batch_size"`
+	// MinBackoff and MaxBackoff are the range of the exponential backoff of retrying
+	MinBackoff time.Duration `json:"min_backoff"`
+	MaxBackoff time.Duration `json:"max_backoff"`
+}
+
+// Stats are the metrics of a ReliableCloud
+type Stats struct {
+	// Queued is the number of the values in the queue
+	Queued int
+	// Sent is the number of the values pushed successfully
+	Sent uint64
+	// Dropped is the number of the values dropped because the queue is full or the cloud rejected them
+	Dropped uint64
+	// Failed is the number of the failed pushes which will be retried
+	Failed uint64
+}
+
+// ReliableCloud wraps a cloud for reliable delivery:
+// Push() queues the values without blocking, and they're pushed in the background.
+// the queue is persisted to a file, so the values survive the network outages and restarts.
+// a failed push is retried with exponential backoff, unless the cloud rejects the values.
+// the stats are exported by the metrics package, see Stats().
+type ReliableCloud struct {
+	// the 64-bit fields accessed atomically are kept at the beginning for the alignment on 32-bit arm
+	sent    uint64
+	dropped uint64
+	failed  uint64
+
+	cloud Cloud
+	cfg   ReliableConfig
+
+	mu    sync.Mutex
+	queue []*queuedValue
+	seq   uint64
+	// head is the offset in the file of the first value in the queue,
+	// the values before it have been pushed or dropped.
+	head int64
+
+	wake chan struct{}
+	quit chan struct{}
+	done chan struct{}
+}
+
+// queuedValue is a value in the queue, it's persisted as a line of json
+type queuedValue struct {
+	seq   uint64
+	value *Value
+	// end is the offset in the file right after the line of the value
+	end int64
+}
+
+// NewReliableCloud wraps the cloud, and loads the values queued in the file.
+// Close() must be called to stop it.
+func NewReliableCloud(cloud Cloud, cfg *ReliableConfig) (*ReliableCloud, error) {
+	if cloud == nil {
+		return nil, errors.New("cloud is nil")
+	}
+	r := &ReliableCloud{
+		cloud: cloud,
+		wake:  make(chan struct{}, 1),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	if cfg != nil {
+		r.cfg = *cfg
+	}
+	if r.cfg.MaxSize <= 0 {
+		r.cfg.MaxSize = defaultMaxSize
+	}
+	if r.cfg.BatchSize <= 0 {
+		r.cfg.BatchSize = defaultBatchSize
+	}
+	if r.cfg.MinBackoff <= 0 {
+		r.cfg.MinBackoff = defaultMinBackoff
+	}
+	if r.cfg.MaxBackoff < r.cfg.MinBackoff {
+		r.cfg.MaxBackoff = defaultMaxBackoff
+	}
+	if r.cfg.CompactAt <= 0 {
+		r.cfg.CompactAt = defaultCompactAt
+	}
+	if r.cfg.Name == "" {
+		r.cfg.Name = "memory"
+		if r.cfg.File != "" {
+			r.cfg.Name = filepath.Base(r.cfg.File)
+		}
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	reliableQueued.Set(float64(len(r.queue)), r.cfg.Name)
+	if len(r.queue) > 0 {
+		log.Printf("[%v]loaded %v values from %v", logTagReliable, len(r.queue), r.cfg.File)
+	}
+	go r.run()
+	r.notify()
+	return r, nil
+}
+
+// Push queues a value, it returns an error if the value can't be persisted,
+// but the value is still queued in memory.
+// the value is stamped with the current time if its time is zero,
+// so it lands at the right time even if it's pushed late.
+func (r *ReliableCloud) Push(v *Value) error {
+	return r.PushBatch([]*Value{v})
+}
+
+// PushBatch queues the values, see Push()
+func (r *ReliableCloud) PushBatch(vs []*Value) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var err error
+	for _, v := range vs {
+		if v.Time.IsZero() {
+			stamped := *v
+			stamped.Time = now
+			v = &stamped
+		}
+		r.seq++
+		end, e := r.append(v)
+		if e != nil {
+			err = e
+		}
+		r.queue = append(r.queue, &queuedValue{seq: r.seq, value: v, end: end})
+	}
+	if len(r.queue) > r.cfg.MaxSize {
+		n := len(r.queue) - r.cfg.MaxSize
+		r.drop(n)
+		r.addDropped(n)
+		log.Printf("[%v]queue is full, dropped %v values", logTagReliable, n)
+	}
+	reliableQueued.Set(float64(len(r.queue)), r.cfg.Name)
+	r.notify()
+	return err
+}
+
+// Stats returns the stats of the cloud, they're exported by the metrics package as well:
+// iot_queue_values, iot_queue_sent_total, iot_queue_dropped_total and iot_queue_failed_pushes_total.
+func (r *ReliableCloud) Stats() *Stats {
+	r.mu.Lock()
+	queued := len(r.queue)
+	r.mu.Unlock()
+	return &Stats{
+		Queued:  queued,
+		Sent:    atomic.LoadUint64(&r.sent),
+		Dropped: atomic.LoadUint64(&r.dropped),
+		Failed:  atomic.LoadUint64(&r.failed),
+	}
+}
+
+// Close stops pushing, the values in the queue are kept in the file
+func (r *ReliableCloud) Close() error {
+	close(r.quit)
+	<-r.done
+	return nil
+}
+
+func (r *ReliableCloud) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *ReliableCloud) run() {
+	defer close(r.done)
+	backoff := r.cfg.MinBackoff
+	for {
+		batch := r.peek()
+		if len(batch) == 0 {
+			select {
+			case <-r.quit:
+				return
+			case <-r.wake:
+			}
+			continue
+		}
+
+		err := r.push(batch)
+		if err == nil {
+			r.ack(batch)
+			atomic.AddUint64(&r.sent, uint64(len(batch)))
+			reliableSent.Add(float64(len(batch)), r.cfg.Name)
+			backoff = r.cfg.MinBackoff
+			continue
+		}
+		if !isTemporary(err) {
+			log.Printf("[%v]the cloud rejected %v values, dropped them, error: %v", logTagReliable, len(batch), err)
+			r.ack(batch)
+			r.addDropped(len(batch))
+			continue
+		}
+
+		atomic.AddUint64(&r.failed, 1)
+		reliableFailed.Inc(r.cfg.Name)
+		log.Printf("[%v]failed to push %v values, retry in %v, error: %v", logTagReliable, len(batch), backoff, err)
+		select {
+		case <-r.quit:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > r.cfg.MaxBackoff {
+			backoff = r.cfg.MaxBackoff
+		}
+	}
+}
+
+// peek returns the values at the head of the queue
+func (r *ReliableCloud) peek() []*queuedValue {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 1
+	if _, ok := r.cloud.(BatchCloud); ok {
+		n = r.cfg.BatchSize
+	}
+	if n > len(r.queue) {
+		n = len(r.queue)
+	}
+	batch := make([]*queuedValue, n)
+	copy(batch, r.queue)
+	return batch
+}
+
+func (r *ReliableCloud) push(batch []*queuedValue) error {
+	if bc, ok := r.cloud.(BatchCloud); ok {
+		vs := make([]*Value, len(batch))
+		for i, q := range batch {
+			vs[i] = q.value
+		}
+		return bc.PushBatch(vs)
+	}
+	return r.cloud.Push(batch[0].value)
+}
+
+// ack removes the batch from the queue,
+// some of them might have been dropped from the queue when it was full.
+func (r *ReliableCloud) ack(batch []*queuedValue) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	last := batch[len(batch)-1].seq
+	i := 0
+	for i < len(r.queue) && r.queue[i].seq <= last {
+		i++
+	}
+	r.drop(i)
+	reliableQueued.Set(float64(len(r.queue)), r.cfg.Name)
+}
+
+func (r *ReliableCloud) addDropped(n int) {
+	atomic.AddUint64(&r.dropped, uint64(n))
+	reliableDropped.Add(float64(n), r.cfg.Name)
+}
+
+// drop removes the first n values from the queue, and moves the head of the file after them.
+// r.mu must be held.
+func (r *ReliableCloud) drop(n int) {
+	if n <= 0 {
+		return
+	}
+	end := r.queue[n-1].end
+	r.queue = r.queue[n:]
+	if r.cfg.File == "" {
+		return
+	}
+
+	var err error
+	switch {
+	case len(r.queue) == 0:
+		// the common case when the cloud is up, nothing needs to be rewritten
+		if err = os.Truncate(r.cfg.File, 0); err == nil || os.IsNotExist(err) {
+			err = r.setHead(0)
+		}
+	case end >= r.cfg.CompactAt:
+		err = r.compact()
+	default:
+		err = r.setHead(end)
+	}
+	if err != nil {
+		log.Printf("[%v]failed to save the queue, error: %v", logTagReliable, err)
+	}
+}
+
+// persistedValue is the format of a value in the file
+type persistedValue struct {
+	Device string          `json:"device"`
+	Type   string          `json:"type,omitempty"`
+	Value  json.RawMessage `json:"value"`
+	Time   time.Time       `json:"time"`
+}
+
+func encodeValue(v *Value) ([]byte, error) {
+	data, err := json.Marshal(v.Value)
+	if err != nil {
+		return nil, err
+	}
+	p := &persistedValue{
+		Device: v.Device,
+		Value:  data,
+		Time:   v.Time,
+	}
+	if _, ok := v.Value.(*util.Point); ok {
+		p.Type = "point"
+	}
+	return json.Marshal(p)
+}
+
+func decodeValue(line []byte) (*Value, error) {
+	var p persistedValue
+	if err := json.Unmarshal(line, &p); err != nil {
+		return nil, err
+	}
+	v := &Value{Device: p.Device, Time: p.Time}
+	switch p.Type {
+	case "point":
+		pt := &util.Point{}
+		if err := json.Unmarshal(p.Value, pt); err != nil {
+			return nil, err
+		}
+		v.Value = pt
+	default:
+		if err := json.Unmarshal(p.Value, &v.Value); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// load loads the queue from the file after the head
+func (r *ReliableCloud) load() error {
+	if r.cfg.File == "" {
+		return nil
+	}
+	f, err := os.OpenFile(r.cfg.File, os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	head, err := r.readHead()
+	if err != nil {
+		return err
+	}
+	if head > info.Size() {
+		// the file was truncated but the head wasn't saved
+		head = 0
+	}
+	if _, err := f.Seek(head, io.SeekStart); err != nil {
+		return err
+	}
+	r.head = head
+
+	offset := head
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				// the last line was partly written when the power was off
+				log.Printf("[%v]skip a broken value at the end of %v", logTagReliable, r.cfg.File)
+				if err := f.Truncate(offset); err != nil {
+					return err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load %v, error: %v", r.cfg.File, err)
+		}
+		offset += int64(len(line))
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		v, err := decodeValue(line)
+		if err != nil {
+			log.Printf("[%v]skip a broken value in %v, error: %v", logTagReliable, r.cfg.File, err)
+			continue
+		}
+		r.seq++
+		r.queue = append(r.queue, &queuedValue{seq: r.seq, value: v, end: offset})
+	}
+	if len(r.queue) > r.cfg.MaxSize {
+		r.drop(len(r.queue) - r.cfg.MaxSize)
+	}
+	return nil
+}
+
+// append appends a value to the file, and returns the offset after it. r.mu must be held
+func (r *ReliableCloud) append(v *Value) (int64, error) {
+	if r.cfg.File == "" {
+		return 0, nil
+	}
+	line, err := encodeValue(v)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(r.cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return 0, err
+	}
+	return f.Seek(0, io.SeekCurrent)
+}
+
+// compact rewrites the file with the values in the queue, r.mu must be held.
+// the head is reset before replacing the file, so a power loss in between
+// pushes some values again rather than losing them.
+func (r *ReliableCloud) compact() error {
+	var buf bytes.Buffer
+	var queue []*queuedValue
+	for _, q := range r.queue {
+		line, err := encodeValue(q.value)
+		if err != nil {
+			log.Printf("[%v]failed to encode a value of %v, error: %v", logTagReliable, q.value.Device, err)
+			continue
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+		q.end = int64(buf.Len())
+		queue = append(queue, q)
+	}
+	tmp := r.cfg.File + ".tmp"
+	if err := writeFile(tmp, buf.Bytes()); err != nil {
+		return err
+	}
+	if err := r.setHead(0); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, r.cfg.File); err != nil {
+		return err
+	}
+	r.queue = queue
+	return nil
+}
+
+// readHead reads the offset of the head, it's 0 if the head file doesn't exist
+func (r *ReliableCloud) readHead() (int64, error) {
+	data, err := ioutil.ReadFile(r.cfg.File + headExt)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	head, err := strconv.ParseInt(string(bytes.TrimSpace(data)), 10, 64)
+	if err != nil || head < 0 {
+		log.Printf("[%v]invalid head in %v, load the queue from the beginning", logTagReliable, r.cfg.File+headExt)
+		return 0, nil
+	}
+	return head, nil
+}
+
+// setHead saves the offset of the head, r.mu must be held.
+// it isn't synced, a lost head only makes the pushed values pushed again.
+func (r *ReliableCloud) setHead(head int64) error {
+	r.head = head
+	file := r.cfg.File + headExt
+	if head == 0 {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strconv.FormatInt(head, 10)), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// writeFile writes and syncs a file
+func writeFile(file string, data []byte) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,184 @@
+package iot
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shanghuiyang/rpi-devices/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockCloud fails the first n pushes with err
+type mockCloud struct {
+	mu     sync.Mutex
+	n      int
+	err    error
+	values []*Value
+	pushes int
+}
+
+func (m *mockCloud) Push(v *Value) error {
+	return m.push([]*Value{v})
+}
+
+func (m *mockCloud) push(vs []*Value) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pushes++
+	if m.n > 0 {
+		m.n--
+		return m.err
+	}
+	m.values = append(m.values, vs...)
+	return nil
+}
+
+func (m *mockCloud) get() []*Value {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values
+}
+
+// mockBatchCloud is a mockCloud which supports batching
+type mockBatchCloud struct {
+	*mockCloud
+}
+
+func (m *mockBatchCloud) PushBatch(vs []*Value) error {
+	return m.push(vs)
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("timeout")
+}
+
+func TestReliableCloudRetry(t *testing.T) {
+	cloud := &mockCloud{n: 2, err: errors.New("network is unreachable")}
+	r, err := NewReliableCloud(cloud, &ReliableConfig{MinBackoff: 10 * time.Millisecond})
+	assert.NoError(t, err)
+	defer r.Close()
+
+	v := &Value{Device: "temp", Value: 21.5}
+	assert.NoError(t, r.Push(v))
+	assert.NoError(t, r.Push(&Value{Device: "temp", Value: 22.5}))
+	waitFor(t, func() bool { return len(cloud.get()) == 2 })
+	assert.Equal(t, 22.5, cloud.get()[1].Value)
+	// the values are stamped when they are queued
+	assert.True(t, v.Time.IsZero())
+	assert.False(t, cloud.get()[0].Time.IsZero())
+
+	s := r.Stats()
+	assert.Equal(t, 0, s.Queued)
+	assert.Equal(t, uint64(2), s.Sent)
+	assert.Equal(t, uint64(2), s.Failed)
+	assert.Equal(t, uint64(0), s.Dropped)
+}
+
+func TestReliableCloudRejected(t *testing.T) {
+	cloud := &mockCloud{n: 1, err: &APIError{Status: 401, Message: "invalid token"}}
+	r, err := NewReliableCloud(cloud, nil)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	r.Push(&Value{Device: "temp", Value: 1})
+	r.Push(&Value{Device: "temp", Value: 2})
+	waitFor(t, func() bool { return len(cloud.get()) == 1 })
+	s := r.Stats()
+	assert.Equal(t, uint64(1), s.Dropped)
+	assert.Equal(t, uint64(1), s.Sent)
+	assert.Equal(t, uint64(0), s.Failed)
+}
+
+func TestReliableCloudPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iot")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "queue.json")
+
+	// the cloud is down
+	down := &mockCloud{n: 1000, err: errors.New("timeout")}
+	r, err := NewReliableCloud(down, &ReliableConfig{File: file, MaxSize: 3, MinBackoff: time.Hour})
+	assert.NoError(t, err)
+	for i := 1; i <= 4; i++ {
+		assert.NoError(t, r.Push(&Value{Device: "pm25", Value: i}))
+	}
+	assert.NoError(t, r.Push(&Value{Device: "gps", Value: &util.Point{Lat: 31.2, Lon: 121.5}}))
+	assert.NoError(t, r.Close())
+	s := r.Stats()
+	assert.Equal(t, 3, s.Queued)
+	assert.Equal(t, uint64(2), s.Dropped)
+
+	// restart with the cloud up
+	up := &mockBatchCloud{&mockCloud{}}
+	r, err = NewReliableCloud(up, &ReliableConfig{File: file, MaxSize: 3})
+	assert.NoError(t, err)
+	defer r.Close()
+	waitFor(t, func() bool { return len(up.get()) == 3 })
+	assert.Equal(t, 1, up.pushes)
+	vs := up.get()
+	assert.Equal(t, "pm25", vs[0].Device)
+	assert.Equal(t, 3.0, vs[0].Value)
+	assert.Equal(t, &util.Point{Lat: 31.2, Lon: 121.5}, vs[2].Value)
+	assert.False(t, vs[2].Time.IsZero())
+
+	waitFor(t, func() bool { return r.Stats().Queued == 0 })
+	data, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Empty(t, data)
+}
+
+func TestReliableCloudCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "iot")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "queue.json")
+
+	down := &mockCloud{n: 1000, err: errors.New("timeout")}
+	r, err := NewReliableCloud(down, &ReliableConfig{File: file, MaxSize: 2, CompactAt: 500, MinBackoff: time.Hour})
+	assert.NoError(t, err)
+	assert.NoError(t, r.Push(&Value{Device: "pm25", Value: 1}))
+	assert.NoError(t, r.Push(&Value{Device: "pm25", Value: 2}))
+	assert.NoError(t, r.Push(&Value{Device: "pm25", Value: 3}))
+	// the dropped value is skipped by the head rather than rewriting the file
+	head, err := ioutil.ReadFile(file + ".head")
+	assert.NoError(t, err)
+	assert.NotEqual(t, "0", string(head))
+
+	for i := 4; i <= 100; i++ {
+		assert.NoError(t, r.Push(&Value{Device: "pm25", Value: i}))
+	}
+	assert.NoError(t, r.Close())
+	info, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.True(t, info.Size() < 1000)
+
+	// a value partly written at the end is skipped
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	f.Write([]byte(`{"device": "pm25", "val`))
+	f.Close()
+
+	up := &mockBatchCloud{&mockCloud{}}
+	r, err = NewReliableCloud(up, &ReliableConfig{File: file, MaxSize: 2})
+	assert.NoError(t, err)
+	defer r.Close()
+	waitFor(t, func() bool { return len(up.get()) == 2 })
+	vs := up.get()
+	assert.Equal(t, 99.0, vs[0].Value)
+	assert.Equal(t, 100.0, vs[1].Value)
+
+	waitFor(t, func() bool { return r.Stats().Queued == 0 })
+	_, err = os.Stat(file + ".head")
+	assert.True(t, os.IsNotExist(err))
+}
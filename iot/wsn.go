@@ -5,12 +5,14 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/shanghuiyang/rpi-devices/util"
 )
 
 const (
-	logTagWsn = "wsn"
+	logTagWsn  = "wsn"
+	wsnTimeout = 15 * time.Second
 )
 
 // WsnCloud is the implement of Cloud
@@ -35,7 +37,7 @@ func (w *WsnCloud) Push(v *Value) error {
 		api = strings.Replace(w.api, "numerical", "gps", -1)
 		pt, ok := v.Value.(*util.Point)
 		if !ok {
-			return fmt.Errorf("failed to convert value to point")
+			return &permanentError{fmt.Errorf("failed to convert value to point")}
 		}
 		formData = url.Values{
 			"ak":    {w.token},
@@ -52,10 +54,13 @@ func (w *WsnCloud) Push(v *Value) error {
 		}
 	}
 
-	resp, err := http.PostForm(api, formData)
+	client := &http.Client{
+		Timeout: wsnTimeout,
+	}
+	resp, err := client.PostForm(api, formData)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
-	return nil
+	return checkStatus(resp)
 }
@@ -0,0 +1,41 @@
+package cv
+
+import (
+	"errors"
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+// Camera is a frame source of a video device using OpenCV, see detect.FrameSource
+type Camera struct {
+	cam *gocv.VideoCapture
+	img gocv.Mat
+}
+
+// NewCamera ...
+func NewCamera(device int) (*Camera, error) {
+	cam, err := gocv.OpenVideoCapture(device)
+	if err != nil {
+		return nil, err
+	}
+	return &Camera{
+		cam: cam,
+		img: gocv.NewMat(),
+	}, nil
+}
+
+// Read reads the latest frame
+func (c *Camera) Read() (image.Image, error) {
+	c.cam.Grab(6)
+	if !c.cam.Read(&c.img) || c.img.Empty() {
+		return nil, errors.New("failed to read a frame")
+	}
+	return c.img.ToImage()
+}
+
+// Close ...
+func (c *Camera) Close() error {
+	c.img.Close()
+	return c.cam.Close()
+}
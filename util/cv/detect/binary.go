@@ -0,0 +1,279 @@
+package detect
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Mask is a binary image, true for foreground pixels
+type Mask struct {
+	W   int
+	H   int
+	Pix []bool
+}
+
+// NewMask ...
+func NewMask(w, h int) *Mask {
+	return &Mask{
+		W:   w,
+		H:   h,
+		Pix: make([]bool, w*h),
+	}
+}
+
+// At ...
+func (m *Mask) At(x, y int) bool {
+	if x < 0 || y < 0 || x >= m.W || y >= m.H {
+		return false
+	}
+	return m.Pix[y*m.W+x]
+}
+
+// Set ...
+func (m *Mask) Set(x, y int, v bool) {
+	m.Pix[y*m.W+x] = v
+}
+
+// Count returns the number of foreground pixels
+func (m *Mask) Count() int {
+	n := 0
+	for _, p := range m.Pix {
+		if p {
+			n++
+		}
+	}
+	return n
+}
+
+// Erode erodes the mask with a (2r+1)x(2r+1) square kernel
+func (m *Mask) Erode(r int) *Mask {
+	return m.morph(r, true)
+}
+
+// Dilate dilates the mask with a (2r+1)x(2r+1) square kernel
+func (m *Mask) Dilate(r int) *Mask {
+	return m.morph(r, false)
+}
+
+// morph is separable, a row pass followed by a column pass
+func (m *Mask) morph(r int, erode bool) *Mask {
+	if r <= 0 {
+		return m
+	}
+	pass := func(src *Mask, dx, dy int) *Mask {
+		dst := NewMask(src.W, src.H)
+		for y := 0; y < src.H; y++ {
+			for x := 0; x < src.W; x++ {
+				v := erode
+				for k := -r; k <= r; k++ {
+					xx, yy := x+k*dx, y+k*dy
+					if xx < 0 || yy < 0 || xx >= src.W || yy >= src.H {
+						continue
+					}
+					p := src.Pix[yy*src.W+xx]
+					if erode && !p {
+						v = false
+						break
+					}
+					if !erode && p {
+						v = true
+						break
+					}
+				}
+				dst.Pix[y*dst.W+x] = v
+			}
+		}
+		return dst
+	}
+	return pass(pass(m, 1, 0), 0, 1)
+}
+
+// Image converts the mask to a gray image, white for foreground
+func (m *Mask) Image() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, m.W, m.H))
+	for i, p := range m.Pix {
+		if p {
+			img.Pix[i] = 0xFF
+		}
+	}
+	return img
+}
+
+// Edges returns the outline of the mask, the set pixels next to an unset pixel or the border
+func (m *Mask) Edges() *Mask {
+	edges := NewMask(m.W, m.H)
+	for y := 0; y < m.H; y++ {
+		for x := 0; x < m.W; x++ {
+			if !m.At(x, y) {
+				continue
+			}
+			if !m.At(x-1, y) || !m.At(x+1, y) || !m.At(x, y-1) || !m.At(x, y+1) {
+				edges.Set(x, y, true)
+			}
+		}
+	}
+	return edges
+}
+
+// Component is a connected component of a mask
+type Component struct {
+	Area   int
+	Bounds image.Rectangle
+}
+
+// Components labels the 8-connected components of the mask
+func (m *Mask) Components() []*Component {
+	labels := make([]int32, len(m.Pix))
+	var comps []*Component
+	stack := make([]int, 0, 64)
+	for i, p := range m.Pix {
+		if !p || labels[i] != 0 {
+			continue
+		}
+		label := int32(len(comps) + 1)
+		c := &Component{
+			// not image.Rect() which swaps min & max
+			Bounds: image.Rectangle{Min: image.Point{X: m.W, Y: m.H}},
+		}
+		labels[i] = label
+		stack = append(stack[:0], i)
+		for len(stack) > 0 {
+			j := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := j%m.W, j/m.W
+			c.Area++
+			if x < c.Bounds.Min.X {
+				c.Bounds.Min.X = x
+			}
+			if y < c.Bounds.Min.Y {
+				c.Bounds.Min.Y = y
+			}
+			if x+1 > c.Bounds.Max.X {
+				c.Bounds.Max.X = x + 1
+			}
+			if y+1 > c.Bounds.Max.Y {
+				c.Bounds.Max.Y = y + 1
+			}
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					xx, yy := x+dx, y+dy
+					if xx < 0 || yy < 0 || xx >= m.W || yy >= m.H {
+						continue
+					}
+					k := yy*m.W + xx
+					if m.Pix[k] && labels[k] == 0 {
+						labels[k] = label
+						stack = append(stack, k)
+					}
+				}
+			}
+		}
+		comps = append(comps, c)
+	}
+	return comps
+}
+
+// HSV is a color in the hsv space of OpenCV for 8-bit images:
+// H in [0, 180), S and V in [0, 255]
+type HSV struct {
+	H float64
+	S float64
+	V float64
+}
+
+// ToHSV converts a color to hsv
+func ToHSV(c color.Color) HSV {
+	r16, g16, b16, _ := c.RGBA()
+	r, g, b := float64(r16>>8), float64(g16>>8), float64(b16>>8)
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	d := max - min
+
+	var h float64
+	switch {
+	case d == 0:
+		h = 0
+	case max == r:
+		h = 60 * math.Mod((g-b)/d, 6)
+	case max == g:
+		h = 60 * ((b-r)/d + 2)
+	default:
+		h = 60 * ((r-g)/d + 4)
+	}
+	if h < 0 {
+		h += 360
+	}
+	s := 0.0
+	if max > 0 {
+		s = d / max * 255
+	}
+	return HSV{H: h / 2, S: s, V: max}
+}
+
+// Resize resizes the image to fit in maxWidth using the nearest neighbour,
+// and returns the scale from the resized image to the original one.
+// the image won't be resized if it is smaller than maxWidth.
+func Resize(img image.Image, maxWidth int) (*image.RGBA, float64) {
+	b := img.Bounds()
+	scale := 1.0
+	w, h := b.Dx(), b.Dy()
+	if maxWidth > 0 && w > maxWidth {
+		scale = float64(w) / float64(maxWidth)
+		w = maxWidth
+		h = int(float64(h)/scale + 0.5)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		sy := b.Min.Y + int(float64(y)*scale)
+		for x := 0; x < w; x++ {
+			sx := b.Min.X + int(float64(x)*scale)
+			dst.Set(x, y, img.At(sx, sy))
+		}
+	}
+	return dst, scale
+}
+
+// BoxBlur blurs the image with a (2r+1)x(2r+1) box kernel
+func BoxBlur(img *image.RGBA, r int) *image.RGBA {
+	if r <= 0 {
+		return img
+	}
+	pass := func(src *image.RGBA, dx, dy int) *image.RGBA {
+		b := src.Rect
+		dst := image.NewRGBA(b)
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				var sum [4]int
+				n := 0
+				for k := -r; k <= r; k++ {
+					p := image.Point{X: x + k*dx, Y: y + k*dy}
+					if !p.In(b) {
+						continue
+					}
+					i := src.PixOffset(p.X, p.Y)
+					for c := 0; c < 4; c++ {
+						sum[c] += int(src.Pix[i+c])
+					}
+					n++
+				}
+				i := dst.PixOffset(x, y)
+				for c := 0; c < 4; c++ {
+					dst.Pix[i+c] = uint8(sum[c] / n)
+				}
+			}
+		}
+		return dst
+	}
+	return pass(pass(img, 1, 0), 0, 1)
+}
+
+// ScaleRect scales a rect from a resized image back to the original one
+func ScaleRect(r image.Rectangle, scale float64, origin image.Point) image.Rectangle {
+	return image.Rect(
+		int(float64(r.Min.X)*scale),
+		int(float64(r.Min.Y)*scale),
+		int(math.Ceil(float64(r.Max.X)*scale)),
+		int(math.Ceil(float64(r.Max.Y)*scale)),
+	).Add(origin)
+}
@@ -0,0 +1,323 @@
+package detect
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Calibrator is a web tool for tuning the hsv profile of ColorDetector.
+// it shows the live frame with the contours of the detected targets, and the thresholded mask,
+// and saves the profile to a file which can be loaded by LoadColorConfig().
+// only the first profile in the config is tuned, the others are kept as they are.
+type Calibrator struct {
+	src  FrameSource
+	file string
+	mux  *http.ServeMux
+
+	mu    sync.Mutex
+	cfg   *ColorConfig
+	frame image.Image
+}
+
+// NewCalibrator creates a calibrator which saves the profile to file,
+// the profile will be loaded from the file if it exists.
+func NewCalibrator(src FrameSource, file string) (*Calibrator, error) {
+	if src == nil {
+		return nil, errors.New("frame source is nil")
+	}
+	cfg, err := LoadColorConfig(file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		cfg = &ColorConfig{}
+		*cfg = *DefaultColorConfig
+		p := *DefaultColorConfig.Profiles[0]
+		cfg.Profiles = []*HSVProfile{&p}
+	}
+	if len(cfg.Profiles) == 0 {
+		return nil, errors.New("no color profiles")
+	}
+
+	c := &Calibrator{
+		src:  src,
+		file: file,
+		cfg:  cfg,
+		mux:  http.NewServeMux(),
+	}
+	c.mux.HandleFunc("/", c.page)
+	c.mux.HandleFunc("/frame.jpg", c.frameJPG)
+	c.mux.HandleFunc("/mask.jpg", c.maskJPG)
+	c.mux.HandleFunc("/profile", c.profile)
+	c.mux.HandleFunc("/save", c.save)
+	return c, nil
+}
+
+// ServeHTTP ...
+func (c *Calibrator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mux.ServeHTTP(w, r)
+}
+
+func (c *Calibrator) page(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(calibratorPage))
+}
+
+// frameJPG reads a new frame, and draws the contours of the detected targets on it
+func (c *Calibrator) frameJPG(w http.ResponseWriter, r *http.Request) {
+	img, err := c.src.Read()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	c.mu.Lock()
+	c.frame = img
+	cfg := c.config()
+	c.mu.Unlock()
+	det, err := NewColorDetector(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	boxes, err := det.Detect(img)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	canvas := image.NewRGBA(img.Bounds())
+	draw.Draw(canvas, canvas.Rect, img, img.Bounds().Min, draw.Src)
+	// outline the contours of the masks, so that the shapes of the false positives can be seen.
+	// red for the best target, yellow for the others
+	var best image.Rectangle
+	if len(boxes) > 0 {
+		best = boxes[0].Rect
+	}
+	for _, p := range cfg.Profiles {
+		mask := det.Mask(img, p)
+		scale := float64(img.Bounds().Dx()) / float64(mask.W)
+		drawEdges(canvas, mask.Edges(), scale, img.Bounds().Min, best)
+	}
+	writeJPEG(w, canvas)
+}
+
+// maskJPG thresholds the last frame
+func (c *Calibrator) maskJPG(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	img := c.frame
+	cfg := c.config()
+	c.mu.Unlock()
+	if img == nil {
+		http.Error(w, "no frame", http.StatusServiceUnavailable)
+		return
+	}
+	det, err := NewColorDetector(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJPEG(w, det.Mask(img, cfg.Profiles[0]).Image())
+}
+
+// profile gets or sets the profile being tuned, the blur and morph
+func (c *Calibrator) profile(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r.Method == http.MethodPost {
+		var t tuning
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p := *c.cfg.Profiles[0]
+		p.Lower, p.Upper, p.MinArea = t.Lower, t.Upper, t.MinArea
+		cfg := *c.cfg
+		cfg.Profiles = append([]*HSVProfile{&p}, c.cfg.Profiles[1:]...)
+		cfg.Blur, cfg.Morph = t.Blur, t.Morph
+		if _, err := NewColorDetector(&cfg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.cfg = &cfg
+	}
+
+	p := c.cfg.Profiles[0]
+	json.NewEncoder(w).Encode(&tuning{
+		Name:    p.Name,
+		Lower:   p.Lower,
+		Upper:   p.Upper,
+		MinArea: p.MinArea,
+		Blur:    c.cfg.Blur,
+		Morph:   c.cfg.Morph,
+	})
+}
+
+func (c *Calibrator) save(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	c.mu.Lock()
+	err := c.cfg.Save(c.file)
+	c.mu.Unlock()
+	if err != nil {
+		log.Printf("[detect]failed to save the profile to %v, error: %v", c.file, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[detect]saved the profile to %v", c.file)
+	w.Write([]byte("ok"))
+}
+
+// config returns the config being tuned, the caller must hold c.mu
+func (c *Calibrator) config() *ColorConfig {
+	cfg := *c.cfg
+	return &cfg
+}
+
+// tuning is the parameters adjusted in the browser
+type tuning struct {
+	Name    string     `json:"name"`
+	Lower   [3]float64 `json:"lower"`
+	Upper   [3]float64 `json:"upper"`
+	MinArea int        `json:"min_area"`
+	Blur    int        `json:"blur"`
+	Morph   int        `json:"morph"`
+}
+
+func writeJPEG(w http.ResponseWriter, img image.Image) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(buf.Bytes())
+}
+
+// drawEdges draws the edges of a mask in the resized image on img,
+// the edges inside best are drawn in red, and the others in yellow.
+func drawEdges(img draw.Image, edges *Mask, scale float64, origin image.Point, best image.Rectangle) {
+	red := image.NewUniform(color.RGBA{R: 0xFF, A: 0xFF})
+	yellow := image.NewUniform(color.RGBA{R: 0xFF, G: 0xFF, A: 0xFF})
+	for y := 0; y < edges.H; y++ {
+		for x := 0; x < edges.W; x++ {
+			if !edges.At(x, y) {
+				continue
+			}
+			r := ScaleRect(image.Rect(x, y, x+1, y+1), scale, origin)
+			clr := yellow
+			if r.In(best) {
+				clr = red
+			}
+			draw.Draw(img, r, clr, image.Point{}, draw.Src)
+		}
+	}
+}
+
+const calibratorPage = `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>HSV Calibration</title>
+    <style>
+        body { font-family: sans-serif; }
+        img { width: 45%; margin: 4px; border: 1px solid #ccc; }
+        .slider { display: flex; align-items: center; margin: 4px 0; }
+        .slider label { width: 90px; }
+        .slider input { width: 300px; }
+        .slider span { width: 50px; text-align: right; }
+    </style>
+</head>
+<body>
+    <h3>HSV Calibration: <span id="name"></span></h3>
+    <div>
+        <img id="frame" src="/frame.jpg">
+        <img id="mask" src="/mask.jpg">
+    </div>
+    <div id="sliders"></div>
+    <button onclick="save()">Save</button> <span id="status"></span>
+
+    <script>
+        var sliders = [
+            {id: "lh", label: "lower H", max: 180},
+            {id: "ls", label: "lower S", max: 255},
+            {id: "lv", label: "lower V", max: 255},
+            {id: "uh", label: "upper H", max: 180},
+            {id: "us", label: "upper S", max: 255},
+            {id: "uv", label: "upper V", max: 255},
+            {id: "blur", label: "blur", max: 10},
+            {id: "morph", label: "morph", max: 10},
+            {id: "minarea", label: "min area", max: 20000}
+        ];
+
+        function val(id) {
+            return parseInt(document.getElementById(id).value);
+        }
+
+        function update() {
+            sliders.forEach(function (s) {
+                document.getElementById(s.id + "-val").innerText = val(s.id);
+            });
+            var p = {
+                lower: [val("lh"), val("ls"), val("lv")],
+                upper: [val("uh"), val("us"), val("uv")],
+                min_area: val("minarea"),
+                blur: val("blur"),
+                morph: val("morph")
+            };
+            fetch("/profile", {method: "POST", body: JSON.stringify(p)})
+                .then(function (r) { return r.text(); })
+                .then(function (t) { document.getElementById("status").innerText = ""; });
+        }
+
+        function save() {
+            fetch("/save", {method: "POST"})
+                .then(function (r) { return r.text(); })
+                .then(function (t) { document.getElementById("status").innerText = t == "ok" ? "saved" : t; });
+        }
+
+        function refresh() {
+            var t = new Date().getTime();
+            var frame = document.getElementById("frame");
+            frame.onload = function () {
+                document.getElementById("mask").src = "/mask.jpg?t=" + t;
+            };
+            frame.src = "/frame.jpg?t=" + t;
+        }
+
+        fetch("/profile").then(function (r) { return r.json(); }).then(function (p) {
+            var values = {
+                lh: p.lower[0], ls: p.lower[1], lv: p.lower[2],
+                uh: p.upper[0], us: p.upper[1], uv: p.upper[2],
+                blur: p.blur, morph: p.morph, minarea: p.min_area
+            };
+            document.getElementById("name").innerText = p.name;
+            var html = "";
+            sliders.forEach(function (s) {
+                html += '<div class="slider"><label>' + s.label + '</label>' +
+                    '<input type="range" id="' + s.id + '" min="0" max="' + s.max + '" value="' + values[s.id] + '" oninput="update()">' +
+                    '<span id="' + s.id + '-val">' + values[s.id] + '</span></div>';
+            });
+            document.getElementById("sliders").innerHTML = html;
+        });
+        setInterval(refresh, 500);
+    </script>
+</body>
+</html>
+`
@@ -0,0 +1,71 @@
+package detect
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&buf, img, nil))
+	return buf.Bytes()
+}
+
+func TestCalibrator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "calibrator")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	img := newImage(64, 48, color.RGBA{R: 90, G: 90, B: 200, A: 255})
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.jpg"), encodeJPEG(t, img), 0644))
+	src, err := OpenSource(dir)
+	assert.NoError(t, err)
+
+	file := filepath.Join(dir, "tracking.json")
+	c, err := NewCalibrator(src, file)
+	assert.NoError(t, err)
+	svr := httptest.NewServer(c)
+	defer svr.Close()
+
+	resp, err := http.Get(svr.URL + "/frame.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "image/jpeg", resp.Header.Get("Content-Type"))
+	resp, err = http.Get(svr.URL + "/mask.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	body := `{"lower": [100, 50, 50], "upper": [140, 255, 255], "min_area": 10, "blur": 2, "morph": 0}`
+	resp, err = http.Post(svr.URL+"/profile", "application/json", strings.NewReader(body))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = http.Post(svr.URL+"/save", "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	cfg, err := LoadColorConfig(file)
+	assert.NoError(t, err)
+	assert.Equal(t, [3]float64{100, 50, 50}, cfg.Profiles[0].Lower)
+	assert.Equal(t, 2, cfg.Blur)
+	det, err := NewColorDetector(cfg)
+	assert.NoError(t, err)
+	boxes, err := det.Detect(img)
+	assert.NoError(t, err)
+	assert.Len(t, boxes, 1)
+
+	// invalid profile
+	body = `{"lower": [100, 255, 50], "upper": [140, 0, 255]}`
+	resp, err = http.Post(svr.URL+"/profile", "application/json", strings.NewReader(body))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
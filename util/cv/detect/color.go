@@ -0,0 +1,186 @@
+package detect
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+)
+
+const (
+	defaultMaxWidth = 160
+	defaultMinArea  = 200
+)
+
+// HSVProfile is a color range in hsv to detect.
+// the hsv is in the space of OpenCV, see HSV.
+// if Lower.H > Upper.H, the hue range wraps around, e.g. from 170 to 10 for red.
+// MinArea is the min area in pixels in the original image.
+type HSVProfile struct {
+	Name    string     `json:"name"`
+	Lower   [3]float64 `json:"lower"`
+	Upper   [3]float64 `json:"upper"`
+	MinArea int        `json:"min_area"`
+}
+
+// ColorConfig is the config of ColorDetector
+type ColorConfig struct {
+	Profiles []*HSVProfile `json:"profiles"`
+	// MaxWidth is the width which the images are resized to before detecting
+	MaxWidth int `json:"max_width"`
+	// Morph is the radius of the erosion and dilation kernel, 0 for disabled
+	Morph int `json:"morph"`
+	// Blur is the radius of the box blur before thresholding, 0 for disabled
+	Blur int `json:"blur"`
+}
+
+// DefaultColorConfig is the hsv range of a tennis ball
+var DefaultColorConfig = &ColorConfig{
+	Profiles: []*HSVProfile{
+		{
+			Name:    "tennis",
+			Lower:   [3]float64{33, 108, 138},
+			Upper:   [3]float64{61, 255, 255},
+			MinArea: defaultMinArea,
+		},
+	},
+	MaxWidth: defaultMaxWidth,
+	Morph:    1,
+}
+
+// ColorDetector detects color blobs in pure go:
+// hsv thresholding, erosion & dilation, and connected-component labelling.
+type ColorDetector struct {
+	cfg *ColorConfig
+}
+
+func init() {
+	Register("color", func(cfgFile string) (Detector, error) {
+		cfg := DefaultColorConfig
+		if cfgFile != "" {
+			var err error
+			cfg, err = LoadColorConfig(cfgFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return NewColorDetector(cfg)
+	})
+}
+
+// LoadColorConfig loads the config from a json file like:
+//
+//	{
+//	    "profiles": [
+//	        {"name": "tennis", "lower": [33, 108, 138], "upper": [61, 255, 255], "min_area": 200},
+//	        {"name": "red", "lower": [170, 120, 70], "upper": [10, 255, 255], "min_area": 200}
+//	    ],
+//	    "max_width": 160,
+//	    "morph": 1,
+//	    "blur": 1
+//	}
+func LoadColorConfig(file string) (*ColorConfig, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var cfg ColorConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %v, error: %v", file, err)
+	}
+	return &cfg, nil
+}
+
+// Save saves the config to a json file
+func (cfg *ColorConfig) Save(file string) error {
+	data, err := json.MarshalIndent(cfg, "", "    ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+// NewColorDetector ...
+func NewColorDetector(cfg *ColorConfig) (*ColorDetector, error) {
+	if cfg == nil || len(cfg.Profiles) == 0 {
+		return nil, errors.New("no color profiles")
+	}
+	for _, p := range cfg.Profiles {
+		for i := 0; i < 3; i++ {
+			if i > 0 && p.Lower[i] > p.Upper[i] {
+				return nil, fmt.Errorf("profile %v: lower is greater than upper", p.Name)
+			}
+		}
+	}
+	return &ColorDetector{cfg: cfg}, nil
+}
+
+// Detect ...
+func (d *ColorDetector) Detect(img image.Image) ([]*Box, error) {
+	hsv, w, h, scale := d.hsv(img)
+	origin := img.Bounds().Min
+	total := float64(w * h)
+
+	var boxes []*Box
+	for _, p := range d.cfg.Profiles {
+		for _, c := range d.mask(hsv, w, h, p).Components() {
+			area := float64(c.Area) * scale * scale
+			if area < float64(p.MinArea) {
+				continue
+			}
+			boxes = append(boxes, &Box{
+				Rect:  ScaleRect(c.Bounds, scale, origin),
+				Score: float64(c.Area) / total,
+				Label: p.Name,
+			})
+		}
+	}
+	sortBoxes(boxes)
+	return boxes, nil
+}
+
+// Mask returns the mask of a profile in the resized image, it's useful for tuning a profile
+func (d *ColorDetector) Mask(img image.Image, p *HSVProfile) *Mask {
+	hsv, w, h, _ := d.hsv(img)
+	return d.mask(hsv, w, h, p)
+}
+
+// hsv resizes and blurs the image, and converts it to hsv
+func (d *ColorDetector) hsv(img image.Image) ([]HSV, int, int, float64) {
+	small, scale := Resize(img, d.cfg.MaxWidth)
+	small = BoxBlur(small, d.cfg.Blur)
+	w, h := small.Rect.Dx(), small.Rect.Dy()
+	hsv := make([]HSV, w*h)
+	for i := range hsv {
+		hsv[i] = ToHSV(small.At(i%w, i/w))
+	}
+	return hsv, w, h, scale
+}
+
+func (d *ColorDetector) mask(hsv []HSV, w, h int, p *HSVProfile) *Mask {
+	mask := Threshold(hsv, w, h, p)
+	if d.cfg.Morph > 0 {
+		mask = mask.Erode(d.cfg.Morph).Dilate(d.cfg.Morph)
+	}
+	return mask
+}
+
+// Threshold masks the pixels in the hsv range of the profile
+func Threshold(hsv []HSV, w, h int, p *HSVProfile) *Mask {
+	mask := NewMask(w, h)
+	for i, c := range hsv {
+		mask.Pix[i] = p.contains(c)
+	}
+	return mask
+}
+
+func (p *HSVProfile) contains(c HSV) bool {
+	if c.S < p.Lower[1] || c.S > p.Upper[1] || c.V < p.Lower[2] || c.V > p.Upper[2] {
+		return false
+	}
+	if p.Lower[0] <= p.Upper[0] {
+		return c.H >= p.Lower[0] && c.H <= p.Upper[0]
+	}
+	return c.H >= p.Lower[0] || c.H <= p.Upper[0]
+}
@@ -0,0 +1,176 @@
+/*
+Package detect detects targets like a colored ball or a fiducial marker in images.
+
+Detectors are registered by names, and can be picked at runtime:
+
+	det, err := detect.New("color", "detect.json")
+	boxes, err := det.Detect(img)
+
+The detectors in this package are in pure go, and work without OpenCV.
+Tracker reads the frames from a FrameSource, e.g. the mjpeg stream of motion:
+
+	src, err := detect.OpenSource("http://localhost:8081")
+	t, err := detect.NewTracker(src, det)
+	ok, rect := t.Locate()
+
+*/
+package detect
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"sort"
+	"sync"
+)
+
+// Box is a detected target with its bounding box in the image
+type Box struct {
+	Rect  image.Rectangle `json:"rect"`
+	Score float64         `json:"score"`
+	Label string          `json:"label"`
+}
+
+// Detector detects targets in an image.
+// the boxes are sorted by score from high to low.
+type Detector interface {
+	Detect(img image.Image) ([]*Box, error)
+}
+
+// Factory creates a detector from a config file,
+// the config file is optional and can be empty.
+type Factory func(cfgFile string) (Detector, error)
+
+var (
+	mu        sync.Mutex
+	factories = map[string]Factory{}
+)
+
+// Register registers a detector by name
+func Register(name string, f Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	factories[name] = f
+}
+
+// New creates a detector by name
+func New(name, cfgFile string) (Detector, error) {
+	mu.Lock()
+	f, ok := factories[name]
+	mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown detector: %v, available: %v", name, Names())
+	}
+	return f(cfgFile)
+}
+
+// Names returns the names of registered detectors
+func Names() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	var names []string
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortBoxes(boxes []*Box) {
+	sort.SliceStable(boxes, func(i, j int) bool {
+		return boxes[i].Score > boxes[j].Score
+	})
+}
+
+// FrameSource provides the frames from a camera
+type FrameSource interface {
+	Read() (image.Image, error)
+	Close() error
+}
+
+// Tracker locates the best target in the frames using a detector.
+// it has the same API as cv.Tracker, the bounding box is in a frame of Size
+// which the car's tracking logic is tuned for.
+type Tracker struct {
+	src  FrameSource
+	det  Detector
+	size image.Point
+	flip bool
+
+	mu   sync.Mutex
+	last *Box
+}
+
+// NewTracker ...
+func NewTracker(src FrameSource, det Detector) (*Tracker, error) {
+	if src == nil {
+		return nil, errors.New("frame source is nil")
+	}
+	if det == nil {
+		return nil, errors.New("detector is nil")
+	}
+	return &Tracker{
+		src:  src,
+		det:  det,
+		size: image.Point{X: 600, Y: 600},
+		flip: true,
+	}, nil
+}
+
+// Locate ...
+func (t *Tracker) Locate() (bool, *image.Rectangle) {
+	img, err := t.src.Read()
+	if err != nil {
+		return false, nil
+	}
+	boxes, err := t.det.Detect(img)
+	if err != nil || len(boxes) == 0 {
+		t.setLast(nil)
+		return false, nil
+	}
+	t.setLast(boxes[0])
+	r := t.scale(boxes[0].Rect, img.Bounds())
+	return true, &r
+}
+
+// Last returns the target found by the last Locate() in the coordinates of the frame,
+// or nil if nothing was found.
+func (t *Tracker) Last() *Box {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last
+}
+
+func (t *Tracker) setLast(b *Box) {
+	t.mu.Lock()
+	t.last = b
+	t.mu.Unlock()
+}
+
+// MiddleXY ...
+func (t *Tracker) MiddleXY(rect *image.Rectangle) (x int, y int) {
+	return (rect.Max.X-rect.Min.X)/2 + rect.Min.X, (rect.Max.Y-rect.Min.Y)/2 + rect.Min.Y
+}
+
+// Close ...
+func (t *Tracker) Close() {
+	t.src.Close()
+}
+
+// scale maps the rect in bounds to the frame of t.size, flipped horizontally like a mirror
+func (t *Tracker) scale(r, bounds image.Rectangle) image.Rectangle {
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return r
+	}
+	r = r.Sub(bounds.Min)
+	if t.flip {
+		r.Min.X, r.Max.X = w-r.Max.X, w-r.Min.X
+	}
+	return image.Rect(
+		r.Min.X*t.size.X/w,
+		r.Min.Y*t.size.Y/h,
+		r.Max.X*t.size.X/w,
+		r.Max.Y*t.size.Y/h,
+	)
+}
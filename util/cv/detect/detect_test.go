@@ -0,0 +1,140 @@
+package detect
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// drawMarker draws a 4x4 marker with a black border and a white quiet zone
+func drawMarker(img *image.RGBA, x0, y0, cell int, bits string) {
+	fill := func(x, y int, c color.Color) {
+		for yy := y0 + y*cell; yy < y0+(y+1)*cell; yy++ {
+			for xx := x0 + x*cell; xx < x0+(x+1)*cell; xx++ {
+				img.Set(xx, yy, c)
+			}
+		}
+	}
+	for y := 0; y < 6; y++ {
+		for x := 0; x < 6; x++ {
+			c := color.Color(color.Black)
+			if x > 0 && x < 5 && y > 0 && y < 5 && bits[(y-1)*4+x-1] == '1' {
+				c = color.White
+			}
+			fill(x, y, c)
+		}
+	}
+}
+
+func newImage(w, h int, c color.Color) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestMaskEdges(t *testing.T) {
+	m := NewMask(6, 6)
+	for y := 1; y < 5; y++ {
+		for x := 1; x < 5; x++ {
+			m.Set(x, y, true)
+		}
+	}
+	edges := m.Edges()
+	assert.Equal(t, 12, edges.Count())
+	assert.True(t, edges.At(1, 1))
+	assert.False(t, edges.At(2, 2))
+}
+
+func TestColorDetector(t *testing.T) {
+	img := newImage(320, 240, color.RGBA{R: 90, G: 90, B: 200, A: 255})
+	// a yellow-green ball
+	for y := 100; y < 160; y++ {
+		for x := 200; x < 260; x++ {
+			img.Set(x, y, color.RGBA{R: 180, G: 230, B: 60, A: 255})
+		}
+	}
+
+	det, err := New("color", "")
+	assert.NoError(t, err)
+	boxes, err := det.Detect(img)
+	assert.NoError(t, err)
+	assert.Len(t, boxes, 1)
+	assert.Equal(t, "tennis", boxes[0].Label)
+	assert.InDelta(t, 200, boxes[0].Rect.Min.X, 4)
+	assert.InDelta(t, 260, boxes[0].Rect.Max.X, 4)
+	assert.InDelta(t, 100, boxes[0].Rect.Min.Y, 4)
+	assert.InDelta(t, 160, boxes[0].Rect.Max.Y, 4)
+
+	// nothing in a blank image
+	boxes, err = det.Detect(newImage(320, 240, color.White))
+	assert.NoError(t, err)
+	assert.Len(t, boxes, 0)
+}
+
+func TestHSVProfileWrap(t *testing.T) {
+	red := &HSVProfile{Lower: [3]float64{170, 100, 100}, Upper: [3]float64{10, 255, 255}}
+	assert.True(t, red.contains(ToHSV(color.RGBA{R: 220, G: 30, B: 20, A: 255})))
+	assert.True(t, red.contains(ToHSV(color.RGBA{R: 220, G: 20, B: 40, A: 255})))
+	assert.False(t, red.contains(ToHSV(color.RGBA{R: 20, G: 220, B: 20, A: 255})))
+}
+
+func TestFiducialDetector(t *testing.T) {
+	bits := "1011010011101001"
+	det, err := NewFiducialDetector(&FiducialConfig{
+		Markers: []*Marker{
+			{ID: 7, Bits: bits},
+			{ID: 8, Bits: "0110100101101100"},
+		},
+		MaxHamming: 1,
+	})
+	assert.NoError(t, err)
+
+	img := newImage(320, 240, color.White)
+	drawMarker(img, 100, 60, 12, bits)
+	boxes, err := det.Detect(img)
+	assert.NoError(t, err)
+	assert.Len(t, boxes, 1)
+	assert.Equal(t, "7", boxes[0].Label)
+	assert.Equal(t, image.Rect(100, 60, 172, 132), boxes[0].Rect)
+
+	// rotated by 90 degree
+	img = newImage(320, 240, color.White)
+	drawMarker(img, 100, 60, 12, "1101011001011001")
+	boxes, err = det.Detect(img)
+	assert.NoError(t, err)
+	assert.Len(t, boxes, 1)
+	assert.Equal(t, "7", boxes[0].Label)
+
+	// unknown marker
+	img = newImage(320, 240, color.White)
+	drawMarker(img, 100, 60, 12, "0000000000000000")
+	boxes, err = det.Detect(img)
+	assert.NoError(t, err)
+	assert.Len(t, boxes, 0)
+}
+
+func TestRegistry(t *testing.T) {
+	assert.Contains(t, Names(), "color")
+	assert.Contains(t, Names(), "fiducial")
+
+	_, err := New("foo", "")
+	assert.Error(t, err)
+
+	dir, err := ioutil.TempDir("", "detect")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "markers.json")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`{"markers": [{"id": 1, "bits": "1011010011101001"}]}`), 0644))
+	det, err := New("fiducial", file)
+	assert.NoError(t, err)
+	assert.NotNil(t, det)
+}
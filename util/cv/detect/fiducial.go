@@ -0,0 +1,257 @@
+package detect
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Marker is a 4x4 fiducial marker like ArUco DICT_4X4 and AprilTag 16h5.
+// Bits are the 16 inner cells row by row from the top-left, '1' for white and '0' for black,
+// e.g. "1011010011101001". the marker is surrounded by a one-cell black border.
+type Marker struct {
+	ID   int    `json:"id"`
+	Bits string `json:"bits"`
+}
+
+// FiducialConfig is the config of FiducialDetector
+type FiducialConfig struct {
+	// Markers is the dictionary of the markers to detect
+	Markers []*Marker `json:"markers"`
+	// MaxHamming is the max number of wrong bits for a marker to be matched
+	MaxHamming int `json:"max_hamming"`
+	// MinSize is the min width in pixels of a marker
+	MinSize int `json:"min_size"`
+	// MaxWidth is the width which the images are resized to before detecting
+	MaxWidth int `json:"max_width"`
+}
+
+// FiducialDetector detects 4x4 fiducial markers in pure go.
+// it binarizes the image with otsu's threshold, and decodes the dark square blobs.
+// it works for the markers facing the camera, which is the common case for a car
+// looking for a marker on the wall, but not for the markers with a strong perspective.
+type FiducialDetector struct {
+	cfg   *FiducialConfig
+	codes map[uint16]*Marker
+}
+
+func init() {
+	Register("fiducial", func(cfgFile string) (Detector, error) {
+		if cfgFile == "" {
+			return nil, errors.New("fiducial detector needs a config file with the dictionary of markers")
+		}
+		cfg, err := LoadFiducialConfig(cfgFile)
+		if err != nil {
+			return nil, err
+		}
+		return NewFiducialDetector(cfg)
+	})
+}
+
+// LoadFiducialConfig loads the config from a json file like:
+//
+//	{
+//	    "markers": [
+//	        {"id": 0, "bits": "1011010011101001"},
+//	        {"id": 1, "bits": "0110100101101100"}
+//	    ],
+//	    "max_hamming": 1,
+//	    "min_size": 16,
+//	    "max_width": 320
+//	}
+func LoadFiducialConfig(file string) (*FiducialConfig, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var cfg FiducialConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %v, error: %v", file, err)
+	}
+	return &cfg, nil
+}
+
+// NewFiducialDetector ...
+func NewFiducialDetector(cfg *FiducialConfig) (*FiducialDetector, error) {
+	if cfg == nil || len(cfg.Markers) == 0 {
+		return nil, errors.New("no markers in the dictionary")
+	}
+	d := &FiducialDetector{
+		cfg:   cfg,
+		codes: map[uint16]*Marker{},
+	}
+	for _, m := range cfg.Markers {
+		code, err := parseBits(m.Bits)
+		if err != nil {
+			return nil, fmt.Errorf("marker %v: %v", m.ID, err)
+		}
+		// all 4 rotations of a marker
+		for i := 0; i < 4; i++ {
+			d.codes[code] = m
+			code = rotate(code)
+		}
+	}
+	return d, nil
+}
+
+// Detect ...
+func (d *FiducialDetector) Detect(img image.Image) ([]*Box, error) {
+	small, scale := Resize(img, d.cfg.MaxWidth)
+	w, h := small.Rect.Dx(), small.Rect.Dy()
+	gray := make([]uint8, w*h)
+	for i := range gray {
+		gray[i] = color.GrayModel.Convert(small.At(i%w, i/w)).(color.Gray).Y
+	}
+	t := otsu(gray)
+	dark := NewMask(w, h)
+	for i, g := range gray {
+		dark.Pix[i] = g <= t
+	}
+
+	var boxes []*Box
+	for _, c := range dark.Components() {
+		bw, bh := c.Bounds.Dx(), c.Bounds.Dy()
+		if float64(bw)*scale < float64(d.cfg.MinSize) || bw < 6 || bh < 6 {
+			continue
+		}
+		if r := float64(bw) / float64(bh); r < 0.7 || r > 1.4 {
+			continue
+		}
+		code, ok := d.read(dark, c.Bounds)
+		if !ok {
+			continue
+		}
+		m, dist := d.match(code)
+		if m == nil {
+			continue
+		}
+		boxes = append(boxes, &Box{
+			Rect:  ScaleRect(c.Bounds, scale, img.Bounds().Min),
+			Score: 1 - float64(dist)/16,
+			Label: strconv.Itoa(m.ID),
+		})
+	}
+	sortBoxes(boxes)
+	return boxes, nil
+}
+
+// read samples the 6x6 cells in the bounds, the border cells must be black
+func (d *FiducialDetector) read(dark *Mask, b image.Rectangle) (uint16, bool) {
+	cell := func(cx, cy int) bool {
+		// the majority of the center area of the cell
+		x0 := b.Min.X + (2*cx+1)*b.Dx()/12
+		y0 := b.Min.Y + (2*cy+1)*b.Dy()/12
+		r := b.Dx() / 24
+		n, black := 0, 0
+		for y := y0 - r; y <= y0+r; y++ {
+			for x := x0 - r; x <= x0+r; x++ {
+				n++
+				if dark.At(x, y) {
+					black++
+				}
+			}
+		}
+		return black*2 > n
+	}
+
+	for i := 0; i < 6; i++ {
+		if !cell(i, 0) || !cell(i, 5) || !cell(0, i) || !cell(5, i) {
+			return 0, false
+		}
+	}
+	var code uint16
+	for y := 1; y <= 4; y++ {
+		for x := 1; x <= 4; x++ {
+			code <<= 1
+			if !cell(x, y) {
+				code |= 1
+			}
+		}
+	}
+	return code, true
+}
+
+func (d *FiducialDetector) match(code uint16) (*Marker, int) {
+	if m, ok := d.codes[code]; ok {
+		return m, 0
+	}
+	var best *Marker
+	bestDist := d.cfg.MaxHamming + 1
+	for c, m := range d.codes {
+		if dist := bits.OnesCount16(c ^ code); dist < bestDist {
+			best = m
+			bestDist = dist
+		}
+	}
+	return best, bestDist
+}
+
+func parseBits(s string) (uint16, error) {
+	s = strings.Replace(s, " ", "", -1)
+	if len(s) != 16 {
+		return 0, fmt.Errorf("bits must have 16 cells, got %v", len(s))
+	}
+	v, err := strconv.ParseUint(s, 2, 16)
+	if err != nil {
+		return 0, err
+	}
+	return uint16(v), nil
+}
+
+// rotate rotates the 4x4 code by 90 degree clockwise
+func rotate(code uint16) uint16 {
+	var r uint16
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			bit := (code >> uint(15-(y*4+x))) & 1
+			// (x, y) -> (3-y, x)
+			nx, ny := 3-y, x
+			r |= bit << uint(15-(ny*4+nx))
+		}
+	}
+	return r
+}
+
+// otsu returns the threshold which best separates the dark and bright pixels
+func otsu(gray []uint8) uint8 {
+	var hist [256]int
+	for _, g := range gray {
+		hist[g]++
+	}
+	total := len(gray)
+	sum := 0.0
+	for i, n := range hist {
+		sum += float64(i * n)
+	}
+	var (
+		sumB   float64
+		wB     int
+		best   float64
+		thresh uint8
+	)
+	for i, n := range hist {
+		wB += n
+		if wB == 0 {
+			continue
+		}
+		wF := total - wB
+		if wF == 0 {
+			break
+		}
+		sumB += float64(i * n)
+		mB := sumB / float64(wB)
+		mF := (sum - sumB) / float64(wF)
+		between := float64(wB) * float64(wF) * (mB - mF) * (mB - mF)
+		if between > best {
+			best = between
+			thresh = uint8(i)
+		}
+	}
+	return thresh
+}
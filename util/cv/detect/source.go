@@ -0,0 +1,11 @@
+package detect
+
+import (
+	"github.com/shanghuiyang/rpi-devices/dev"
+)
+
+// OpenSource opens a frame source by a url of mjpeg stream like "http://localhost:8081",
+// a video device like "/dev/video0", or a directory of jpeg images, see dev.OpenCamera().
+func OpenSource(src string) (FrameSource, error) {
+	return dev.OpenCamera(src, nil)
+}
@@ -0,0 +1,260 @@
+/*
+Package motion detects motions in the frames of a camera in pure go,
+it's an in-process replacement of the motion daemon.
+
+A frame is compared with the background, a running average of the previous frames.
+The pixels which differ from the background more than a threshold are grouped into blobs,
+and a motion is detected if any blob is larger than the min area.
+An event is emitted when a motion starts, and another one when it ends.
+
+	d := motion.NewDetector(src, motion.DefaultConfig)
+	go d.Run(ctx)
+	for e := range d.Events() {
+		...
+	}
+
+*/
+package motion
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"log"
+	"time"
+
+	"github.com/shanghuiyang/rpi-devices/util/cv/detect"
+)
+
+const (
+	chSize = 16
+)
+
+// Config ...
+type Config struct {
+	// Threshold is the min difference of a pixel from the background in gray levels, 0~255
+	Threshold int `json:"threshold"`
+	// MinArea is the min area in pixels of a moving blob in the original frame
+	MinArea int `json:"min_area"`
+	// Masks are the areas ignored, e.g. a tv or a window, in the coordinates of the original frame
+	Masks []image.Rectangle `json:"masks"`
+	// MaxWidth is the width which the frames are resized to before detecting
+	MaxWidth int `json:"max_width"`
+	// Blur is the radius of the box blur, it reduces the noise of the camera
+	Blur int `json:"blur"`
+	// LearnRate is how fast the background adapts to the frames, 0~1
+	LearnRate float64 `json:"learn_rate"`
+	// Interval is the interval of reading the frames
+	Interval time.Duration `json:"interval"`
+	// Gap is how long the frames have no motion before a motion ends
+	Gap time.Duration `json:"gap"`
+}
+
+// DefaultConfig ...
+var DefaultConfig = &Config{
+	Threshold: 25,
+	MinArea:   500,
+	MaxWidth:  320,
+	Blur:      1,
+	LearnRate: 0.05,
+	Interval:  200 * time.Millisecond,
+	Gap:       5 * time.Second,
+}
+
+// EventType ...
+type EventType string
+
+const (
+	// Start is the event when a motion starts
+	Start EventType = "start"
+	// End is the event when a motion ends
+	End EventType = "end"
+)
+
+// Event is a motion event.
+// the snapshot is the frame when the motion starts for a Start event,
+// and the frame with the largest motion for an End event.
+type Event struct {
+	Type     EventType
+	Time     time.Time
+	Boxes    []image.Rectangle
+	Snapshot []byte
+}
+
+// Detector detects the motions in the frames from a frame source
+type Detector struct {
+	src    detect.FrameSource
+	cfg    *Config
+	bg     []float64
+	size   image.Point
+	events chan *Event
+
+	// the current motion
+	moving    bool
+	lastSeen  time.Time
+	maxArea   int
+	bestImg   image.Image
+	bestBoxes []image.Rectangle
+}
+
+// NewDetector ...
+func NewDetector(src detect.FrameSource, cfg *Config) *Detector {
+	if cfg == nil {
+		cfg = DefaultConfig
+	}
+	return &Detector{
+		src:    src,
+		cfg:    cfg,
+		events: make(chan *Event, chSize),
+	}
+}
+
+// Events returns the channel of the motion events, it's closed when Run() returns
+func (d *Detector) Events() <-chan *Event {
+	return d.events
+}
+
+// Run reads and detects the frames until ctx is done
+func (d *Detector) Run(ctx context.Context) error {
+	defer close(d.events)
+	ticker := time.NewTicker(d.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if d.moving {
+				d.end(time.Now())
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		img, err := d.src.Read()
+		if err != nil {
+			log.Printf("[motion]failed to read a frame, error: %v", err)
+			continue
+		}
+		d.Feed(img, time.Now())
+	}
+}
+
+// Feed detects a frame taken at t, and emits the events.
+// it's called by Run(), and is useful for feeding the frames by yourself.
+func (d *Detector) Feed(img image.Image, t time.Time) {
+	boxes, area := d.Detect(img)
+	if len(boxes) == 0 {
+		if d.moving && t.Sub(d.lastSeen) >= d.cfg.Gap {
+			d.end(t)
+		}
+		return
+	}
+
+	d.lastSeen = t
+	if !d.moving {
+		d.moving = true
+		d.maxArea = 0
+		d.emit(&Event{
+			Type:     Start,
+			Time:     t,
+			Boxes:    boxes,
+			Snapshot: encode(img),
+		})
+	}
+	if area > d.maxArea {
+		d.maxArea = area
+		d.bestImg = img
+		d.bestBoxes = boxes
+	}
+}
+
+func (d *Detector) end(t time.Time) {
+	d.moving = false
+	d.emit(&Event{
+		Type:     End,
+		Time:     t,
+		Boxes:    d.bestBoxes,
+		Snapshot: encode(d.bestImg),
+	})
+	d.bestImg, d.bestBoxes = nil, nil
+}
+
+// emit drops the event if nobody is receiving the events
+func (d *Detector) emit(e *Event) {
+	select {
+	case d.events <- e:
+	default:
+		log.Printf("[motion]event channel is full, drop the %v event", e.Type)
+	}
+}
+
+// Detect returns the bounding boxes of the moving blobs and their total area in the frame,
+// and updates the background with the frame.
+func (d *Detector) Detect(img image.Image) ([]image.Rectangle, int) {
+	small, scale := detect.Resize(img, d.cfg.MaxWidth)
+	small = detect.BoxBlur(small, d.cfg.Blur)
+	w, h := small.Rect.Dx(), small.Rect.Dy()
+	gray := make([]float64, w*h)
+	for i := range gray {
+		gray[i] = float64(color.GrayModel.Convert(small.At(i%w, i/w)).(color.Gray).Y)
+	}
+
+	if d.bg == nil || d.size != small.Rect.Size() {
+		// the first frame, or the resolution changed
+		d.bg = gray
+		d.size = small.Rect.Size()
+		return nil, 0
+	}
+
+	mask := detect.NewMask(w, h)
+	for i, g := range gray {
+		diff := g - d.bg[i]
+		if diff < 0 {
+			diff = -diff
+		}
+		mask.Pix[i] = diff > float64(d.cfg.Threshold)
+		d.bg[i] += d.cfg.LearnRate * (g - d.bg[i])
+	}
+	origin := img.Bounds().Min
+	for _, m := range d.cfg.Masks {
+		r := image.Rect(
+			int(float64(m.Min.X-origin.X)/scale),
+			int(float64(m.Min.Y-origin.Y)/scale),
+			int(float64(m.Max.X-origin.X)/scale+0.5),
+			int(float64(m.Max.Y-origin.Y)/scale+0.5),
+		).Intersect(small.Rect)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				mask.Set(x, y, false)
+			}
+		}
+	}
+	// remove the noise of single pixels
+	mask = mask.Erode(1).Dilate(1)
+
+	var (
+		boxes []image.Rectangle
+		total int
+	)
+	for _, c := range mask.Components() {
+		area := int(float64(c.Area) * scale * scale)
+		if area < d.cfg.MinArea {
+			continue
+		}
+		boxes = append(boxes, detect.ScaleRect(c.Bounds, scale, origin))
+		total += area
+	}
+	return boxes, total
+}
+
+func encode(img image.Image) []byte {
+	if img == nil {
+		return nil
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		log.Printf("[motion]failed to encode the snapshot, error: %v", err)
+		return nil
+	}
+	return buf.Bytes()
+}
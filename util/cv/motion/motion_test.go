@@ -0,0 +1,75 @@
+package motion
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// frame draws a white square at x on a gray background
+func frame(x int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 320, 240))
+	for y := 0; y < 240; y++ {
+		for xx := 0; xx < 320; xx++ {
+			c := color.RGBA{R: 80, G: 80, B: 80, A: 255}
+			if x >= 0 && xx >= x && xx < x+40 && y >= 100 && y < 140 {
+				c = color.RGBA{R: 240, G: 240, B: 240, A: 255}
+			}
+			img.Set(xx, y, c)
+		}
+	}
+	return img
+}
+
+func TestDetect(t *testing.T) {
+	d := NewDetector(nil, DefaultConfig)
+	boxes, _ := d.Detect(frame(-1))
+	assert.Len(t, boxes, 0)
+	boxes, _ = d.Detect(frame(-1))
+	assert.Len(t, boxes, 0)
+
+	boxes, area := d.Detect(frame(100))
+	assert.Len(t, boxes, 1)
+	assert.InDelta(t, 1600, area, 200)
+	assert.InDelta(t, 100, boxes[0].Min.X, 3)
+	assert.InDelta(t, 140, boxes[0].Max.X, 3)
+
+	// masked
+	d = NewDetector(nil, &Config{
+		Threshold: 25,
+		MinArea:   500,
+		MaxWidth:  160,
+		Masks:     []image.Rectangle{image.Rect(80, 80, 160, 160)},
+		LearnRate: 0.05,
+	})
+	d.Detect(frame(-1))
+	boxes, _ = d.Detect(frame(100))
+	assert.Len(t, boxes, 0)
+	boxes, _ = d.Detect(frame(200))
+	assert.Len(t, boxes, 1)
+}
+
+func TestEvents(t *testing.T) {
+	cfg := *DefaultConfig
+	cfg.Gap = 2 * time.Second
+	d := NewDetector(nil, &cfg)
+	t0 := time.Now()
+	d.Feed(frame(-1), t0)
+	d.Feed(frame(50), t0.Add(1*time.Second))
+	d.Feed(frame(150), t0.Add(2*time.Second))
+	d.Feed(frame(-1), t0.Add(3*time.Second))
+	assert.Len(t, d.Events(), 1)
+	d.Feed(frame(-1), t0.Add(5*time.Second))
+	assert.Len(t, d.Events(), 2)
+
+	e := <-d.Events()
+	assert.Equal(t, Start, e.Type)
+	assert.Equal(t, t0.Add(1*time.Second), e.Time)
+	assert.NotEmpty(t, e.Snapshot)
+	e = <-d.Events()
+	assert.Equal(t, End, e.Type)
+	assert.NotEmpty(t, e.Snapshot)
+}
@@ -1,51 +1,53 @@
 package cv
 
 import (
+	"context"
 	"log"
-	"net/http"
 
-	"github.com/hybridgroup/mjpeg"
+	"github.com/shanghuiyang/rpi-devices/util/cv/stream"
 	"gocv.io/x/gocv"
 )
 
-// Stream ...
+// Stream streams the frames of a camera over http, see package stream
 type Stream struct {
 	host   string
-	cam    *gocv.VideoCapture
-	stream *mjpeg.Stream
+	cam    *Camera
+	cfg    *stream.Config
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // NewStream ...
 func NewStream(cam *gocv.VideoCapture, host string) *Stream {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Stream{
-		host:   host,
-		cam:    cam,
-		stream: mjpeg.NewStream(),
+		host: host,
+		cam: &Camera{
+			cam: cam,
+			img: gocv.NewMat(),
+		},
+		cfg:    &stream.Config{},
+		ctx:    ctx,
+		cancel: cancel,
 	}
 }
 
-// Start ...
-func (s *Stream) Start() {
-	go func() {
-		http.Handle("/video", s.stream)
-		if err := http.ListenAndServe(s.host, nil); err != nil {
-			log.Printf("[stream]failed to listen and serve, err: %v", err)
-			return
-		}
-	}()
+// NewStreamWithConfig creates a stream with the frame rate, jpeg quality and overlays in cfg
+func NewStreamWithConfig(cam *gocv.VideoCapture, host string, cfg *stream.Config) *Stream {
+	s := NewStream(cam, host)
+	s.cfg = cfg
+	return s
+}
 
-	img := gocv.NewMat()
-	defer img.Close()
-	for true {
-		s.cam.Grab(6)
-		if !s.cam.Read(&img) {
-			continue
-		}
-		buf, err := gocv.IMEncode(".jpg", img)
-		if err != nil {
-			log.Printf("[stream]failed to push image, err: %v", err)
-			continue
-		}
-		s.stream.UpdateJPEG(buf)
+// Start serves the stream until Stop() is called
+func (s *Stream) Start() {
+	defer s.cam.img.Close()
+	if err := stream.ListenAndServe(s.ctx, s.host, s.cam, s.cfg); err != nil {
+		log.Printf("[stream]failed to listen and serve, err: %v", err)
 	}
 }
+
+// Stop stops the stream, the camera isn't closed
+func (s *Stream) Stop() {
+	s.cancel()
+}
@@ -0,0 +1,141 @@
+package stream
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"time"
+)
+
+const (
+	// glyphW and glyphH are the size of a glyph of the font in pixels
+	glyphW = 5
+	glyphH = 7
+	// textScale scales the glyphs, 2 means every pixel of a glyph is drawn as 2x2 pixels
+	textScale  = 2
+	textMargin = 4
+)
+
+var (
+	textColor = color.RGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}
+	textBg    = color.RGBA{A: 0x80}
+	boxColor  = color.RGBA{R: 0xFF, A: 0xFF}
+)
+
+// Overlay draws something onto a frame
+type Overlay func(img *image.RGBA)
+
+// Timestamp draws the current time on the top-left of the frame
+func Timestamp() Overlay {
+	return func(img *image.RGBA) {
+		text := time.Now().Format("2006-01-02 15:04:05")
+		DrawText(img, img.Rect.Min.Add(image.Point{X: textMargin, Y: textMargin}), text)
+	}
+}
+
+// Boxes draws the bounding boxes, e.g. the target found by the tracker
+func Boxes(boxes func() []image.Rectangle) Overlay {
+	return func(img *image.RGBA) {
+		for _, b := range boxes() {
+			DrawRect(img, b, boxColor, 2)
+		}
+	}
+}
+
+// Text draws the text on the bottom-left of the frame, e.g. the telemetry of the car
+func Text(text func() string) Overlay {
+	return func(img *image.RGBA) {
+		lines := strings.Split(text(), "\n")
+		y := img.Rect.Max.Y - textMargin - len(lines)*(glyphH+2)*textScale
+		for _, line := range lines {
+			DrawText(img, image.Point{X: img.Rect.Min.X + textMargin, Y: y}, line)
+			y += (glyphH + 2) * textScale
+		}
+	}
+}
+
+// DrawText draws a line of text with a translucent background at pt,
+// the letters are drawn in upper case, and the unknown characters are drawn as spaces.
+func DrawText(img *image.RGBA, pt image.Point, text string) {
+	text = strings.ToUpper(text)
+	w := len(text) * (glyphW + 1) * textScale
+	bg := image.Rect(pt.X-textScale, pt.Y-textScale, pt.X+w+textScale, pt.Y+(glyphH+1)*textScale)
+	draw.Draw(img, bg, image.NewUniform(textBg), image.Point{}, draw.Over)
+
+	for i, ch := range text {
+		g, ok := font[ch]
+		if !ok {
+			continue
+		}
+		x0 := pt.X + i*(glyphW+1)*textScale
+		for col := 0; col < glyphW; col++ {
+			for row := 0; row < glyphH; row++ {
+				if g[col]&(1<<uint(row)) == 0 {
+					continue
+				}
+				r := image.Rect(0, 0, textScale, textScale).Add(image.Point{X: x0 + col*textScale, Y: pt.Y + row*textScale})
+				draw.Draw(img, r, image.NewUniform(textColor), image.Point{}, draw.Src)
+			}
+		}
+	}
+}
+
+// DrawRect draws the outline of a rect with the thickness
+func DrawRect(img draw.Image, r image.Rectangle, c color.Color, thickness int) {
+	u := image.NewUniform(c)
+	t := thickness
+	draw.Draw(img, image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+t), u, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(r.Min.X, r.Max.Y-t, r.Max.X, r.Max.Y), u, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(r.Min.X, r.Min.Y, r.Min.X+t, r.Max.Y), u, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(r.Max.X-t, r.Min.Y, r.Max.X, r.Max.Y), u, image.Point{}, draw.Src)
+}
+
+// font is a 5x7 font, a glyph is 5 columns from left to right, and bit 0 is the top row
+var font = map[rune][glyphW]byte{
+	'0': {0x3E, 0x51, 0x49, 0x45, 0x3E},
+	'1': {0x00, 0x42, 0x7F, 0x40, 0x00},
+	'2': {0x42, 0x61, 0x51, 0x49, 0x46},
+	'3': {0x21, 0x41, 0x45, 0x4B, 0x31},
+	'4': {0x18, 0x14, 0x12, 0x7F, 0x10},
+	'5': {0x27, 0x45, 0x45, 0x45, 0x39},
+	'6': {0x3C, 0x4A, 0x49, 0x49, 0x30},
+	'7': {0x01, 0x71, 0x09, 0x05, 0x03},
+	'8': {0x36, 0x49, 0x49, 0x49, 0x36},
+	'9': {0x06, 0x49, 0x49, 0x29, 0x1E},
+	'A': {0x7E, 0x11, 0x11, 0x11, 0x7E},
+	'B': {0x7F, 0x49, 0x49, 0x49, 0x36},
+	'C': {0x3E, 0x41, 0x41, 0x41, 0x22},
+	'D': {0x7F, 0x41, 0x41, 0x22, 0x1C},
+	'E': {0x7F, 0x49, 0x49, 0x49, 0x41},
+	'F': {0x7F, 0x09, 0x09, 0x09, 0x01},
+	'G': {0x3E, 0x41, 0x49, 0x49, 0x7A},
+	'H': {0x7F, 0x08, 0x08, 0x08, 0x7F},
+	'I': {0x00, 0x41, 0x7F, 0x41, 0x00},
+	'J': {0x20, 0x40, 0x41, 0x3F, 0x01},
+	'K': {0x7F, 0x08, 0x14, 0x22, 0x41},
+	'L': {0x7F, 0x40, 0x40, 0x40, 0x40},
+	'M': {0x7F, 0x02, 0x0C, 0x02, 0x7F},
+	'N': {0x7F, 0x04, 0x08, 0x10, 0x7F},
+	'O': {0x3E, 0x41, 0x41, 0x41, 0x3E},
+	'P': {0x7F, 0x09, 0x09, 0x09, 0x06},
+	'Q': {0x3E, 0x41, 0x51, 0x21, 0x5E},
+	'R': {0x7F, 0x09, 0x19, 0x29, 0x46},
+	'S': {0x46, 0x49, 0x49, 0x49, 0x31},
+	'T': {0x01, 0x01, 0x7F, 0x01, 0x01},
+	'U': {0x3F, 0x40, 0x40, 0x40, 0x3F},
+	'V': {0x1F, 0x20, 0x40, 0x20, 0x1F},
+	'W': {0x3F, 0x40, 0x38, 0x40, 0x3F},
+	'X': {0x63, 0x14, 0x08, 0x14, 0x63},
+	'Y': {0x07, 0x08, 0x70, 0x08, 0x07},
+	'Z': {0x61, 0x51, 0x49, 0x45, 0x43},
+	':': {0x00, 0x36, 0x36, 0x00, 0x00},
+	'-': {0x08, 0x08, 0x08, 0x08, 0x08},
+	'.': {0x00, 0x60, 0x60, 0x00, 0x00},
+	',': {0x00, 0x50, 0x30, 0x00, 0x00},
+	'/': {0x20, 0x10, 0x08, 0x04, 0x02},
+	'=': {0x14, 0x14, 0x14, 0x14, 0x14},
+	'%': {0x23, 0x13, 0x08, 0x64, 0x62},
+	'(': {0x00, 0x1C, 0x22, 0x41, 0x00},
+	')': {0x00, 0x41, 0x22, 0x1C, 0x00},
+}
@@ -0,0 +1,276 @@
+/*
+Package stream serves the frames of a camera as a mjpeg stream over http.
+
+It serves:
+
+	/video          the mjpeg stream, for <img src="http://host:port/video">
+	/snapshot.jpg   the latest frame
+
+The frames are only captured when someone is watching. Every client has its own
+buffer of one frame, a slow client skips the frames instead of blocking the others.
+Overlays, e.g. the time, are drawn onto the frames before encoding.
+
+*/
+package stream
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/shanghuiyang/rpi-devices/util/cv/detect"
+)
+
+const (
+	boundary        = "frameboundary"
+	defaultFPS      = 10
+	defaultQuality  = 75
+	snapshotTimeout = 5 * time.Second
+)
+
+// Config ...
+type Config struct {
+	// FPS is the max frame rate
+	FPS int
+	// Quality is the quality of jpeg encoding, from 1 to 100
+	Quality int
+	// Overlays are drawn onto the frames in order
+	Overlays []Overlay
+}
+
+// Server is a mjpeg stream server
+type Server struct {
+	src      detect.FrameSource
+	interval time.Duration
+	quality  int
+	overlays []Overlay
+	mux      *http.ServeMux
+
+	mu      sync.Mutex
+	clients map[chan []byte]bool
+	last    []byte
+	lastAt  time.Time
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+// NewServer creates a server of the frames from src,
+// the server doesn't close the src, it's owned by the caller.
+func NewServer(src detect.FrameSource, cfg *Config) *Server {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+	fps := cfg.FPS
+	if fps <= 0 {
+		fps = defaultFPS
+	}
+	quality := cfg.Quality
+	if quality <= 0 || quality > 100 {
+		quality = defaultQuality
+	}
+	s := &Server{
+		src:      src,
+		interval: time.Second / time.Duration(fps),
+		quality:  quality,
+		overlays: cfg.Overlays,
+		mux:      http.NewServeMux(),
+		clients:  map[chan []byte]bool{},
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	s.mux.HandleFunc("/video", s.video)
+	s.mux.HandleFunc("/snapshot.jpg", s.snapshot)
+	return s
+}
+
+// ListenAndServe serves the stream on addr until ctx is done
+func ListenAndServe(ctx context.Context, addr string, src detect.FrameSource, cfg *Config) error {
+	s := NewServer(src, cfg)
+	svr := &http.Server{Addr: addr, Handler: s}
+	go func() {
+		<-ctx.Done()
+		svr.Close()
+	}()
+	go s.Run(ctx)
+	log.Printf("[stream]serving on %v", addr)
+	if err := svr.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// ServeHTTP ...
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Run captures the frames until ctx is done
+func (s *Server) Run(ctx context.Context) error {
+	defer close(s.done)
+	for {
+		if s.numClients() == 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-s.wake:
+			}
+			continue
+		}
+
+		start := time.Now()
+		wait := s.interval
+		if err := s.capture(); err != nil {
+			log.Printf("[stream]failed to capture a frame, error: %v", err)
+			wait = time.Second
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait - time.Since(start)):
+		}
+	}
+}
+
+// capture reads a frame, draws the overlays, encodes it and sends it to the clients
+func (s *Server) capture() error {
+	img, err := s.src.Read()
+	if err != nil {
+		return err
+	}
+	if len(s.overlays) > 0 {
+		rgba, ok := img.(*image.RGBA)
+		if !ok {
+			rgba = image.NewRGBA(img.Bounds())
+			draw.Draw(rgba, rgba.Rect, img, img.Bounds().Min, draw.Src)
+		}
+		for _, o := range s.overlays {
+			o(rgba)
+		}
+		img = rgba
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: s.quality}); err != nil {
+		return err
+	}
+	frame := buf.Bytes()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last = frame
+	s.lastAt = time.Now()
+	for ch := range s.clients {
+		// drop the stale frame if the client hasn't taken it
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- frame
+	}
+	return nil
+}
+
+func (s *Server) video(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming isn't supported", http.StatusInternalServerError)
+		return
+	}
+	ch := s.subscribe()
+	defer s.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+boundary)
+	w.Header().Set("Cache-Control", "no-cache")
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		case frame := <-ch:
+			_, err := fmt.Fprintf(w, "--%v\r\nContent-Type: image/jpeg\r\nContent-Length: %v\r\n\r\n", boundary, len(frame))
+			if err == nil {
+				_, err = w.Write(frame)
+			}
+			if err == nil {
+				_, err = w.Write([]byte("\r\n"))
+			}
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func (s *Server) snapshot(w http.ResponseWriter, r *http.Request) {
+	frame, err := s.latest(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(frame)
+}
+
+// latest returns the last frame if it's fresh, or waits for a new one
+func (s *Server) latest(ctx context.Context) ([]byte, error) {
+	select {
+	case <-s.done:
+		return nil, errors.New("server is stopped")
+	default:
+	}
+	s.mu.Lock()
+	if s.last != nil && time.Since(s.lastAt) < 2*s.interval {
+		frame := s.last
+		s.mu.Unlock()
+		return frame, nil
+	}
+	s.mu.Unlock()
+
+	ch := s.subscribe()
+	defer s.unsubscribe(ch)
+	select {
+	case frame := <-ch:
+		return frame, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.done:
+		return nil, errors.New("server is stopped")
+	case <-time.After(snapshotTimeout):
+		return nil, errors.New("timeout")
+	}
+}
+
+func (s *Server) subscribe() chan []byte {
+	ch := make(chan []byte, 1)
+	s.mu.Lock()
+	s.clients[ch] = true
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return ch
+}
+
+func (s *Server) unsubscribe(ch chan []byte) {
+	s.mu.Lock()
+	delete(s.clients, ch)
+	s.mu.Unlock()
+}
+
+func (s *Server) numClients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.clients)
+}
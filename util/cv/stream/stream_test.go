@@ -0,0 +1,94 @@
+package stream
+
+import (
+	"bufio"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSource struct {
+	reads int
+}
+
+func (s *fakeSource) Read() (image.Image, error) {
+	s.reads++
+	img := image.NewRGBA(image.Rect(0, 0, 160, 120))
+	for i := range img.Pix {
+		img.Pix[i] = 0x40
+	}
+	return img, nil
+}
+
+func (s *fakeSource) Close() error {
+	return nil
+}
+
+func TestServer(t *testing.T) {
+	src := &fakeSource{}
+	s := NewServer(src, &Config{
+		FPS:     20,
+		Quality: 90,
+		Overlays: []Overlay{
+			Timestamp(),
+			Boxes(func() []image.Rectangle {
+				return []image.Rectangle{image.Rect(40, 40, 80, 80)}
+			}),
+			Text(func() string { return "speed:30" }),
+		},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx) }()
+	svr := httptest.NewServer(s)
+	defer svr.Close()
+
+	// snapshot
+	resp, err := http.Get(svr.URL + "/snapshot.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	img, err := jpeg.Decode(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 160, 120), img.Bounds())
+	// the box is drawn in red
+	r, g, _, _ := img.At(60, 41).RGBA()
+	assert.True(t, r > 0xC000 && g < 0x4000)
+	r, _, _, _ = img.At(60, 60).RGBA()
+	assert.True(t, r < 0x8000)
+
+	// video
+	resp, err = http.Get(svr.URL + "/video")
+	assert.NoError(t, err)
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	mr := multipart.NewReader(bufio.NewReader(resp.Body), params["boundary"])
+	for i := 0; i < 3; i++ {
+		part, err := mr.NextPart()
+		assert.NoError(t, err)
+		_, err = jpeg.Decode(part)
+		assert.NoError(t, err)
+	}
+	resp.Body.Close()
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	resp, err = http.Get(svr.URL + "/snapshot.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestDrawText(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 30))
+	DrawText(img, image.Point{X: 2, Y: 2}, "1")
+	// the vertical stroke of "1"
+	assert.Equal(t, color.RGBA{R: 0xFF, G: 0xFF, B: 0xFF, A: 0xFF}, img.RGBAAt(2+2*textScale, 2+3*textScale))
+}
@@ -0,0 +1,358 @@
+/*
+Package metrics provides the gauges, counters and summaries of the sensors and the apps,
+and exposes them on /metrics in the text exposition format of prometheus.
+
+	temp := metrics.NewGauge("room_temperature_celsius", "The temperature of the room.", "room")
+	temp.Set(22.5, "bedroom")
+	http.Handle("/metrics", metrics.Handler())
+
+*/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	logTag = "metrics"
+
+	kindGauge   = "gauge"
+	kindCounter = "counter"
+	kindSummary = "summary"
+)
+
+var (
+	validName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+	// DefaultRegistry is the registry of the metrics created by NewGauge(), NewCounter() and NewSummary()
+	DefaultRegistry = NewRegistry()
+)
+
+// Registry is a set of metrics
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// family is a metric with all the series of its label values
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is a metric with the label values
+type series struct {
+	labelValues []string
+	value       float64
+	// sum and count are for a summary
+	sum   float64
+	count uint64
+}
+
+// Gauge is a value which can go up and down, e.g. a temperature
+type Gauge struct {
+	f *family
+}
+
+// Counter is a value which only goes up, e.g. the number of errors
+type Counter struct {
+	f *family
+}
+
+// Summary counts the observations and sums them up, e.g. the latencies
+type Summary struct {
+	f *family
+}
+
+// NewRegistry ...
+func NewRegistry() *Registry {
+	return &Registry{
+		families: map[string]*family{},
+	}
+}
+
+// NewGauge creates a gauge in the registry, or returns the existing one with the same name
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{f: r.register(name, help, kindGauge, labels)}
+}
+
+// NewCounter creates a counter in the registry, or returns the existing one with the same name
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{f: r.register(name, help, kindCounter, labels)}
+}
+
+// NewSummary creates a summary in the registry, or returns the existing one with the same name
+func (r *Registry) NewSummary(name, help string, labels ...string) *Summary {
+	return &Summary{f: r.register(name, help, kindSummary, labels)}
+}
+
+// NewGauge creates a gauge in the default registry
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return DefaultRegistry.NewGauge(name, help, labels...)
+}
+
+// NewCounter creates a counter in the default registry
+func NewCounter(name, help string, labels ...string) *Counter {
+	return DefaultRegistry.NewCounter(name, help, labels...)
+}
+
+// NewSummary creates a summary in the default registry
+func NewSummary(name, help string, labels ...string) *Summary {
+	return DefaultRegistry.NewSummary(name, help, labels...)
+}
+
+// Handler returns the http handler of the default registry
+func Handler() http.Handler {
+	return DefaultRegistry
+}
+
+// ListenAndServe serves the default registry on /metrics, it blocks like http.ListenAndServe()
+func ListenAndServe(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", DefaultRegistry)
+	log.Printf("[%v]serving on %v/metrics", logTag, addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// register panics if the name or the labels are invalid, or the name is used by another kind of metric,
+// they're the bugs like an invalid regexp in regexp.MustCompile().
+func (r *Registry) register(name, help, kind string, labels []string) *family {
+	if !validName.MatchString(name) {
+		panic(fmt.Sprintf("metrics: invalid name %q", name))
+	}
+	for _, l := range labels {
+		if !validName.MatchString(l) || strings.Contains(l, ":") {
+			panic(fmt.Sprintf("metrics: invalid label %q of %v", l, name))
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.kind != kind || len(f.labels) != len(labels) {
+			panic(fmt.Sprintf("metrics: %v has been registered as a %v with %v labels", name, f.kind, len(f.labels)))
+		}
+		return f
+	}
+	f := &family{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: map[string]*series{},
+	}
+	r.families[name] = f
+	return f
+}
+
+// update updates the series of the label values with fn, f.mu is held in fn
+func (f *family) update(labelValues []string, fn func(s *series)) {
+	if len(labelValues) != len(f.labels) {
+		log.Printf("[%v]%v has %v labels, but got %v values", logTag, f.name, len(f.labels), len(labelValues))
+		return
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		f.series[key] = s
+	}
+	fn(s)
+}
+
+func (f *family) get(labelValues []string) *series {
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		return &series{}
+	}
+	cp := *s
+	return &cp
+}
+
+// Set ...
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.f.update(labelValues, func(s *series) {
+		s.value = v
+	})
+}
+
+// Add adds v to the gauge, v can be negative
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.f.update(labelValues, func(s *series) {
+		s.value += v
+	})
+}
+
+// SetBool sets 1 for true and 0 for false
+func (g *Gauge) SetBool(b bool, labelValues ...string) {
+	v := 0.0
+	if b {
+		v = 1
+	}
+	g.Set(v, labelValues...)
+}
+
+// SetToCurrentTime sets the current unix time in seconds
+func (g *Gauge) SetToCurrentTime(labelValues ...string) {
+	g.Set(float64(time.Now().UnixNano())/1e9, labelValues...)
+}
+
+// Value ...
+func (g *Gauge) Value(labelValues ...string) float64 {
+	return g.f.get(labelValues).value
+}
+
+// Inc ...
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter, a negative v is ignored
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		return
+	}
+	c.f.update(labelValues, func(s *series) {
+		s.value += v
+	})
+}
+
+// Value ...
+func (c *Counter) Value(labelValues ...string) float64 {
+	return c.f.get(labelValues).value
+}
+
+// Observe ...
+func (s *Summary) Observe(v float64, labelValues ...string) {
+	s.f.update(labelValues, func(s *series) {
+		s.sum += v
+		s.count++
+	})
+}
+
+// ObserveDuration observes the seconds since the start
+func (s *Summary) ObserveDuration(start time.Time, labelValues ...string) {
+	s.Observe(time.Since(start).Seconds(), labelValues...)
+}
+
+// Count returns the number of the observations
+func (s *Summary) Count(labelValues ...string) uint64 {
+	return s.f.get(labelValues).count
+}
+
+// Sum returns the sum of the observations
+func (s *Summary) Sum(labelValues ...string) float64 {
+	return s.f.get(labelValues).sum
+}
+
+// ServeHTTP serves the metrics in the text exposition format
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := r.Write(w); err != nil {
+		log.Printf("[%v]failed to write the metrics, error: %v", logTag, err)
+	}
+}
+
+// Write writes the metrics in the text exposition format, sorted by the names and the label values
+func (r *Registry) Write(out io.Writer) error {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	w := bufio.NewWriter(out)
+	for _, f := range families {
+		f.write(w)
+	}
+	return w.Flush()
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.series) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	if f.help != "" {
+		fmt.Fprintf(w, "# HELP %v %v\n", f.name, escapeHelp(f.help))
+	}
+	fmt.Fprintf(w, "# TYPE %v %v\n", f.name, f.kind)
+	for _, k := range keys {
+		s := f.series[k]
+		labels := f.formatLabels(s.labelValues)
+		if f.kind == kindSummary {
+			fmt.Fprintf(w, "%v_sum%v %v\n", f.name, labels, formatValue(s.sum))
+			fmt.Fprintf(w, "%v_count%v %v\n", f.name, labels, s.count)
+			continue
+		}
+		fmt.Fprintf(w, "%v%v %v\n", f.name, labels, formatValue(s.value))
+	}
+}
+
+func (f *family) formatLabels(values []string) string {
+	if len(f.labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(f.labels))
+	for i, l := range f.labels {
+		pairs[i] = fmt.Sprintf("%v=\"%v\"", l, escapeLabel(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+	r := NewRegistry()
+	temp := r.NewGauge("temperature_celsius", "The temperature.\nIn celsius.", "room")
+	errs := r.NewCounter("errors_total", "", "sensor")
+	latency := r.NewSummary("read_duration_seconds", "The latency.")
+	r.NewGauge("unused", "No series.")
+
+	temp.Set(22.5, "bed\"room")
+	temp.Set(math.Inf(1), "kitchen")
+	temp.Add(-0.5, "bed\"room")
+	errs.Inc("ds18b20")
+	errs.Add(2, "ds18b20")
+	errs.Add(-1, "ds18b20")
+	latency.Observe(0.25)
+	latency.Observe(0.5)
+	// the label values don't match the labels
+	temp.Set(1)
+
+	assert.Equal(t, 22.0, temp.Value("bed\"room"))
+	assert.Equal(t, 3.0, errs.Value("ds18b20"))
+	assert.Equal(t, uint64(2), latency.Count())
+
+	var buf bytes.Buffer
+	assert.NoError(t, r.Write(&buf))
+	expected := `# TYPE errors_total counter
+errors_total{sensor="ds18b20"} 3
+# HELP read_duration_seconds The latency.
+# TYPE read_duration_seconds summary
+read_duration_seconds_sum 0.75
+read_duration_seconds_count 2
+# HELP temperature_celsius The temperature.\nIn celsius.
+# TYPE temperature_celsius gauge
+temperature_celsius{room="bed\"room"} 22
+temperature_celsius{room="kitchen"} +Inf
+`
+	assert.Equal(t, expected, buf.String())
+}
+
+func TestRegister(t *testing.T) {
+	r := NewRegistry()
+	g := r.NewGauge("value", "", "device")
+	g.Set(1, "a")
+	// the same metric is returned
+	assert.Equal(t, 1.0, r.NewGauge("value", "", "device").Value("a"))
+	assert.Panics(t, func() { r.NewCounter("value", "", "device") })
+	assert.Panics(t, func() { r.NewGauge("invalid-name", "") })
+}
+
+func TestHandler(t *testing.T) {
+	ObserveRead("fake", time.Now(), nil)
+	SetReading("fake", "temperature", 21)
+	SetState("relay", 7, 1)
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	assert.Contains(t, body, `sensor_value{sensor="fake",reading="temperature"} 21`)
+	assert.Contains(t, body, `sensor_read_duration_seconds_count{sensor="fake"} 1`)
+	assert.Contains(t, body, `actuator_state{actuator="relay",pin="7"} 1`)
+	assert.NotContains(t, body, "sensor_errors_total")
+}
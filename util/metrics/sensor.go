@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"time"
+)
+
+// the common metrics of the sensors and the actuators
+var (
+	sensorValue = NewGauge("sensor_value",
+		"The last reading of a sensor.", "sensor", "reading")
+	sensorErrors = NewCounter("sensor_errors_total",
+		"The number of the failed readings of a sensor.", "sensor")
+	sensorLatency = NewSummary("sensor_read_duration_seconds",
+		"The latency of reading a sensor.", "sensor")
+	actuatorState = NewGauge("actuator_state",
+		"The state of an actuator, 1 is on and 0 is off, or the angle of a servo.", "actuator", "pin")
+)
+
+// ObserveRead records the latency of reading a sensor since the start, and counts the error if it isn't nil
+func ObserveRead(sensor string, start time.Time, err error) {
+	sensorLatency.ObserveDuration(start, sensor)
+	if err != nil {
+		sensorErrors.Inc(sensor)
+	}
+}
+
+// SetReading sets the last reading of a sensor, e.g. SetReading("pms7003", "pm2.5", 35)
+func SetReading(sensor, reading string, v float64) {
+	sensorValue.Set(v, sensor, reading)
+}
+
+// SetState sets the state of an actuator on a pin
+func SetState(actuator string, pin uint8, v float64) {
+	actuatorState.Set(v, actuator, formatValue(float64(pin)))
+}
@@ -0,0 +1,130 @@
+package motion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeMotion is a stand-in of the webcontrol of motion in text mode
+type fakeMotion struct {
+	detecting bool
+	connected bool
+	events    []string
+	params    map[string]string
+}
+
+func (f *fakeMotion) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	cam, cmd := parts[0], parts[1]
+	switch cmd {
+	case "detection/status":
+		status := "PAUSE"
+		if f.detecting {
+			status = "ACTIVE"
+		}
+		fmt.Fprintf(w, "Camera %v Detection status %v \n", cam, status)
+	case "detection/connection":
+		status := "Lost"
+		if f.connected {
+			status = "OK"
+		}
+		fmt.Fprintf(w, "Camera %v Connection %v\n", cam, status)
+	case "detection/pause":
+		f.detecting = false
+		fmt.Fprintf(w, "Camera %v Detection paused\nDone \n", cam)
+	case "detection/start":
+		f.detecting = true
+		fmt.Fprintf(w, "Camera %v Detection resumed\nDone \n", cam)
+	case "action/snapshot", "action/eventstart", "action/eventend":
+		f.events = append(f.events, cmd)
+		fmt.Fprintf(w, "Camera %v\nDone \n", cam)
+	case "config/get":
+		name := r.URL.Query().Get("query")
+		v, ok := f.params[name]
+		if !ok {
+			fmt.Fprintf(w, "Camera %v\nUnknown parameter\n", cam)
+			return
+		}
+		fmt.Fprintf(w, "Camera %v\n%v = %v \nDone\n", cam, name, v)
+	case "config/set":
+		for name, vs := range r.URL.Query() {
+			if _, ok := f.params[name]; !ok {
+				fmt.Fprintf(w, "Camera %v\nUnknown parameter\n", cam)
+				return
+			}
+			f.params[name] = vs[0]
+			fmt.Fprintf(w, "Camera %v\n%v = %v\nDone\n", cam, name, vs[0])
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestClient(t *testing.T) {
+	f := &fakeMotion{
+		detecting: true,
+		connected: true,
+		params:    map[string]string{"threshold": "1500"},
+	}
+	svr := httptest.NewServer(f)
+	defer svr.Close()
+	c := NewClient(svr.URL + "/")
+
+	s, err := c.Status(0)
+	assert.NoError(t, err)
+	assert.True(t, s.Detecting)
+	assert.True(t, s.Connected)
+
+	assert.NoError(t, c.Pause(0))
+	s, err = c.Status(0)
+	assert.NoError(t, err)
+	assert.False(t, s.Detecting)
+	assert.NoError(t, c.Resume(0))
+	assert.True(t, f.detecting)
+
+	assert.NoError(t, c.Snapshot(1))
+	assert.NoError(t, c.StartEvent(1))
+	assert.NoError(t, c.EndEvent(1))
+	assert.Equal(t, []string{"action/snapshot", "action/eventstart", "action/eventend"}, f.events)
+
+	v, err := c.Get(0, "threshold")
+	assert.NoError(t, err)
+	assert.Equal(t, "1500", v)
+	assert.NoError(t, c.Set(0, "threshold", "3000"))
+	assert.Equal(t, "3000", f.params["threshold"])
+
+	_, err = c.Get(0, "nosuchparam")
+	assert.True(t, errors.Is(err, ErrUnknownParam))
+	err = c.Set(0, "nosuchparam", "1")
+	assert.True(t, errors.Is(err, ErrUnknownParam))
+
+	// not supported by the stand-in
+	assert.Error(t, c.Restart(0))
+}
+
+func TestClientErrors(t *testing.T) {
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<!DOCTYPE html><html></html>"))
+	}))
+	c := NewClient(svr.URL)
+	assert.Error(t, c.Pause(0))
+	svr.Close()
+
+	// the server is down
+	assert.Error(t, c.Pause(0))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Error(t, c.WaitReady(ctx, 0))
+}
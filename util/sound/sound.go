@@ -0,0 +1,288 @@
+/*
+Package sound analyzes the sound level of the audio, e.g. the wav recorded by util.Record().
+
+The audio is split into short windows, and the level of every window is computed in dBFS,
+0 dB is the loudest and -96 dB is the silence of 16-bit audio.
+A crying-like sound is a sustained sound: most of the windows in a span of several seconds
+are louder than a threshold, while a single slam of a door isn't.
+
+	wav, err := sound.ReadWavFile("record.wav")
+	levels := sound.Levels(wav, sound.DefaultConfig.Window)
+	result := sound.Analyze(levels, sound.DefaultConfig)
+
+*/
+package sound
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"time"
+)
+
+const (
+	// Silence is the level of the silence in dBFS
+	Silence = -96.0
+)
+
+// Config ...
+type Config struct {
+	// Window is the length of a window
+	Window time.Duration `json:"window"`
+	// Threshold is the level of a loud window in dBFS
+	Threshold float64 `json:"threshold"`
+	// Sustain is the min length of a crying-like sound
+	Sustain time.Duration `json:"sustain"`
+	// Ratio is the min ratio of the loud windows in a sustained sound, 0~1,
+	// it allows the short pauses of crying for breath.
+	Ratio float64 `json:"ratio"`
+}
+
+// DefaultConfig ...
+var DefaultConfig = &Config{
+	Window:    100 * time.Millisecond,
+	Threshold: -30,
+	Sustain:   3 * time.Second,
+	Ratio:     0.7,
+}
+
+// Result is the result of analyzing the levels
+type Result struct {
+	// Level is the average level in dBFS
+	Level float64 `json:"level"`
+	// Peak is the level of the loudest window in dBFS
+	Peak float64 `json:"peak"`
+	// Loud is the ratio of the loud windows
+	Loud float64 `json:"loud"`
+	// Crying is true if a sustained sound is detected
+	Crying bool `json:"crying"`
+}
+
+// Wav is a mono pcm audio, the samples are in [-1, 1]
+type Wav struct {
+	SampleRate int
+	Samples    []float64
+}
+
+// Duration ...
+func (w *Wav) Duration() time.Duration {
+	if w.SampleRate == 0 {
+		return 0
+	}
+	return time.Duration(len(w.Samples)) * time.Second / time.Duration(w.SampleRate)
+}
+
+// ReadWavFile ...
+func ReadWavFile(file string) (*Wav, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadWav(bufio.NewReader(f))
+}
+
+// ReadWav reads a 8-bit or 16-bit pcm wav, the channels are mixed into mono
+func ReadWav(r io.Reader) (*Wav, error) {
+	var riff struct {
+		ID     [4]byte
+		Size   uint32
+		Format [4]byte
+	}
+	if err := binary.Read(r, binary.LittleEndian, &riff); err != nil {
+		return nil, err
+	}
+	if string(riff.ID[:]) != "RIFF" || string(riff.Format[:]) != "WAVE" {
+		return nil, errors.New("not a wav file")
+	}
+
+	var format struct {
+		AudioFormat   uint16
+		Channels      uint16
+		SampleRate    uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+	}
+	hasFormat := false
+	for {
+		var chunk struct {
+			ID   [4]byte
+			Size uint32
+		}
+		if err := binary.Read(r, binary.LittleEndian, &chunk); err != nil {
+			return nil, fmt.Errorf("no data chunk: %v", err)
+		}
+		switch string(chunk.ID[:]) {
+		case "fmt ":
+			if err := binary.Read(r, binary.LittleEndian, &format); err != nil {
+				return nil, err
+			}
+			if _, err := io.CopyN(ioutil.Discard, r, int64(chunk.Size)-16); err != nil {
+				return nil, err
+			}
+			hasFormat = true
+		case "data":
+			if !hasFormat {
+				return nil, errors.New("no fmt chunk before data chunk")
+			}
+			return readSamples(io.LimitReader(r, int64(chunk.Size)), int(format.AudioFormat), int(format.Channels), int(format.SampleRate), int(format.BitsPerSample))
+		default:
+			if _, err := io.CopyN(ioutil.Discard, r, int64(chunk.Size+chunk.Size%2)); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func readSamples(r io.Reader, audioFormat, channels, sampleRate, bits int) (*Wav, error) {
+	if audioFormat != 1 {
+		return nil, fmt.Errorf("unsupported audio format: %v, only pcm is supported", audioFormat)
+	}
+	if bits != 8 && bits != 16 {
+		return nil, fmt.Errorf("unsupported bits per sample: %v", bits)
+	}
+	if channels <= 0 {
+		return nil, fmt.Errorf("invalid channels: %v", channels)
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	size := bits / 8
+	frame := size * channels
+	w := &Wav{
+		SampleRate: sampleRate,
+		Samples:    make([]float64, len(data)/frame),
+	}
+	for i := range w.Samples {
+		var sum float64
+		for c := 0; c < channels; c++ {
+			p := data[i*frame+c*size:]
+			if bits == 8 {
+				// 8-bit samples are unsigned
+				sum += (float64(p[0]) - 128) / 128
+			} else {
+				sum += float64(int16(binary.LittleEndian.Uint16(p))) / 32768
+			}
+		}
+		w.Samples[i] = sum / float64(channels)
+	}
+	return w, nil
+}
+
+// WriteWav writes a 16-bit mono pcm wav
+func WriteWav(out io.Writer, w *Wav) error {
+	size := uint32(len(w.Samples) * 2)
+	header := []interface{}{
+		[]byte("RIFF"), 36 + size, []byte("WAVE"),
+		[]byte("fmt "), uint32(16), uint16(1), uint16(1), uint32(w.SampleRate), uint32(w.SampleRate * 2), uint16(2), uint16(16),
+		[]byte("data"), size,
+	}
+	for _, v := range header {
+		if err := binary.Write(out, binary.LittleEndian, v); err != nil {
+			return err
+		}
+	}
+	samples := make([]int16, len(w.Samples))
+	for i, s := range w.Samples {
+		samples[i] = int16(math.Max(-1, math.Min(s, 32767.0/32768)) * 32768)
+	}
+	return binary.Write(out, binary.LittleEndian, samples)
+}
+
+// Levels returns the levels of the windows in dBFS
+func Levels(w *Wav, window time.Duration) []float64 {
+	n := int(time.Duration(w.SampleRate) * window / time.Second)
+	if n <= 0 {
+		return nil
+	}
+	var levels []float64
+	for i := 0; i+n <= len(w.Samples); i += n {
+		var sum float64
+		for _, s := range w.Samples[i : i+n] {
+			sum += s * s
+		}
+		levels = append(levels, DB(math.Sqrt(sum/float64(n))))
+	}
+	return levels
+}
+
+// DB converts a rms in [0, 1] to dBFS, e.g. a full scale square wave is 0 dB and a sine wave is -3 dB
+func DB(rms float64) float64 {
+	db := 20 * math.Log10(rms)
+	if math.IsInf(db, -1) || db < Silence {
+		return Silence
+	}
+	return db
+}
+
+// Analyze analyzes the levels of the windows
+func Analyze(levels []float64, cfg *Config) *Result {
+	return AnalyzeWithTail(nil, levels, cfg)
+}
+
+// AnalyzeWithTail analyzes the levels of a sample following the levels of the previous sample,
+// so that a sustained sound across the two samples is detected, e.g. 2 seconds in each.
+// only the end of the tail is used, and Level, Peak and Loud are of the levels only.
+func AnalyzeWithTail(tail, levels []float64, cfg *Config) *Result {
+	if cfg == nil {
+		cfg = DefaultConfig
+	}
+	r := &Result{Level: Silence, Peak: Silence}
+	if len(levels) == 0 {
+		return r
+	}
+	span := int(cfg.Sustain / cfg.Window)
+	// a sustained sound must end in the levels, or it has been detected in the previous sample
+	if n := span - 1; len(tail) > n {
+		tail = tail[len(tail)-n:]
+	}
+
+	var (
+		power float64
+		loud  []bool
+		nLoud int
+	)
+	for _, l := range tail {
+		loud = append(loud, l >= cfg.Threshold)
+	}
+	for _, l := range levels {
+		power += math.Pow(10, l/10)
+		if l > r.Peak {
+			r.Peak = l
+		}
+		isLoud := l >= cfg.Threshold
+		if isLoud {
+			nLoud++
+		}
+		loud = append(loud, isLoud)
+	}
+	r.Level = math.Max(10*math.Log10(power/float64(len(levels))), Silence)
+	r.Loud = float64(nLoud) / float64(len(levels))
+
+	// slide a span of Sustain over the windows
+	if span <= 0 || span > len(loud) {
+		return r
+	}
+	count := 0
+	for i, l := range loud {
+		if l {
+			count++
+		}
+		if i >= span && loud[i-span] {
+			count--
+		}
+		if i >= span-1 && float64(count) >= cfg.Ratio*float64(span) {
+			r.Crying = true
+			break
+		}
+	}
+	return r
+}
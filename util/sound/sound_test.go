@@ -0,0 +1,118 @@
+package sound
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const rate = 8000
+
+// synth synthesizes an audio, amp returns the amplitude of a 440Hz tone at t
+func synth(d time.Duration, amp func(t time.Duration) float64) *Wav {
+	n := int(d * rate / time.Second)
+	w := &Wav{SampleRate: rate, Samples: make([]float64, n)}
+	r := rand.New(rand.NewSource(1))
+	for i := range w.Samples {
+		t := time.Duration(i) * time.Second / rate
+		noise := (r.Float64() - 0.5) * 0.002
+		w.Samples[i] = amp(t)*math.Sin(2*math.Pi*440*float64(i)/rate) + noise
+	}
+	return w
+}
+
+// fixture writes the audio to a wav file, and reads it back
+func fixture(t *testing.T, dir, name string, w *Wav) *Wav {
+	file := filepath.Join(dir, name)
+	var buf bytes.Buffer
+	assert.NoError(t, WriteWav(&buf, w))
+	assert.NoError(t, ioutil.WriteFile(file, buf.Bytes(), 0644))
+	wav, err := ReadWavFile(file)
+	assert.NoError(t, err)
+	return wav
+}
+
+func TestAnalyze(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sound")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	quiet := fixture(t, dir, "quiet.wav", synth(5*time.Second, func(time.Duration) float64 { return 0 }))
+	assert.Equal(t, 5*time.Second, quiet.Duration())
+	r := Analyze(Levels(quiet, DefaultConfig.Window), DefaultConfig)
+	assert.False(t, r.Crying)
+	assert.Less(t, r.Peak, -50.0)
+
+	// a door slams for 0.3 second
+	slam := fixture(t, dir, "slam.wav", synth(5*time.Second, func(t time.Duration) float64 {
+		if t > 2*time.Second && t < 2300*time.Millisecond {
+			return 0.8
+		}
+		return 0
+	}))
+	r = Analyze(Levels(slam, DefaultConfig.Window), DefaultConfig)
+	assert.False(t, r.Crying)
+	assert.InDelta(t, -4.9, r.Peak, 0.5)
+
+	// crying for 4 seconds, with a pause of 0.2 second for breath every second
+	cry := fixture(t, dir, "cry.wav", synth(5*time.Second, func(t time.Duration) float64 {
+		if t < time.Second || t%time.Second > 800*time.Millisecond {
+			return 0
+		}
+		return 0.3
+	}))
+	r = Analyze(Levels(cry, DefaultConfig.Window), DefaultConfig)
+	assert.True(t, r.Crying)
+	assert.InDelta(t, 0.64, r.Loud, 0.05)
+	assert.Greater(t, r.Level, DefaultConfig.Threshold)
+}
+
+func TestAnalyzeWithTail(t *testing.T) {
+	// 2 seconds of crying at the end of a sample, and 2 seconds at the beginning of the next one
+	prev := make([]float64, 50)
+	next := make([]float64, 50)
+	for i := range prev {
+		prev[i], next[i] = -60, -60
+		if i >= 30 {
+			prev[i] = -10
+		}
+		if i < 20 {
+			next[i] = -10
+		}
+	}
+	assert.False(t, Analyze(prev, DefaultConfig).Crying)
+	assert.False(t, Analyze(next, DefaultConfig).Crying)
+	r := AnalyzeWithTail(prev, next, DefaultConfig)
+	assert.True(t, r.Crying)
+	assert.InDelta(t, 0.4, r.Loud, 0.001)
+
+	// only the end of the tail is used, the crying at its beginning has been detected in the previous sample
+	for i := range prev {
+		prev[i] = -60
+		if i < 25 {
+			prev[i] = -10
+		}
+		next[i] = -60
+	}
+	assert.True(t, Analyze(prev, DefaultConfig).Crying)
+	assert.False(t, AnalyzeWithTail(prev, next, DefaultConfig).Crying)
+}
+
+func TestReadWav(t *testing.T) {
+	_, err := ReadWav(bytes.NewReader([]byte("not a wav")))
+	assert.Error(t, err)
+
+	// 8-bit stereo
+	data := []byte("RIFF\x2c\x00\x00\x00WAVEfmt \x10\x00\x00\x00\x01\x00\x02\x00\x40\x1f\x00\x00\x80\x3e\x00\x00\x02\x00\x08\x00data\x04\x00\x00\x00\xc0\xc0\x40\x80")
+	w, err := ReadWav(bytes.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, 8000, w.SampleRate)
+	assert.Equal(t, []float64{0.5, -0.25}, w.Samples)
+}